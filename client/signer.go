@@ -0,0 +1,119 @@
+// Package client contains helpers for partners calling wallet api from their servers.
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of signed request.
+const (
+	KeyIDHeader     = "X-Signature-Key-Id" // id of API key the signing secret is issued for
+	TimestampHeader = "X-Signature-Timestamp"
+	NonceHeader     = "X-Signature-Nonce"
+	SignatureHeader = "X-Signature" // "sha256=" + hex of HMAC-SHA256 over StringToSign
+)
+
+const nonceLength = 16
+
+// StringToSign gives canonical representation of request covered by signature:
+// method, path with query, unix timestamp, nonce and hex of body sha256 joined by new lines.
+func StringToSign(method, requestURI, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		strings.ToUpper(method), requestURI, timestamp, nonce, hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// Signature of canonical request representation made with signing secret.
+func Signature(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(stringToSign))
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer signs requests with signing secret of API key.
+type Signer struct {
+	KeyID  string
+	Secret string
+	// clock used for timestamp, time.Now if nil
+	Now func() time.Time
+}
+
+func NewSigner(keyID, secret string) Signer {
+	return Signer{KeyID: keyID, Secret: secret}
+}
+
+func generateNonce() (string, error) {
+	buf := make([]byte, nonceLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Sign sets signature headers, body is read and replaced with the same content.
+// Each call gives new nonce, so retried request must be signed again.
+func (s Signer) Sign(r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		_ = r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	nonce, err := generateNonce()
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	r.Header.Set(KeyIDHeader, s.KeyID)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, Signature(s.Secret, StringToSign(r.Method, r.URL.RequestURI(), timestamp, nonce, body)))
+
+	return nil
+}
+
+// Transport signs each request before passing it to Base (http.DefaultTransport if nil).
+type Transport struct {
+	Signer Signer
+	Base   http.RoundTripper
+}
+
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context()) // round tripper must not modify request
+	if r.Body != nil && r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	if err := t.Signer.Sign(r); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(r)
+}
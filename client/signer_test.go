@@ -0,0 +1,31 @@
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	a := assert.New(t)
+	s := NewSigner("key", "secret")
+	s.Now = func() time.Time { return time.Unix(1600000000, 0) }
+	r, _ := http.NewRequest("POST", "http://wallet.local/transfers/?a=b", bytes.NewBufferString("{}"))
+
+	a.NoError(s.Sign(r))
+	body, _ := ioutil.ReadAll(r.Body)
+	a.Equal("{}", string(body), "body is kept")
+	a.Equal("key", r.Header.Get(KeyIDHeader))
+	a.Equal("1600000000", r.Header.Get(TimestampHeader))
+	nonce := r.Header.Get(NonceHeader)
+	a.Len(nonce, 2*nonceLength)
+	a.Equal(Signature("secret", StringToSign("POST", "/transfers/?a=b", "1600000000", nonce, []byte("{}"))),
+		r.Header.Get(SignatureHeader))
+
+	a.NoError(s.Sign(r))
+	a.NotEqual(nonce, r.Header.Get(NonceHeader), "each signature has own nonce")
+}
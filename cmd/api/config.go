@@ -2,31 +2,187 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/BurntSushi/toml"
 	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v3"
+
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/webhooks"
 )
 
+// EnvPrefix of env vars overriding config, e.g. WALLET_DB_RETRY_COUNT for -dbRetryCount.
+const EnvPrefix = "WALLET_"
+
+// secretFlags may carry credentials (DSNs or URLs with password), they are redacted in effective config.
+var secretFlags = map[string]bool{"db": true, "dbReplica": true, "outboxWebhookURL": true, "jwks": true}
+
 type Config struct {
-	port                 string
-	logLevel             level.Option
-	dbConnectionURL      string
-	shutdownTimeout      time.Duration
-	dbConnectRetryCount  uint
-	dbConnectRetryTimout time.Duration
+	port                  string
+	metricsPort           string
+	logLevel              level.Option
+	logLevelName          string
+	configFile            string
+	dbFile                string
+	dbConnectionURL       string
+	dbReplicaURL          string
+	dbDriver              string
+	dbMaxConns            int
+	dbIdleConns           int
+	dbConnMaxLifetime     time.Duration
+	dbStatementCache      int
+	dbStatementCacheMode  string
+	dbQueryTimeout        time.Duration
+	shutdownTimeout       time.Duration
+	drainDelay            time.Duration
+	dbConnectRetryCount   uint
+	dbConnectRetryTimout  time.Duration
+	mandatesInterval      time.Duration
+	reconcileInterval     time.Duration
+	outboxPublisher       string
+	outboxWebhookURL      string
+	outboxWebhookTimeout  time.Duration
+	outboxInterval        time.Duration
+	outboxRetention       time.Duration
+	webhooksInterval      time.Duration
+	webhooksTimeout       time.Duration
+	webhooksMaxAttempts   int
+	webhooksRetryDelay    time.Duration
+	webhooksMaxRetryDelay time.Duration
+	webhooksAllowedNets   string
+	activityPollInterval  time.Duration
+	activityGapTimeout    time.Duration
+	activityHeartbeat     time.Duration
+	jwks                  string
+	jwksRefreshInterval   time.Duration
+	jwtIssuer             string
+	jwtAudience           string
+	requestSigning        bool
+	signatureMaxSkew      time.Duration
+	nonceCache            string
+	rateLimiter           string
+	clientRateLimit       float64
+	clientRateBurst       int
+	senderRateLimit       float64
+	senderRateBurst       int
+	traceExporter         string
+	traceFile             string
+	traceSampleRatio      float64
+	readinessTimeout      time.Duration
+	autoMigrate           bool
+	currenciesCacheTTL    time.Duration
+	// flag name to value, secrets are redacted
+	effective map[string]string
+	// positional arguments left after flags
+	args []string
 }
 
-func NewConfig() Config {
+// NewConfig resolves each option in order: default, config file (-config, YAML or TOML by extension),
+// env var (see EnvName) and flag, later ones override former ones.
+func NewConfig(name string, args []string, getenv func(string) string) (Config, error) {
 	var c Config
-	flag.StringVar(&c.port, "port", "8080", "port")
-	flag.StringVar(&c.dbConnectionURL, "db", "", "db connections credentials")
-	flag.DurationVar(&c.shutdownTimeout, "shutdownTimeout", 10*time.Second, "graceful shutdown timeout")
-	flag.UintVar(&c.dbConnectRetryCount, "dbRetryCount", 10, "retry count for connecting to db")
-	flag.DurationVar(&c.dbConnectRetryTimout, "dbRetryTimeout", 2*time.Second, "retry timeout for connecting to db")
-	logLevel := flag.String("logLevel", "info", "debug|info|warn|error")
-	flag.Parse()
-	switch *logLevel {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.configFile, "config", "", "YAML or TOML file with options named as flags, overridden by env vars and flags")
+	fs.StringVar(&c.port, "port", "8080", "port")
+	fs.StringVar(&c.metricsPort, "metricsPort", "9090", "port of prometheus metrics")
+	fs.StringVar(&c.dbConnectionURL, "db", "", "db connections credentials")
+	fs.StringVar(&c.dbReplicaURL, "dbReplica", "",
+		"read-only db connections credentials for account lists and transfer history, they are read from db if empty")
+	fs.StringVar(&c.dbDriver, "dbDriver", migrations.Postgres,
+		"postgres|pgx|sqlite, db is a file path for sqlite, which serves accounts, transfers and currencies only")
+	fs.IntVar(&c.dbMaxConns, "dbMaxConns", 0, "max open db connections, 0 is unlimited")
+	fs.IntVar(&c.dbIdleConns, "dbIdleConns", 0,
+		"max idle db connections kept for reuse (0 is database/sql default of 2), as many are opened on start, "+
+			"they aren't reopened after closing by dbConnMaxLifetime")
+	fs.DurationVar(&c.dbConnMaxLifetime, "dbConnMaxLifetime", 0, "how long db connection is reused, 0 is forever")
+	fs.IntVar(&c.dbStatementCache, "dbStatementCache", 512, "prepared statements cached per connection by pgx, 0 disables cache")
+	fs.StringVar(&c.dbStatementCacheMode, "dbStatementCacheMode", "prepare",
+		"prepare|describe how pgx caches statements, describe works behind pgbouncer in transaction mode")
+	fs.DurationVar(&c.dbQueryTimeout, "dbQueryTimeout", 0, "statement_timeout of each query made via pgx, 0 is no timeout")
+	fs.DurationVar(&c.shutdownTimeout, "shutdownTimeout", 10*time.Second, "graceful shutdown timeout")
+	fs.DurationVar(&c.drainDelay, "drainDelay", 5*time.Second, "how long requests are still served after instance becomes not ready on shutdown")
+	fs.UintVar(&c.dbConnectRetryCount, "dbRetryCount", 10, "retry count for connecting to db")
+	fs.DurationVar(&c.dbConnectRetryTimout, "dbRetryTimeout", 2*time.Second, "retry timeout for connecting to db")
+	fs.DurationVar(&c.mandatesInterval, "mandatesInterval", time.Minute, "how often due mandates are executed")
+	fs.DurationVar(&c.reconcileInterval, "reconciliationInterval", time.Hour,
+		"how often account balances are reconciled with transfers, 0 disables reconciliation worker")
+	fs.StringVar(&c.outboxPublisher, "outboxPublisher", "none",
+		"none|stdout|webhook where outbox events are relayed besides webhook subscriptions")
+	fs.StringVar(&c.outboxWebhookURL, "outboxWebhookURL", "", "url events are posted to by webhook outbox publisher")
+	fs.DurationVar(&c.outboxWebhookTimeout, "outboxWebhookTimeout", 5*time.Second, "timeout of webhook outbox publisher")
+	fs.DurationVar(&c.outboxInterval, "outboxInterval", time.Second, "how often outbox is relayed")
+	fs.DurationVar(&c.outboxRetention, "outboxRetention", 7*24*time.Hour,
+		"how long published outbox events are kept, activity streams can be resumed within it, 0 is forever")
+	fs.DurationVar(&c.webhooksInterval, "webhooksInterval", time.Second, "how often due webhook deliveries are attempted")
+	fs.DurationVar(&c.webhooksTimeout, "webhooksTimeout", 10*time.Second, "timeout of single webhook delivery attempt")
+	fs.IntVar(&c.webhooksMaxAttempts, "webhooksMaxAttempts", 10, "attempts before webhook delivery is dead-lettered")
+	fs.DurationVar(&c.webhooksRetryDelay, "webhooksRetryDelay", 10*time.Second, "delay after first failed webhook delivery, doubled each next one")
+	fs.DurationVar(&c.webhooksMaxRetryDelay, "webhooksMaxRetryDelay", time.Hour, "max delay between webhook delivery attempts")
+	fs.StringVar(&c.webhooksAllowedNets, "webhooksAllowedNets", "",
+		"comma separated CIDRs webhooks may reach though they are internal (loopback, private, link-local ones are rejected otherwise)")
+	fs.DurationVar(&c.activityPollInterval, "activityPollInterval", time.Second, "how often outbox is polled for activity streams besides notifications")
+	fs.DurationVar(&c.activityGapTimeout, "activityGapTimeout", 5*time.Second, "how long activity streams wait for not yet committed events")
+	fs.DurationVar(&c.activityHeartbeat, "activityHeartbeat", 15*time.Second, "heartbeat interval of activity streams")
+	fs.StringVar(&c.jwks, "jwks", "", "url or file path of JWKS for bearer tokens validation, tokens aren't accepted if empty")
+	fs.DurationVar(&c.jwksRefreshInterval, "jwksRefreshInterval", 5*time.Minute, "how often JWKS is reloaded")
+	fs.StringVar(&c.jwtIssuer, "jwtIssuer", "", "required issuer of bearer tokens")
+	fs.StringVar(&c.jwtAudience, "jwtAudience", "", "required audience of bearer tokens")
+	fs.BoolVar(&c.requestSigning, "requestSigning", true, "accept requests signed with signing secret of API key")
+	fs.DurationVar(&c.signatureMaxSkew, "signatureMaxSkew", 5*time.Minute, "max difference between timestamp of signed request and server time")
+	fs.StringVar(&c.nonceCache, "nonceCache", "postgres", "memory|postgres where nonces of signed requests are kept, memory one protects single replica only")
+	fs.StringVar(&c.rateLimiter, "rateLimiter", "postgres", "memory|postgres where rate limit buckets are kept, memory one limits single replica only")
+	fs.Float64Var(&c.clientRateLimit, "clientRateLimit", 0, "transfers per second each API client may create, 0 is unlimited")
+	fs.IntVar(&c.clientRateBurst, "clientRateBurst", 20, "transfers API client may create at once over clientRateLimit")
+	fs.Float64Var(&c.senderRateLimit, "senderRateLimit", 0, "transfers per second from each account, 0 is unlimited")
+	fs.IntVar(&c.senderRateBurst, "senderRateBurst", 5, "transfers from account at once over senderRateLimit")
+	fs.StringVar(&c.traceExporter, "traceExporter", "none", "none|stdout|file where spans are exported")
+	fs.StringVar(&c.traceFile, "traceFile", "traces.json", "file spans are appended to by file trace exporter, as line of OTLP JSON per batch")
+	fs.Float64Var(&c.traceSampleRatio, "traceSampleRatio", 1, "ratio of traces sampled unless parent is sampled already")
+	fs.BoolVar(&c.autoMigrate, "autoMigrate", false, "apply pending migrations on start, don't use with several replicas starting at once")
+	fs.DurationVar(&c.readinessTimeout, "readinessTimeout", 2*time.Second, "timeout of readiness checks")
+	fs.DurationVar(&c.currenciesCacheTTL, "currenciesCacheTTL", time.Minute,
+		"how long currencies are cached, cache is invalidated on changes by DB notifications as well, 0 disables cache")
+	fs.StringVar(&c.dbFile, "dbFile", "", "file with db connections credentials (e.g. mounted secret), alternative to -db")
+	fs.StringVar(&c.logLevelName, "logLevel", "info", "debug|info|warn|error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n  %s [flags]\n  %s %s [flags] up|down|status\n", name, name, name, MigrateCommand)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "Each option may be set by env var %s<OPTION_IN_SNAKE_CASE>, e.g. %s\n",
+			EnvPrefix, EnvName("dbRetryCount"))
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	c.args = fs.Args()
+	if err := c.layer(fs, getenv); err != nil {
+		return c, err
+	}
+	if c.dbFile != "" && c.dbConnectionURL != "" {
+		return c, errors.New("invalid config: only one of db and dbFile may be set")
+	}
+	if c.dbFile != "" {
+		dsn, err := ioutil.ReadFile(c.dbFile)
+		if err != nil {
+			return c, fmt.Errorf("dbFile: %w", err)
+		}
+		c.dbConnectionURL = strings.TrimSpace(string(dsn))
+	}
+	if err := c.validate(); err != nil {
+		return c, err
+	}
+	switch c.logLevelName {
 	case "error":
 		c.logLevel = level.AllowError()
 	case "debug":
@@ -36,5 +192,230 @@ func NewConfig() Config {
 	default:
 		c.logLevel = level.AllowInfo()
 	}
-	return c
+	c.effective = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if secretFlags[f.Name] {
+			v = redactDSN(v)
+		}
+		c.effective[f.Name] = v
+	})
+	if c.dbFile != "" {
+		c.effective["db"] = redactDSN(c.dbConnectionURL)
+	}
+
+	return c, nil
+}
+
+// EnvName of env var for option, e.g. WALLET_DB_RETRY_COUNT for dbRetryCount.
+func EnvName(option string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range option {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// layer sets options that aren't set by flags from env vars and then from config file.
+func (c *Config) layer(fs *flag.FlagSet, getenv func(string) string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["config"] {
+		if v := getenv(EnvName("config")); v != "" {
+			c.configFile = v
+		}
+	}
+	file, err := readConfigFile(c.configFile)
+	if err != nil {
+		return err
+	}
+	for name := range file {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("config %s: unknown option %s", c.configFile, name)
+		}
+	}
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" {
+			return
+		}
+		source, value := EnvName(f.Name), getenv(EnvName(f.Name))
+		if value == "" {
+			var ok bool
+			if value, ok = file[f.Name]; !ok {
+				return
+			}
+			source = c.configFile + " " + f.Name
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value %q for %s: %s", source, value, f.Name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// readConfigFile gives values of options by their names, empty path gives no options.
+func readConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config %s: unsupported format %s, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("config %s: option %s must be scalar", path, k)
+		case float64:
+			values[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			values[k] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+
+	return err == nil && p > 0 && p < 65536
+}
+
+func (c Config) validate() error {
+	var errs []string
+	check := func(ok bool, msg string) {
+		if !ok {
+			errs = append(errs, msg)
+		}
+	}
+	check(c.dbConnectionURL != "", "db or dbFile is required")
+	check(validPort(c.port), "port must be number in 1-65535")
+	check(validPort(c.metricsPort), "metricsPort must be number in 1-65535")
+	check(c.port != c.metricsPort, "metricsPort must differ from port")
+	check(oneOf(c.logLevelName, "debug", "info", "warn", "error"), "logLevel must be debug|info|warn|error")
+	check(oneOf(c.outboxPublisher, "none", "stdout", "webhook"), "outboxPublisher must be none|stdout|webhook")
+	check(c.outboxPublisher != "webhook" || c.outboxWebhookURL != "", "outboxWebhookURL is required for webhook outbox publisher")
+	check(oneOf(c.nonceCache, "memory", "postgres"), "nonceCache must be memory|postgres")
+	check(oneOf(c.dbDriver, migrations.Postgres, migrations.Pgx, migrations.SQLite), "dbDriver must be postgres|pgx|sqlite")
+	check(c.dbMaxConns >= 0, "dbMaxConns must not be negative")
+	check(c.dbIdleConns >= 0, "dbIdleConns must not be negative")
+	check(c.dbMaxConns == 0 || c.dbIdleConns <= c.dbMaxConns, "dbIdleConns must not exceed dbMaxConns")
+	check(c.dbStatementCache >= 0, "dbStatementCache must not be negative")
+	check(oneOf(c.dbStatementCacheMode, "prepare", "describe"), "dbStatementCacheMode must be prepare|describe")
+	check(c.dbDriver != migrations.SQLite || c.nonceCache == "memory" || !c.requestSigning,
+		"nonceCache must be memory with sqlite dbDriver")
+	check(oneOf(c.rateLimiter, "memory", "postgres"), "rateLimiter must be memory|postgres")
+	rateLimited := c.clientRateLimit > 0 || c.senderRateLimit > 0
+	check(c.dbDriver != migrations.SQLite || c.rateLimiter == "memory" || !rateLimited, "rateLimiter must be memory with sqlite dbDriver")
+	check(c.clientRateLimit >= 0, "clientRateLimit must not be negative")
+	check(c.senderRateLimit >= 0, "senderRateLimit must not be negative")
+	check(c.clientRateBurst > 0, "clientRateBurst must be positive")
+	check(c.senderRateBurst > 0, "senderRateBurst must be positive")
+	check(c.dbDriver != migrations.SQLite || c.dbReplicaURL == "", "dbReplica isn't supported with sqlite dbDriver")
+	check(oneOf(c.traceExporter, "none", "stdout", "file"), "traceExporter must be none|stdout|file")
+	check(c.traceSampleRatio >= 0 && c.traceSampleRatio <= 1, "traceSampleRatio must be in 0-1")
+	check(c.webhooksMaxAttempts > 0, "webhooksMaxAttempts must be positive")
+	_, err := webhooks.ParseNets(c.webhooksAllowedNets)
+	check(err == nil, "webhooksAllowedNets must be comma separated CIDRs")
+	check(c.jwks != "" || (c.jwtIssuer == "" && c.jwtAudience == ""), "jwtIssuer and jwtAudience require jwks")
+	for name, d := range map[string]time.Duration{
+		"shutdownTimeout":      c.shutdownTimeout,
+		"dbRetryTimeout":       c.dbConnectRetryTimout,
+		"mandatesInterval":     c.mandatesInterval,
+		"outboxWebhookTimeout": c.outboxWebhookTimeout,
+		"outboxInterval":       c.outboxInterval,
+		"webhooksInterval":     c.webhooksInterval,
+		"webhooksTimeout":      c.webhooksTimeout,
+		"webhooksRetryDelay":   c.webhooksRetryDelay,
+		"activityPollInterval": c.activityPollInterval,
+		"activityHeartbeat":    c.activityHeartbeat,
+		"jwksRefreshInterval":  c.jwksRefreshInterval,
+		"signatureMaxSkew":     c.signatureMaxSkew,
+		"readinessTimeout":     c.readinessTimeout,
+	} {
+		check(d > 0, name+" must be positive")
+	}
+	check(c.drainDelay >= 0, "drainDelay must not be negative")
+	check(c.activityGapTimeout >= 0, "activityGapTimeout must not be negative")
+	check(c.currenciesCacheTTL >= 0, "currenciesCacheTTL must not be negative")
+	check(c.outboxRetention >= 0, "outboxRetention must not be negative")
+	check(c.reconcileInterval >= 0, "reconciliationInterval must not be negative")
+	check(c.dbConnMaxLifetime >= 0, "dbConnMaxLifetime must not be negative")
+	check(c.dbQueryTimeout >= 0, "dbQueryTimeout must not be negative")
+	check(c.webhooksMaxRetryDelay >= c.webhooksRetryDelay, "webhooksMaxRetryDelay must not be less than webhooksRetryDelay")
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+
+	return errors.New("invalid config: " + strings.Join(errs, "; "))
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactDSN hides password of both URL and key=value connection strings.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+		}
+		q := u.Query()
+		if q.Get("password") != "" {
+			q.Set("password", "redacted")
+			u.RawQuery = q.Encode()
+		}
+
+		return u.String()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}redacted")
+}
+
+// Effective values of options as key-value pairs sorted by name for logging, secrets are redacted.
+func (c Config) Effective() []interface{} {
+	names := make([]string, 0, len(c.effective))
+	for name := range c.effective {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kv := make([]interface{}, 0, 2*len(names))
+	for _, name := range names {
+		kv = append(kv, name, c.effective[name])
+	}
+
+	return kv
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+
+	"github.com/risentveber/wallet-api/migrations"
+)
+
+// openDB gives pool of dsn (db or dbReplica) configured by options,
+// statement cache and query timeout are applied to pgx only.
+func openDB(c Config, dsn string) (*sql.DB, error) {
+	var db *sql.DB
+	if c.dbDriver == migrations.Pgx {
+		config, err := pgxConfig(c, dsn)
+		if err != nil {
+			return nil, err
+		}
+		db = stdlib.OpenDB(*config)
+	} else {
+		var err error
+		if db, err = migrations.Open(c.dbDriver, dsn); err != nil {
+			return nil, err
+		}
+	}
+	db.SetMaxOpenConns(c.dbMaxConns)
+	if c.dbIdleConns > 0 {
+		db.SetMaxIdleConns(c.dbIdleConns)
+	}
+	db.SetConnMaxLifetime(c.dbConnMaxLifetime)
+
+	return db, nil
+}
+
+func pgxConfig(c Config, dsn string) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config.BuildStatementCache = nil
+	if c.dbStatementCache > 0 {
+		mode := stmtcache.ModePrepare
+		if c.dbStatementCacheMode == "describe" {
+			mode = stmtcache.ModeDescribe
+		}
+		capacity := c.dbStatementCache
+		config.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			return stmtcache.New(conn, mode, capacity)
+		}
+	}
+	if c.dbQueryTimeout > 0 {
+		// server cancels statement, so it doesn't keep locks after client gave up
+		config.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.dbQueryTimeout.Milliseconds(), 10)
+	}
+
+	return config, nil
+}
+
+// warmUp opens dbIdleConns connections, so first requests don't wait for them. They are kept idle
+// by database/sql till dbConnMaxLifetime, it doesn't reopen closed ones, so it isn't min size of pool.
+func warmUp(ctx context.Context, db *sql.DB, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		if err = conn.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
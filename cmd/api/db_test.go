@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgxConfig(t *testing.T) {
+	a := assert.New(t)
+	c, err := NewConfig("api", []string{"-db", "host=db user=wallet", "-dbDriver", "pgx"}, env(nil))
+	a.NoError(err)
+	config, err := pgxConfig(c, c.dbConnectionURL)
+	a.NoError(err)
+	a.Equal("db", config.Host)
+	a.NotNil(config.BuildStatementCache, "statements are cached by default")
+	a.NotContains(config.RuntimeParams, "statement_timeout")
+
+	c.dbStatementCache, c.dbStatementCacheMode, c.dbQueryTimeout = 16, "describe", 1500*time.Millisecond
+	config, err = pgxConfig(c, c.dbConnectionURL)
+	a.NoError(err)
+	a.Equal(stmtcache.ModeDescribe, config.BuildStatementCache(nil).Mode())
+	a.Equal(16, config.BuildStatementCache(nil).Cap())
+	a.Equal("1500", config.RuntimeParams["statement_timeout"])
+
+	c.dbStatementCache = 0
+	config, err = pgxConfig(c, c.dbConnectionURL)
+	a.NoError(err)
+	a.Nil(config.BuildStatementCache)
+}
+
+func TestOpenDB(t *testing.T) {
+	a := assert.New(t)
+	c, err := NewConfig("api", []string{"-db", "host=db", "-dbMaxConns", "8", "-dbIdleConns", "4"}, env(nil))
+	a.NoError(err)
+	db, err := openDB(c, c.dbConnectionURL)
+	a.NoError(err)
+	defer db.Close()
+	a.Equal(8, db.Stats().MaxOpenConnections)
+}
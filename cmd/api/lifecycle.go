@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/run"
+
+	"github.com/risentveber/wallet-api/services/health"
+)
+
+// shutdownSignals start graceful shutdown, SIGTERM is sent by k8s, SIGHUP is kept for compatibility.
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}
+
+// idlePollInterval is how often pool is checked while waiting for in-flight db transactions.
+const idlePollInterval = 50 * time.Millisecond
+
+// run.Group calls interrupts one by one in order actors were added, so blocking interrupt
+// until actor exits makes actors stop in that order.
+
+// addHTTPServer adds actor serving on listener, on interrupt instance becomes not ready first,
+// then requests are still served for drainDelay (load balancer notices readiness meanwhile)
+// and after that server stops accepting connections waiting for in-flight requests up to timeout.
+func addHTTPServer(
+	g *run.Group, server *http.Server, listener net.Listener,
+	checker *health.Checker, drainDelay, timeout time.Duration, logger log.Logger,
+) {
+	g.Add(func() error {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+
+		return nil
+	}, func(error) {
+		checker.ShutDown()
+		_ = level.Info(logger).Log("msg", "draining", "delay", drainDelay)
+		time.Sleep(drainDelay)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			_ = level.Error(logger).Log("msg", "graceful shutdown "+err.Error())
+			_ = server.Close()
+		}
+	})
+}
+
+// addWorker adds actor running worker until its ctx is done, interrupt waits for worker to return.
+func addWorker(g *run.Group, worker func(ctx context.Context) error, stop ...func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	g.Add(func() error {
+		defer close(done)
+
+		return worker(ctx)
+	}, func(error) {
+		cancel()
+		for _, s := range stop {
+			s()
+		}
+		<-done
+	})
+}
+
+// waitIdle waits until no connections of pool are in use (i.e. all transactions are finished) up to timeout.
+func waitIdle(db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for db.Stats().InUse > 0 {
+		if time.Now().After(deadline) {
+			return errors.New("db connections are still in use")
+		}
+		time.Sleep(idlePollInterval)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/run"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/health"
+)
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+type stopLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (l *stopLog) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.names = append(l.names, name)
+}
+
+func (l *stopLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.names...)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	a := assert.New(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	stopped := &stopLog{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("done"))
+	})
+	server := &http.Server{Handler: mux}
+	server.RegisterOnShutdown(func() { stopped.add("streams") })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	base := "http://" + listener.Addr().String()
+	checker := health.NewChecker(time.Second)
+
+	var g run.Group
+	addHTTPServer(&g, server, listener, checker, 200*time.Millisecond, 5*time.Second, testLogger)
+	for _, name := range []string{"scheduler", "relay"} {
+		name := name
+		addWorker(&g, func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond) // finishing current work
+			stopped.add(name)
+
+			return nil
+		})
+	}
+	execute, interrupt := run.SignalHandler(context.Background(), shutdownSignals...)
+	g.Add(execute, interrupt)
+	result := make(chan error, 1)
+	go func() { result <- g.Run() }()
+
+	slow := make(chan string, 1)
+	go func() {
+		res, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	a.NoError(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	a.Eventually(func() bool { return !checker.Ready(context.Background()).Ready },
+		time.Second, 5*time.Millisecond, "not ready as soon as shutdown begins")
+	res, err := http.Get(base + "/fast")
+	a.NoError(err, "requests are served while draining")
+	if err == nil {
+		_ = res.Body.Close()
+	}
+
+	time.Sleep(300 * time.Millisecond) // drain is over, server waits for in-flight request
+	a.Equal([]string{"streams"}, stopped.get(), "streams are ended, workers are stopped after in-flight requests")
+	close(release)
+	a.Equal("done", <-slow, "in-flight request completes")
+
+	select {
+	case err = <-result:
+		sig, ok := err.(run.SignalError)
+		a.True(ok)
+		a.Equal(syscall.SIGTERM, sig.Signal)
+	case <-time.After(5 * time.Second):
+		t.Fatal("group is not stopped")
+	}
+	a.Equal([]string{"streams", "scheduler", "relay"}, stopped.get(), "workers are stopped in order")
+	_, err = http.Get(base + "/fast")
+	a.Error(err, "connections aren't accepted after shutdown")
+}
+
+func TestWaitIdle(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New()
+	a.NoError(err)
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	tx, err := db.Begin()
+	a.NoError(err)
+
+	a.Error(waitIdle(db, 10*time.Millisecond), "transaction is in progress")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = tx.Commit()
+	}()
+	a.NoError(waitIdle(db, time.Second))
+	a.NoError(mock.ExpectationsWereMet())
+}
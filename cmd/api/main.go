@@ -3,22 +3,50 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
-	"syscall"
 	"time"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	_ "github.com/lib/pq"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
 	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	migrate "github.com/rubenv/sql-migrate"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 
 	"github.com/risentveber/wallet-api/integration"
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/activity"
+	"github.com/risentveber/wallet-api/services/audit"
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/health"
+	"github.com/risentveber/wallet-api/services/instrumenting"
+	"github.com/risentveber/wallet-api/services/mandates"
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/pgnotify"
+	"github.com/risentveber/wallet-api/services/ratelimit"
+	"github.com/risentveber/wallet-api/services/reconciliation"
+	"github.com/risentveber/wallet-api/services/tracing"
 	"github.com/risentveber/wallet-api/services/transfers"
+	"github.com/risentveber/wallet-api/services/webhooks"
 )
 
+const jwksTimeout = 10 * time.Second
+
 // give stack when panic is recovered.
 func trimPanicStack() string {
 	buf := make([]byte, 1024)
@@ -59,10 +87,114 @@ func RecoverWrap(h http.Handler, logger log.Logger) http.Handler {
 	})
 }
 
+// SignatureWrap verifies signed requests and puts their principal into context,
+// unsigned ones are passed as is to be authenticated by other means.
+func SignatureWrap(h http.Handler, verifier auth.Authenticator, errorEncoder httptransport.ErrorEncoder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, signed, err := verifier.Authenticate(r)
+		if err != nil {
+			errorEncoder(r.Context(), err, w)
+
+			return
+		}
+		if signed {
+			r = r.WithContext(auth.NewContext(r.Context(), p))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func newNonceCache(c Config, db *sql.DB) (auth.NonceCache, error) {
+	switch c.nonceCache {
+	case "memory":
+		return auth.NewMemoryNonceCache(), nil
+	case "postgres":
+		return auth.NewPostgresNonceCache(db), nil
+	default:
+		return nil, errors.New("unknown nonce cache " + c.nonceCache)
+	}
+}
+
+func newRateLimiter(c Config, db *sql.DB) (ratelimit.Limiter, error) {
+	switch c.rateLimiter {
+	case "memory":
+		return ratelimit.NewMemoryLimiter(), nil
+	case "postgres":
+		return ratelimit.NewPostgresLimiter(db), nil
+	default:
+		return nil, errors.New("unknown rate limiter " + c.rateLimiter)
+	}
+}
+
+// newTracerProvider gives nil if tracing is disabled.
+func newTracerProvider(c Config) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch c.traceExporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		var f *os.File
+		f, err = os.OpenFile(c.traceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = tracing.NewFileExporter(context.Background(), f)
+	default:
+		return nil, errors.New("unknown trace exporter " + c.traceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.traceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("wallet-api"))),
+	), nil
+}
+
+func newEventPublisher(c Config) (outbox.EventPublisher, error) {
+	switch c.outboxPublisher {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return outbox.NewWriterPublisher(os.Stdout), nil
+	case "webhook":
+		if c.outboxWebhookURL == "" {
+			return nil, errors.New("outboxWebhookURL is required for webhook publisher")
+		}
+
+		return outbox.NewHTTPPublisher(c.outboxWebhookURL, &http.Client{Timeout: c.outboxWebhookTimeout}), nil
+	default:
+		return nil, errors.New("unknown outbox publisher " + c.outboxPublisher)
+	}
+}
+
 func main() { // nolint funlen
-	c := NewConfig()
+	args, command := os.Args[1:], ""
+	if len(args) > 0 && args[0] == MigrateCommand {
+		args, command = args[1:], MigrateCommand
+	}
+	c, err := NewConfig(os.Args[0], args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err == nil && command == "" && len(c.args) > 0 {
+		err = fmt.Errorf("unknown command %s", c.args[0])
+	}
+	if err == nil && command == MigrateCommand && len(c.args) != 1 {
+		err = errors.New("migrate requires one of up|down|status")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	logger := level.NewFilter(log.NewJSONLogger(os.Stdout), c.logLevel)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
+	_ = level.Info(logger).Log(append([]interface{}{"msg", "effective config"}, c.Effective()...)...)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -75,38 +207,268 @@ func main() { // nolint funlen
 		}
 	}()
 
-	db, err := sql.Open("postgres", c.dbConnectionURL)
+	db, err := openDB(c, c.dbConnectionURL)
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
 	err = integration.Retry(c.dbConnectRetryTimout, c.dbConnectRetryCount, db.Ping, logger)
 	if err != nil {
 		panic(err)
 	}
+	if command == MigrateCommand {
+		err = runMigrate(db, c.dbDriver, c.args[0], os.Stdout)
+		_ = db.Close()
+		if err != nil {
+			panic(err)
+		}
+
+		return
+	}
+	dialect, migrationsSource, err := migrations.For(c.dbDriver)
+	if err != nil {
+		panic(err)
+	}
+	if c.autoMigrate {
+		n, err := migrations.Set.Exec(db, dialect, migrationsSource, migrate.Up)
+		if err != nil {
+			panic(err)
+		}
+		_ = level.Info(logger).Log("msg", "migrations applied", "count", n)
+	}
+
+	if err = warmUp(context.Background(), db, c.dbIdleConns); err != nil {
+		panic(err)
+	}
+	if err = instrumenting.RegisterDBStats(db, "wallet"); err != nil {
+		panic(err)
+	}
+	// account lists and transfer history are read from replica, if any, unless request asks to read its writes
+	replica := db
+	if c.dbReplicaURL != "" {
+		if replica, err = openDB(c, c.dbReplicaURL); err != nil {
+			panic(err)
+		}
+		if err = integration.Retry(c.dbConnectRetryTimout, c.dbConnectRetryCount, replica.Ping, logger); err != nil {
+			panic(err)
+		}
+		if err = warmUp(context.Background(), replica, c.dbIdleConns); err != nil {
+			panic(err)
+		}
+		if err = instrumenting.RegisterDBStats(replica, "wallet_replica"); err != nil {
+			panic(err)
+		}
+	}
+	// traceparent of incoming requests is honoured even if tracing is disabled
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracerProvider, err := newTracerProvider(c)
+	if err != nil {
+		panic(err)
+	}
+	if tracerProvider != nil {
+		otel.SetTracerProvider(tracerProvider)
+	}
+	endpointMetrics := instrumenting.NewEndpointMetrics()
+
+	// mandates, webhooks, reconciliation, audit, outbox relay and activity streams rely on postgres features,
+	// they are off with sqlite
+	postgres := c.dbDriver != migrations.SQLite
+	authRepo, repo := auth.NewSQLiteRepository(db), transfers.NewSQLiteRepository(db)
+	if postgres {
+		authRepo, repo = auth.NewRepository(db), transfers.NewRepositoryWithReplica(db, replica)
+	}
+	authService := auth.NewService(authRepo)
+	var currenciesCache *transfers.CachedRepository
+	closeCurrenciesListener := func() error { return nil }
+	if c.currenciesCacheTTL > 0 {
+		var notify <-chan struct{} // sqlite is used by single process, cache expires by ttl only
+		if postgres {
+			notify, closeCurrenciesListener, err = pgnotify.Listen(c.dbConnectionURL, transfers.CurrenciesChannel, logger)
+			if err != nil {
+				panic(err)
+			}
+		}
+		currenciesCache = transfers.NewCachedRepository(repo, c.currenciesCacheTTL, notify)
+		repo = currenciesCache
+	}
+	service := transfers.NewInstrumentedService(repo, instrumenting.NewTransferVolume())
+	// calls changing state are recorded to audit trail, it's kept in postgres only
+	audited := func(string, map[string]bool) func(name string) endpoint.Middleware {
+		return func(string) endpoint.Middleware {
+			return func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+		}
+	}
+	if postgres {
+		auditService := audit.NewService(audit.NewRepository(db))
+		auditFailures := instrumenting.NewAuditFailures()
+		audited = func(service string, reads map[string]bool) func(name string) endpoint.Middleware {
+			return audit.Middleware(auditService, logger, auditFailures, service, reads)
+		}
+	}
+	limiter, err := newRateLimiter(c, db)
+	if err != nil {
+		panic(err)
+	}
+	// rate limit counts calls passing scope check only, calls over it are audited and counted by metrics
+	endpoints := transfers.NewEndpoints(service).
+		Wrap(ratelimit.Middleware(limiter,
+			ratelimit.Rule{
+				Endpoint: "CreateTransfer",
+				Limit:    ratelimit.Limit{Rate: c.clientRateLimit, Burst: c.clientRateBurst},
+				Key:      ratelimit.ByClient,
+			},
+			ratelimit.Rule{
+				Endpoint: "CreateTransfer",
+				Limit:    ratelimit.Limit{Rate: c.senderRateLimit, Burst: c.senderRateBurst},
+				Key:      transfers.SenderKey,
+			})).
+		Wrap(auth.ScopeMiddleware(transfers.EndpointScopes)).
+		Wrap(audited("transfers", transfers.ReadEndpoints)).
+		Wrap(endpointMetrics.Middleware("transfers")).
+		Wrap(tracing.EndpointMiddleware("transfers"))
+	mandatesService := mandates.NewService(mandates.NewRepository(db), service, transfers.NewAdminService(repo))
+	mandatesEndpoints := mandates.NewEndpoints(mandatesService).
+		Wrap(auth.ScopeMiddleware(mandates.EndpointScopes)).
+		Wrap(audited("mandates", mandates.ReadEndpoints)).
+		Wrap(endpointMetrics.Middleware("mandates")).
+		Wrap(tracing.EndpointMiddleware("mandates"))
+
+	allowedNets, err := webhooks.ParseNets(c.webhooksAllowedNets)
+	if err != nil {
+		panic(err)
+	}
+	guard := webhooks.NewAddressGuard(allowedNets)
+	webhooksService := webhooks.NewService(
+		webhooks.NewRepository(db),
+		guard.Client(c.webhooksTimeout),
+		guard,
+		webhooks.RetryPolicy{
+			MaxAttempts:  c.webhooksMaxAttempts,
+			InitialDelay: c.webhooksRetryDelay,
+			MaxDelay:     c.webhooksMaxRetryDelay,
+		})
+	webhooksEndpoints := webhooks.NewEndpoints(webhooksService).
+		Wrap(auth.ScopeMiddleware(webhooks.EndpointScopes)).
+		Wrap(audited("webhooks", webhooks.ReadEndpoints)).
+		Wrap(endpointMetrics.Middleware("webhooks")).
+		Wrap(tracing.EndpointMiddleware("webhooks"))
+	reconciliationService := reconciliation.NewService(reconciliation.NewRepository(db), transfers.NewAdminService(repo))
+	reconciliationEndpoints := reconciliation.NewEndpoints(reconciliationService).
+		Wrap(auth.ScopeMiddleware(reconciliation.EndpointScopes)).
+		Wrap(audited("reconciliation", reconciliation.ReadEndpoints)).
+		Wrap(endpointMetrics.Middleware("reconciliation")).
+		Wrap(tracing.EndpointMiddleware("reconciliation"))
+	publisher := outbox.NewMultiPublisher(webhooksService)
+	extraPublisher, err := newEventPublisher(c)
+	if err != nil {
+		panic(err)
+	}
+	if extraPublisher != nil {
+		publisher = append(publisher, extraPublisher)
+	}
 
-	repo := transfers.NewRepository(db)
-	service := transfers.NewService(repo)
-	endpoints := transfers.NewEndpoints(service)
-	httpHandler := transfers.NewHTTPHandler(endpoints, logger)
-	httpServer := &http.Server{Handler: RecoverWrap(httpHandler, logger)}
+	outboxRepo := outbox.NewRepository(db)
+	broker := activity.NewBroker()
+
+	router := mux.NewRouter()
+	if postgres {
+		router.Handle("/accounts/{account_id}/events",
+			auth.RequireScopeHandler(auth.ScopeAccountsRead, transfers.ErrorEncoder,
+				activity.NewHTTPHandler(broker, outboxRepo, service, c.activityHeartbeat, logger))).Methods("GET")
+		router.PathPrefix("/mandates").Handler(mandates.NewHTTPHandler(mandatesEndpoints, logger))
+		router.PathPrefix("/webhooks").Handler(webhooks.NewHTTPHandler(webhooksEndpoints, logger))
+		router.PathPrefix("/reconciliation").Handler(reconciliation.NewHTTPHandler(reconciliationEndpoints, logger))
+	}
+	router.PathPrefix("/").Handler(transfers.NewHTTPHandler(endpoints, logger))
+	authenticators := []auth.Authenticator{auth.NewAPIKeyAuthenticator(authService)}
+	var keySet *auth.KeySet
+	if c.jwks != "" {
+		keySet = auth.NewKeySet(c.jwks, &http.Client{Timeout: jwksTimeout}, c.jwksRefreshInterval, logger)
+		if err = keySet.Refresh(context.Background()); err != nil {
+			panic(err)
+		}
+		authenticators = append(authenticators,
+			auth.NewJWTAuthenticator(keySet, auth.JWTConfig{Issuer: c.jwtIssuer, Audience: c.jwtAudience}))
+	}
+	authenticate := auth.NewHTTPMiddleware(transfers.ErrorEncoder, authenticators...)
+	handler := authenticate(router)
+	if c.requestSigning {
+		nonces, err := newNonceCache(c, db)
+		if err != nil {
+			panic(err)
+		}
+		handler = SignatureWrap(handler, auth.NewSignatureAuthenticator(authService, nonces, c.signatureMaxSkew),
+			transfers.ErrorEncoder)
+	}
+	checker := health.NewChecker(c.readinessTimeout).
+		Add("db", health.DBCheck(db)).
+		Add("migrations", health.MigrationsCheck(db, migrations.Table, migrationsSource))
+	if replica != db {
+		checker.Add("db_replica", health.DBCheck(replica))
+	}
+	probes := health.NewHTTPHandler(checker)
+	root := http.NewServeMux()
+	// probes are neither authenticated nor traced
+	root.Handle("/healthz", probes)
+	root.Handle("/readyz", probes)
+	root.Handle("/", otelhttp.NewHandler(
+		RecoverWrap(audit.HTTPMiddleware(handler), logger),
+		"http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		})))
+	httpServer := &http.Server{Handler: root}
+
+	// streams are ended as soon as server stops accepting connections, clients resume them on other instances
+	httpServer.RegisterOnShutdown(broker.Close)
 
 	_ = level.Info(logger).Log("msg", "started on port "+c.port)
+	// actors are stopped in order they are added: api first, so no new work appears, then workers
 	var g run.Group
 	{
 		httpListener, err := net.Listen("tcp", ":"+c.port)
 		if err != nil {
 			panic(err)
 		}
+		addHTTPServer(&g, httpServer, httpListener, checker, c.drainDelay, c.shutdownTimeout, logger)
+	}
+	if postgres {
+		scheduler := mandates.NewScheduler(mandatesService, c.mandatesInterval, logger)
+		addWorker(&g, scheduler.Run)
+		relay := outbox.NewRelay(outboxRepo, publisher, c.outboxInterval, 100, c.outboxRetention, logger)
+		addWorker(&g, relay.Run)
+		dispatcher := webhooks.NewDispatcher(webhooksService, c.webhooksInterval, logger)
+		addWorker(&g, dispatcher.Run)
+		notify, closeListener, err := pgnotify.Listen(c.dbConnectionURL, activity.OutboxChannel, logger)
+		if err != nil {
+			panic(err)
+		}
+		follower := activity.NewFollower(outboxRepo, broker, c.activityPollInterval, c.activityGapTimeout, notify, logger)
+		addWorker(&g, follower.Run, func() { _ = closeListener() })
+		if c.reconcileInterval > 0 {
+			addWorker(&g, reconciliation.NewWorker(reconciliationService, c.reconcileInterval, logger).Run)
+		}
+	}
+	if keySet != nil {
+		addWorker(&g, keySet.Run)
+	}
+	if currenciesCache != nil {
+		addWorker(&g, currenciesCache.Run, func() { _ = closeCurrenciesListener() })
+	}
+	{
+		// metrics are served on separate port, so they aren't exposed with api, they are available till the end
+		metricsListener, err := net.Listen("tcp", ":"+c.metricsPort)
+		if err != nil {
+			panic(err)
+		}
+		metricsServer := &http.Server{Handler: promhttp.Handler()}
 
 		g.Add(func() error {
-			return httpServer.Serve(httpListener)
+			return metricsServer.Serve(metricsListener)
 		}, func(error) {
-			_ = httpListener.Close()
+			_ = metricsListener.Close()
 		})
 	}
 	{
-		execute, interrupt := run.SignalHandler(context.Background(), syscall.SIGHUP)
+		execute, interrupt := run.SignalHandler(context.Background(), shutdownSignals...)
 		g.Add(execute, interrupt)
 	}
 	err = g.Run()
@@ -115,10 +477,25 @@ func main() { // nolint funlen
 		_ = level.Info(logger).Log("msg", "exiting by signal "+sig.Signal.String())
 		err = nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
-	if err := httpServer.Shutdown(ctx); err != nil {
+	if err := waitIdle(db, c.shutdownTimeout); err != nil {
+		_ = level.Error(logger).Log("msg", "waiting for db transactions "+err.Error())
+	}
+	if tracerProvider != nil {
+		// exports buffered spans
+		ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			_ = level.Error(logger).Log("msg", "tracer shutdown "+err.Error())
+		}
 		cancel()
-		_ = level.Info(logger).Log("msg", "graceful shutdown "+err.Error())
+	}
+	// db is closed last, after everything using it is stopped
+	if replica != db {
+		if err := replica.Close(); err != nil {
+			_ = level.Error(logger).Log("msg", "db replica close "+err.Error())
+		}
+	}
+	if err := db.Close(); err != nil {
+		_ = level.Error(logger).Log("msg", "db close "+err.Error())
 	}
 	if err != nil {
 		panic(err)
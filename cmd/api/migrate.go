@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/risentveber/wallet-api/migrations"
+)
+
+// MigrateCommand is the subcommand of api binary: `api migrate [flags] up|down|status`.
+const MigrateCommand = "migrate"
+
+// runMigrate applies all pending migrations (up), rolls back the last applied one (down)
+// or prints migrations with time they were applied at (status).
+func runMigrate(db *sql.DB, driver, action string, out io.Writer) error {
+	dialect, source, err := migrations.For(driver)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "up":
+		n, err := migrations.Set.Exec(db, dialect, source, migrate.Up)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migrations\n", n)
+
+		return nil
+	case "down":
+		n, err := migrations.Set.ExecMax(db, dialect, source, migrate.Down, 1)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "rolled back %d migrations\n", n)
+
+		return nil
+	case "status":
+		all, err := source.FindMigrations()
+		if err != nil {
+			return err
+		}
+		records, err := migrations.Set.GetMigrationRecords(db, dialect)
+		if err != nil {
+			return err
+		}
+
+		return printStatus(out, all, records)
+	default:
+		return fmt.Errorf("unknown migrate action %q, use up|down|status", action)
+	}
+}
+
+func printStatus(out io.Writer, all []*migrate.Migration, records []*migrate.MigrationRecord) error {
+	appliedAt := make(map[string]time.Time, len(records))
+	for _, r := range records {
+		appliedAt[r.Id] = r.AppliedAt
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED")
+	for _, m := range all {
+		applied := "no"
+		if at, ok := appliedAt[m.Id]; ok {
+			applied = at.UTC().Format(time.RFC3339)
+			delete(appliedAt, m.Id)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", m.Id, applied)
+	}
+	for _, r := range records {
+		if _, ok := appliedAt[r.Id]; ok {
+			fmt.Fprintf(w, "%s\t%s (unknown to this version)\n", r.Id, r.AppliedAt.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return w.Flush()
+}
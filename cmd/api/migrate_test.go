@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/migrations"
+)
+
+func TestPrintStatus(t *testing.T) {
+	a := assert.New(t)
+	appliedAt := time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+
+	a.NoError(printStatus(&out,
+		[]*migrate.Migration{{Id: "1-initial.sql"}, {Id: "2-mandates.sql"}},
+		[]*migrate.MigrationRecord{{Id: "1-initial.sql", AppliedAt: appliedAt}, {Id: "9-future.sql", AppliedAt: appliedAt}},
+	))
+	a.Equal(`MIGRATION       APPLIED
+1-initial.sql   2020-09-01T10:00:00Z
+2-mandates.sql  no
+9-future.sql    2020-09-01T10:00:00Z (unknown to this version)
+`, out.String())
+}
+
+func TestRunMigrate_UnknownAction(t *testing.T) {
+	assert.EqualError(t, runMigrate(nil, migrations.Postgres, "sideways", nil), `unknown migrate action "sideways", use up|down|status`)
+}
+
+func TestRunMigrate_SQLite(t *testing.T) {
+	a := assert.New(t)
+	db, err := migrations.Open(migrations.SQLite, filepath.Join(t.TempDir(), "wallet.db"))
+	a.NoError(err)
+	defer db.Close()
+	var out bytes.Buffer
+
+	a.NoError(runMigrate(db, migrations.SQLite, "up", &out))
+	a.NoError(runMigrate(db, migrations.SQLite, "status", &out))
+	a.Contains(out.String(), "applied 1 migrations\nMIGRATION")
+	a.NotContains(out.String(), " no\n")
+}
@@ -0,0 +1,181 @@
+// apikeys is an admin command to manage customers and API keys of their clients:
+//
+//	apikeys -db <dsn> add-customer -name <customer>
+//	apikeys -db <dsn> customers
+//	apikeys -db <dsn> issue -customer <customer id> -name <client> -scopes accounts:read,transfers:write
+//	apikeys -db <dsn> signing-secret -id <key id>
+//	apikeys -db <dsn> revoke -id <key id>
+//	apikeys -db <dsn> list
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -db <dsn> add-customer|customers|issue|signing-secret|revoke|list [flags]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dsn := flag.String("db", "", "db connections credentials")
+	driver := flag.String("dbDriver", migrations.Postgres, "postgres|pgx|sqlite, db is a file path for sqlite")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := migrations.Open(*driver, *dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+	repo := auth.NewRepository(db)
+	if *driver == migrations.SQLite {
+		repo = auth.NewSQLiteRepository(db)
+	}
+	svc := auth.NewService(repo)
+	ctx := context.Background()
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "add-customer":
+		err = addCustomer(ctx, svc, args)
+	case "customers":
+		err = customers(ctx, svc)
+	case "issue":
+		err = issue(ctx, svc, args)
+	case "signing-secret":
+		err = signingSecret(ctx, svc, args)
+	case "revoke":
+		err = revoke(ctx, svc, args)
+	case "list":
+		err = list(ctx, svc)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+func addCustomer(ctx context.Context, svc auth.Service, args []string) error {
+	fs := flag.NewFlagSet("add-customer", flag.ExitOnError)
+	name := fs.String("name", "", "customer name")
+	_ = fs.Parse(args)
+
+	c, err := svc.CreateCustomer(ctx, *name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id: %s\n", c.ID)
+
+	return nil
+}
+
+func customers(ctx context.Context, svc auth.Service) error {
+	list, err := svc.GetCustomers(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, c := range list {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func issue(ctx context.Context, svc auth.Service, args []string) error {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	customer := fs.String("customer", "", "id of customer whose accounts client accesses")
+	name := fs.String("name", "", "client name")
+	scopes := fs.String("scopes", "", "comma separated scopes: "+strings.Join(auth.Scopes, ","))
+	_ = fs.Parse(args)
+
+	var scopeList []string
+	for _, s := range strings.Split(*scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopeList = append(scopeList, s)
+		}
+	}
+	customerID, err := uuid.Parse(*customer)
+	if err != nil {
+		return err
+	}
+	k, key, err := svc.IssueAPIKey(ctx, customerID, *name, scopeList)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id:     %s\nscopes: %s\nkey:    %s\n", k.ID, strings.Join(k.Scopes, ","), key)
+	fmt.Println("the key is shown only once, store it securely")
+
+	return nil
+}
+
+func signingSecret(ctx context.Context, svc auth.Service, args []string) error {
+	fs := flag.NewFlagSet("signing-secret", flag.ExitOnError)
+	id := fs.String("id", "", "key id")
+	_ = fs.Parse(args)
+
+	keyID, err := uuid.Parse(*id)
+	if err != nil {
+		return err
+	}
+	secret, err := svc.IssueSigningSecret(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("key id: %s\nsecret: %s\n", keyID, secret)
+	fmt.Println("the secret is shown only once and replaces the previous one, store it securely")
+
+	return nil
+}
+
+func revoke(ctx context.Context, svc auth.Service, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	id := fs.String("id", "", "key id")
+	_ = fs.Parse(args)
+
+	keyID, err := uuid.Parse(*id)
+	if err != nil {
+		return err
+	}
+
+	return svc.RevokeAPIKey(ctx, keyID)
+}
+
+func list(ctx context.Context, svc auth.Service) error {
+	keys, err := svc.GetAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, k := range keys {
+		if err := enc.Encode(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
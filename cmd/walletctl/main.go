@@ -0,0 +1,433 @@
+// walletctl is an admin command for operational tasks on accounts and transfers:
+//
+//	walletctl -db <dsn> create-account -currency USD [-customer <customer id>]
+//	walletctl -db <dsn> adjust -account <account id> -amount -10.5 -reason <reason> [-id <adjustment id>]
+//	walletctl -db <dsn> freeze -account <account id>
+//	walletctl -db <dsn> unfreeze -account <account id>
+//	walletctl -db <dsn> transfer -id <transfer id>
+//	walletctl -db <dsn> currencies
+//	walletctl -db <dsn> set-currency -code GBP -precision 2
+//	walletctl -db <dsn> disable-currency -code GBP
+//	walletctl -db <dsn> enable-currency -code GBP
+//	walletctl -db <dsn> check-balances
+//	walletctl -db <dsn> reconcile
+//	walletctl -db <dsn> check-conservation
+//	walletctl -db <dsn> audit-verify
+//
+// Results are printed as table or, with -output json, as JSON.
+// It works with DB directly as these actions aren't exposed by API,
+// actions changing state are recorded to audit trail as done by walletctl:<os user>.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/audit"
+	"github.com/risentveber/wallet-api/services/reconciliation"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s -db <dsn> [-output table|json] create-account|adjust|freeze|unfreeze|transfer|check-balances|reconcile|check-conservation|audit-verify|currencies|set-currency|disable-currency|enable-currency [flags]\n",
+		os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dsn := flag.String("db", "", "db connections credentials")
+	driver := flag.String("dbDriver", migrations.Postgres, "postgres|pgx|sqlite, db is a file path for sqlite")
+	output := flag.String("output", outputTable, "table|json")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 || (*output != outputTable && *output != outputJSON) {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := migrations.Open(*driver, *dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+	repo := transfers.NewRepository(db)
+	if *driver == migrations.SQLite {
+		repo = transfers.NewSQLiteRepository(db)
+	}
+	svc := transfers.NewAdminService(repo)
+	ctx := context.Background()
+
+	var v view
+	var params interface{}
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "create-account":
+		v, params, err = createAccount(ctx, svc, args)
+	case "adjust":
+		v, params, err = adjust(ctx, svc, args)
+	case "freeze":
+		v, params, err = setFrozen(ctx, svc, "freeze", true, args)
+	case "unfreeze":
+		v, params, err = setFrozen(ctx, svc, "unfreeze", false, args)
+	case "transfer":
+		v, err = transfer(ctx, svc, args)
+	case "currencies":
+		v, err = currencies(ctx, svc)
+	case "set-currency":
+		v, params, err = setCurrency(ctx, svc, args)
+	case "disable-currency":
+		v, params, err = setCurrencyDisabled(ctx, svc, "disable-currency", true, args)
+	case "enable-currency":
+		v, params, err = setCurrencyDisabled(ctx, svc, "enable-currency", false, args)
+	case "check-balances":
+		v, err = checkBalances(ctx, svc)
+	case "check-conservation":
+		v, err = checkConservation(ctx, svc)
+	case "reconcile":
+		if *driver == migrations.SQLite {
+			fail(errors.New("reconciliation reports are kept in postgres only"))
+		}
+		v, err = reconcile(ctx, reconciliation.NewService(reconciliation.NewRepository(db), svc))
+	case "audit-verify":
+		if *driver == migrations.SQLite {
+			fail(errors.New("audit trail is kept in postgres only"))
+		}
+		v, err = auditVerify(ctx, audit.NewService(audit.NewRepository(db)))
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if audited[flag.Arg(0)] && *driver != migrations.SQLite {
+		if rerr := record(ctx, audit.NewService(audit.NewRepository(db)), flag.Arg(0), params, args, err); rerr != nil {
+			fail(rerr)
+		}
+	}
+	if err == nil || errors.Is(err, errInconsistent) || errors.Is(err, errNotConserved) || errors.Is(err, audit.ErrChainBroken) {
+		if werr := v.write(os.Stdout, *output); werr != nil {
+			fail(werr)
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+var (
+	errInconsistent = errors.New("some account balances are inconsistent with transfers")
+	errNotConserved = errors.New("balances of some currencies aren't equal to deposits minus withdrawals")
+)
+
+func accountView(a transfers.Account) view {
+	customer := ""
+	if a.CustomerID != nil {
+		customer = a.CustomerID.String()
+	}
+
+	return view{
+		value:  a,
+		header: []string{"ID", "CUSTOMER", "CURRENCY", "BALANCE", "FROZEN", "UPDATED"},
+		rows: [][]string{{
+			a.ID.String(), customer, a.CurrencyCode, a.Balance.String(),
+			fmt.Sprint(a.Frozen), a.UpdatedAt.Format(timeFormat),
+		}},
+	}
+}
+
+// createAccount and other audited commands give parameters they are resolved to besides view, nil if they aren't.
+func createAccount(ctx context.Context, svc transfers.AdminService, args []string) (view, interface{}, error) {
+	fs := flag.NewFlagSet("create-account", flag.ExitOnError)
+	currency := fs.String("currency", "", "currency code of account")
+	customer := fs.String("customer", "", "id of customer owning account, internal account is created if empty")
+	_ = fs.Parse(args)
+
+	var customerID *uuid.UUID
+	if *customer != "" {
+		id, err := uuid.Parse(*customer)
+		if err != nil {
+			return view{}, nil, err
+		}
+		customerID = &id
+	}
+	params := map[string]interface{}{"customer_id": customerID, "currency_code": *currency}
+	a, err := svc.CreateAccount(ctx, customerID, *currency)
+	if err != nil {
+		return view{}, params, err
+	}
+
+	return accountView(a), params, nil
+}
+
+func adjust(ctx context.Context, svc transfers.AdminService, args []string) (view, interface{}, error) {
+	fs := flag.NewFlagSet("adjust", flag.ExitOnError)
+	id := fs.String("id", "", "adjustment id making retries safe, new one is generated if empty")
+	account := fs.String("account", "", "account id")
+	amount := fs.String("amount", "", "amount deposited to account, negative one is withdrawn")
+	reason := fs.String("reason", "", "why balance is adjusted (mandatory)")
+	_ = fs.Parse(args)
+
+	adj := transfers.Adjustment{ID: uuid.New(), Reason: *reason}
+	var err error
+	if *id != "" {
+		if adj.ID, err = uuid.Parse(*id); err != nil {
+			return view{}, nil, err
+		}
+	}
+	if adj.AccountID, err = uuid.Parse(*account); err != nil {
+		return view{}, nil, err
+	}
+	if adj.Amount, err = decimal.NewFromString(*amount); err != nil {
+		return view{}, nil, err
+	}
+	// generated id is recorded, so adjustment can be found by audit entry
+	if err = svc.Adjust(ctx, adj); err != nil {
+		return view{}, adj, err
+	}
+	t, err := svc.GetTransfer(ctx, adj.ID)
+	if err != nil {
+		return view{}, adj, err
+	}
+
+	return transferView(t), adj, nil
+}
+
+func setFrozen(ctx context.Context, svc transfers.AdminService, name string, frozen bool, args []string) (view, interface{}, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	account := fs.String("account", "", "account id")
+	_ = fs.Parse(args)
+
+	accountID, err := uuid.Parse(*account)
+	if err != nil {
+		return view{}, nil, err
+	}
+	params := map[string]interface{}{"account_id": accountID, "frozen": frozen}
+	if err = svc.SetFrozen(ctx, accountID, frozen); err != nil {
+		return view{}, params, err
+	}
+
+	return view{
+		value:  params,
+		header: []string{"ACCOUNT", "FROZEN"},
+		rows:   [][]string{{accountID.String(), fmt.Sprint(frozen)}},
+	}, params, nil
+}
+
+func transfer(ctx context.Context, svc transfers.AdminService, args []string) (view, error) {
+	fs := flag.NewFlagSet("transfer", flag.ExitOnError)
+	id := fs.String("id", "", "transfer id")
+	_ = fs.Parse(args)
+
+	transferID, err := uuid.Parse(*id)
+	if err != nil {
+		return view{}, err
+	}
+	t, err := svc.GetTransfer(ctx, transferID)
+	if err != nil {
+		return view{}, err
+	}
+
+	return transferView(t), nil
+}
+
+func transferView(t transfers.TransferDetails) view {
+	reason := ""
+	if t.Reason != nil {
+		reason = *t.Reason
+	}
+	v := view{
+		value:  t,
+		header: []string{"TRANSFER", "TYPE", "ACCOUNT", "DIRECTION", "CORRESPONDING", "AMOUNT", "CURRENCY", "CREATED", "REASON"},
+	}
+	for _, tp := range t.Parts {
+		corresponding := ""
+		if tp.CorrespondingAccountID != nil {
+			corresponding = tp.CorrespondingAccountID.String()
+		}
+		v.rows = append(v.rows, []string{
+			t.ID.String(), t.Type, tp.AccountID.String(), tp.Direction, corresponding,
+			t.Amount.String(), t.CurrencyCode, t.CreatedAt.Format(timeFormat), reason,
+		})
+	}
+
+	return v
+}
+
+func currencies(ctx context.Context, svc transfers.AdminService) (view, error) {
+	list, err := svc.GetCurrencies(ctx)
+	if err != nil {
+		return view{}, err
+	}
+	v := view{value: list, header: currencyHeader}
+	for _, c := range list {
+		v.rows = append(v.rows, currencyRow(c))
+	}
+
+	return v, nil
+}
+
+var currencyHeader = []string{"CODE", "PRECISION", "DISABLED"}
+
+func currencyRow(c transfers.Currency) []string {
+	return []string{c.Code, fmt.Sprint(c.Precision), fmt.Sprint(c.Disabled)}
+}
+
+// setCurrency creates currency or changes its precision, precision can't be lowered
+// if some balance has more decimal places.
+func setCurrency(ctx context.Context, svc transfers.AdminService, args []string) (view, interface{}, error) {
+	fs := flag.NewFlagSet("set-currency", flag.ExitOnError)
+	code := fs.String("code", "", "currency code")
+	precision := fs.Uint("precision", 0, "decimal places amounts are rounded to")
+	_ = fs.Parse(args)
+
+	params := map[string]interface{}{"code": *code, "precision": *precision}
+	c, err := svc.SaveCurrency(ctx, transfers.Currency{Code: *code, Precision: *precision})
+	if err != nil {
+		return view{}, params, err
+	}
+
+	return view{value: c, header: currencyHeader, rows: [][]string{currencyRow(c)}}, params, nil
+}
+
+func setCurrencyDisabled(ctx context.Context, svc transfers.AdminService, name string, disabled bool, args []string) (view, interface{}, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	code := fs.String("code", "", "currency code")
+	_ = fs.Parse(args)
+
+	params := map[string]interface{}{"code": *code, "disabled": disabled}
+	c, err := svc.SetCurrencyDisabled(ctx, *code, disabled)
+	if err != nil {
+		return view{}, params, err
+	}
+
+	return view{value: c, header: currencyHeader, rows: [][]string{currencyRow(c)}}, params, nil
+}
+
+// checkBalances prints mismatches and fails if there are any, so it may be used by monitoring.
+func checkBalances(ctx context.Context, svc transfers.AdminService) (view, error) {
+	list, err := svc.CheckBalances(ctx)
+	if err != nil {
+		return view{}, err
+	}
+	v := view{value: list, header: mismatchHeader}
+	for _, m := range list {
+		v.rows = append(v.rows, mismatchRow(m))
+	}
+	if len(list) > 0 {
+		return v, errInconsistent
+	}
+
+	return v, nil
+}
+
+var mismatchHeader = []string{"ACCOUNT", "CURRENCY", "BALANCE", "EXPECTED", "DIFF"}
+
+func mismatchRow(m transfers.BalanceMismatch) []string {
+	return []string{
+		m.AccountID.String(), m.CurrencyCode, m.Balance.String(), m.Expected.String(), m.Balance.Sub(m.Expected).String(),
+	}
+}
+
+// reconcile is check-balances whose result is stored as reconciliation report shown by API,
+// report without discrepancies is printed as single row with its id only.
+func reconcile(ctx context.Context, svc reconciliation.Service) (view, error) {
+	report, err := svc.Reconcile(ctx)
+	if err != nil {
+		return view{}, err
+	}
+	v := view{value: report, header: append([]string{"REPORT"}, mismatchHeader...)}
+	for _, m := range report.Discrepancies {
+		v.rows = append(v.rows, append([]string{report.ID.String()}, mismatchRow(m)...))
+	}
+	if len(report.Discrepancies) > 0 {
+		return v, errInconsistent
+	}
+	v.rows = [][]string{{report.ID.String()}}
+
+	return v, nil
+}
+
+// checkConservation prints totals of currencies and fails if money of some one isn't conserved.
+func checkConservation(ctx context.Context, svc transfers.AdminService) (view, error) {
+	totals, err := svc.CheckConservation(ctx)
+	if err != nil {
+		return view{}, err
+	}
+	v := view{
+		value:  totals,
+		header: []string{"CURRENCY", "BALANCES", "DEPOSITS", "WITHDRAWALS", "CONSERVED"},
+	}
+	for _, t := range totals {
+		v.rows = append(v.rows, []string{
+			t.CurrencyCode, t.Balances.String(), t.Deposits.String(), t.Withdrawals.String(), fmt.Sprint(t.Conserved),
+		})
+		if !t.Conserved {
+			err = errNotConserved
+		}
+	}
+
+	return v, err
+}
+
+// audited commands change state, they are recorded to audit trail like api calls.
+var audited = map[string]bool{
+	"create-account":   true,
+	"adjust":           true,
+	"freeze":           true,
+	"unfreeze":         true,
+	"set-currency":     true,
+	"disable-currency": true,
+	"enable-currency":  true,
+}
+
+// record adds command with parameters it's resolved to (or arguments as given if it isn't) to audit trail,
+// operator is identified by os user.
+func record(ctx context.Context, svc audit.Service, command string, params interface{}, args []string, failure error) error {
+	if params == nil {
+		params = map[string]interface{}{"args": args}
+	}
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	e := audit.NewEntry(ctx, "walletctl."+command, payload, failure)
+	e.ClientID = "walletctl"
+	if u, err := user.Current(); err == nil {
+		e.ClientID += ":" + u.Username
+	}
+	e.UserAgent = "walletctl"
+	_, err = svc.Record(ctx, e)
+
+	return err
+}
+
+// auditVerify recomputes hash chain of audit trail and fails if some entry was tampered with.
+func auditVerify(ctx context.Context, svc audit.Service) (view, error) {
+	v, err := svc.Verify(ctx)
+	if err != nil && !errors.Is(err, audit.ErrChainBroken) {
+		return view{}, err
+	}
+	broken := ""
+	if v.BrokenID > 0 {
+		broken = fmt.Sprint(v.BrokenID)
+	}
+
+	return view{
+		value:  v,
+		header: []string{"ENTRIES", "LAST HASH", "BROKEN"},
+		rows:   [][]string{{fmt.Sprint(v.Entries), v.LastHash, broken}},
+	}, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/audit"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+type recorderMock struct {
+	entries []audit.Entry
+}
+
+func (m *recorderMock) Record(_ context.Context, e audit.Entry) (audit.Entry, error) {
+	m.entries = append(m.entries, e)
+
+	return e, nil
+}
+
+func (m *recorderMock) Verify(context.Context) (audit.Verification, error) {
+	return audit.Verification{}, nil
+}
+
+func TestRecord_ResolvedParams(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	svc := transfers.NewAdminService(transfers.NewMemoryRepository())
+	account, err := svc.CreateAccount(ctx, nil, "USD")
+	a.NoError(err)
+	recorder := &recorderMock{}
+
+	args := []string{"-account", account.ID.String(), "-amount", "10", "-reason", "opening"}
+	_, params, err := adjust(ctx, svc, args)
+	a.NoError(err)
+	a.NoError(record(ctx, recorder, "adjust", params, args, err))
+	a.Len(recorder.entries, 1)
+	var adj transfers.Adjustment
+	a.NoError(json.Unmarshal(recorder.entries[0].Payload, &adj))
+	a.NotEqual(uuid.Nil, adj.ID)
+	a.Equal(params.(transfers.Adjustment).ID, adj.ID, "generated adjustment id is recorded")
+	a.Equal(account.ID, adj.AccountID)
+	a.True(decimal.RequireFromString("10").Equal(adj.Amount))
+	a.Equal("walletctl.adjust", recorder.entries[0].Action)
+	a.Equal(audit.OutcomeOK, recorder.entries[0].Outcome)
+	_, err = svc.GetTransfer(ctx, adj.ID)
+	a.NoError(err, "recorded id is id of adjustment transfer")
+
+	args = []string{"-account", "nope", "-amount", "10"}
+	_, params, err = adjust(ctx, svc, args)
+	a.Error(err)
+	a.NoError(record(ctx, recorder, "adjust", params, args, err))
+	a.JSONEq(`{"args":["-account","nope","-amount","10"]}`, string(recorder.entries[1].Payload),
+		"arguments as given are recorded if they can't be resolved")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+const timeFormat = time.RFC3339
+
+// view is result of command, value is printed as JSON and header with rows as table.
+type view struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+}
+
+func (v view) write(w io.Writer, output string) error {
+	if output == outputJSON {
+		if rv := reflect.ValueOf(v.value); rv.Kind() == reflect.Slice && rv.IsNil() {
+			v.value = []struct{}{} // empty list instead of null
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v.value)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) // nolint gomnd
+	fmt.Fprintln(tw, strings.Join(v.header, "\t"))
+	for _, r := range v.rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+func TestView_Write(t *testing.T) {
+	a := assert.New(t)
+	id, account := uuid.Must(uuid.Parse("616f2ce5-ed3b-4888-9fad-81e66fa08c26")), uuid.Must(uuid.Parse("1836981e-7bce-4356-99a5-a001073e51fe"))
+	reason := "refund"
+	v := transferView(transfers.TransferDetails{
+		Transfer: transfers.Transfer{ID: id, Type: transfers.Deposit, Amount: decimal.RequireFromString("1.5"),
+			CurrencyCode: "USD", Reason: &reason},
+		Parts: []transfers.TransferPart{{TransferID: id, AccountID: account, Direction: transfers.Incoming}},
+	})
+
+	var table bytes.Buffer
+	a.NoError(v.write(&table, outputTable))
+	a.Equal(
+		"TRANSFER                              TYPE     ACCOUNT                               DIRECTION  CORRESPONDING  AMOUNT  CURRENCY  CREATED               REASON\n"+
+			"616f2ce5-ed3b-4888-9fad-81e66fa08c26  DEPOSIT  1836981e-7bce-4356-99a5-a001073e51fe  INCOMING                  1.5     USD       0001-01-01T00:00:00Z  refund\n",
+		table.String())
+
+	var js bytes.Buffer
+	a.NoError(v.write(&js, outputJSON))
+	a.JSONEq(`{
+  "id": "616f2ce5-ed3b-4888-9fad-81e66fa08c26",
+  "type": "DEPOSIT",
+  "amount": "1.5",
+  "currency_code": "USD",
+  "reason": "refund",
+  "created_at": "0001-01-01T00:00:00Z",
+  "parts": [{
+    "transfer_id": "616f2ce5-ed3b-4888-9fad-81e66fa08c26",
+    "account_id": "1836981e-7bce-4356-99a5-a001073e51fe",
+    "corresponding_account_id": null,
+    "direction": "INCOMING"
+  }]
+}`, js.String())
+}
+
+func TestView_WriteEmptyList(t *testing.T) {
+	a := assert.New(t)
+	var mismatches []transfers.BalanceMismatch
+
+	var js bytes.Buffer
+	a.NoError(view{value: mismatches}.write(&js, outputJSON))
+	a.JSONEq(`[]`, js.String(), "empty list isn't null")
+}
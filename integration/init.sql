@@ -1,11 +1,16 @@
 BEGIN TRANSACTION;
-INSERT INTO accounts(id, currency_code, balance)
-VALUES ('1836981E-7BCE-4356-99A5-A001073E51FE', 'USD', 1000),
-       ('8FF54AAA-31D7-4A04-908A-6FA375030432', 'USD', 100),
-       ('78C3C61F-70FA-477D-88FE-9767638B61A0', 'EUR', 100),
-       ('742DDA95-3205-49FB-8BAD-5CAC4DE9EE39', 'EUR', 0),
-       ('6D75C6A3-212B-426B-9CCA-991CBAD8A007', 'BTC', 1),
-       ('3AA42E32-1117-4533-A1B2-86714E9F842E', 'BTC', 0);
+INSERT INTO customers(id, name)
+VALUES ('5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'integration'),
+       ('A7D3E2F1-9C8B-4A6D-8E5F-1B2C3D4E5F60', 'other');
+
+INSERT INTO accounts(id, customer_id, currency_code, balance)
+VALUES ('1836981E-7BCE-4356-99A5-A001073E51FE', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'USD', 1000),
+       ('8FF54AAA-31D7-4A04-908A-6FA375030432', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'USD', 100),
+       ('78C3C61F-70FA-477D-88FE-9767638B61A0', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'EUR', 100),
+       ('742DDA95-3205-49FB-8BAD-5CAC4DE9EE39', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'EUR', 0),
+       ('6D75C6A3-212B-426B-9CCA-991CBAD8A007', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'BTC', 1),
+       ('3AA42E32-1117-4533-A1B2-86714E9F842E', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'BTC', 0),
+       ('C4B0D7E2-6A1F-4F3E-9D8C-2B7A5E6F1D03', 'A7D3E2F1-9C8B-4A6D-8E5F-1B2C3D4E5F60', 'USD', 0);
 
 INSERT INTO transfers(id, currency_code, amount, type)
 VALUES ('616F2CE5-ED3B-4888-9FAD-81E66FA08C26', 'USD', 1000, 'DEPOSIT'),
@@ -18,4 +23,10 @@ VALUES ('616F2CE5-ED3B-4888-9FAD-81E66FA08C26', '1836981E-7BCE-4356-99A5-A001073
        ('208473C8-1B85-4F41-90CE-CDC8A70023D1', '78C3C61F-70FA-477D-88FE-9767638B61A0', NULL, 'INCOMING'),
        ('FE307752-8771-4D1C-845A-3B4CAB375325', '6D75C6A3-212B-426B-9CCA-991CBAD8A007', NULL, 'INCOMING');
 
+-- key wk_integration_test_key
+INSERT INTO api_keys(id, customer_id, name, hash, scopes)
+VALUES ('0B6D4B0C-8F0C-4F7A-9E0A-3C1B6B1E5A11', '5C1E0B7A-3F4B-4E55-8B1D-6A2F7C9D0E11', 'integration',
+        'd1236e7062da09799bd5914f954a2d647aaa928f70eabe4459d7158b0cfc3530',
+        '{accounts:read,transfers:write,mandates:read,mandates:write,webhooks:manage}');
+
 END TRANSACTION;
\ No newline at end of file
@@ -19,12 +19,15 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/auth"
 	"github.com/risentveber/wallet-api/services/transfers"
 )
 
 const (
-	host = "test-api.docker.local:8080"
-	base = "http://" + host
+	host   = "test-api.docker.local:8080"
+	base   = "http://" + host
+	apiKey = "wk_integration_test_key" // seeded in init.sql
 )
 
 func integrationTestsDisabled() bool {
@@ -38,7 +41,13 @@ func makePost(path string, requestData map[string]string) (int, transfers.Common
 		return 0, result, err
 	}
 
-	res, err := http.Post(base+path, "application/json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequest(http.MethodPost, base+path, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return 0, result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.APIKeyHeader, apiKey)
+	res, err := http.DefaultClient.Do(req)
 
 	if err != nil {
 		return 0, result, err
@@ -55,7 +64,12 @@ func makePost(path string, requestData map[string]string) (int, transfers.Common
 
 func makeGet(path string) (int, transfers.CommonResponse, error) {
 	result := transfers.CommonResponse{}
-	res, err := http.Get(base + path)
+	req, err := http.NewRequest(http.MethodGet, base+path, nil)
+	if err != nil {
+		return 0, result, err
+	}
+	req.Header.Set(auth.APIKeyHeader, apiKey)
+	res, err := http.DefaultClient.Do(req)
 
 	if err != nil {
 		return 0, result, err
@@ -76,11 +90,7 @@ func applyMigrations(logger log.Logger) error {
 		return err
 	}
 	defer db.Close()
-	migrate.SetTable("migrations")
-	migrations := &migrate.FileMigrationSource{
-		Dir: "../migrations",
-	}
-	n, err := migrate.Exec(db, "postgres", migrations, migrate.Up)
+	n, err := migrations.Set.Exec(db, migrations.Dialect, migrations.Source, migrate.Up)
 
 	if err != nil {
 		return err
@@ -132,7 +142,10 @@ func TestIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("preparation fail %s", err.Error())
 	}
+	t.Run("Probes", testProbes)
+	t.Run("Unauthenticated", testUnauthenticated)
 	t.Run("ListAccounts", testListAccounts)
+	t.Run("ListCurrencies", testListCurrencies)
 	t.Run("Ops1", generateCheckTransferCount("1836981E-7BCE-4356-99A5-A001073E51FE", 1, 0, "1000"))
 	t.Run("Balance1", generateCheckBalance("1836981E-7BCE-4356-99A5-A001073E51FE", "1000USD"))
 	t.Run("Balance2", generateCheckBalance("8FF54AAA-31D7-4A04-908A-6FA375030432", "100USD"))
@@ -150,6 +163,14 @@ func TestIntegration(t *testing.T) {
 			"8FF54AAA-31D7-4A04-908A-6FA375030432",
 			"1001", "USD", "insufficient_funds",
 		))
+	t.Run("TransferFromForeignAccount",
+		generateTransfer(
+			"0E2F5C4A-8B7D-4C1E-9F3A-6D5B4C3A2E10",
+			"C4B0D7E2-6A1F-4F3E-9D8C-2B7A5E6F1D03",
+			"1836981E-7BCE-4356-99A5-A001073E51FE",
+			"1", "USD", "sender_account_not_exist",
+		))
+	t.Run("TransfersOfForeignAccount", testTransfersOfForeignAccount)
 	t.Run("TransferOK",
 		generateTransfer(
 			"EE795FCB-F656-4E2B-A095-4B872670D6F7",
@@ -176,6 +197,24 @@ func TestIntegration(t *testing.T) {
 	// DB in container is cleared outside tests
 }
 
+func testUnauthenticated(t *testing.T) {
+	a := assert.New(t)
+	res, err := http.Get(base + "/accounts/")
+	a.NoError(err)
+	defer res.Body.Close()
+	a.Equal(http.StatusUnauthorized, res.StatusCode)
+}
+
+func testProbes(t *testing.T) {
+	a := assert.New(t)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get(base + path)
+		a.NoError(err)
+		_ = res.Body.Close()
+		a.Equal(http.StatusOK, res.StatusCode, path+" doesn't require authentication, migrations are applied")
+	}
+}
+
 func testListAccounts(t *testing.T) {
 	a := assert.New(t)
 	status, res, err := makeGet("/accounts/")
@@ -184,7 +223,26 @@ func testListAccounts(t *testing.T) {
 	a.Equal("", res.Error)
 	a.Equal("OK", res.Result)
 	accounts, _ := res.Payload.([]interface{})
-	a.Equal(6, len(accounts), "must return created in init.sql")
+	a.Equal(6, len(accounts), "must return created in init.sql for the customer only")
+}
+
+func testListCurrencies(t *testing.T) {
+	a := assert.New(t)
+	status, res, err := makeGet("/currencies/")
+	a.NoError(err)
+	a.Equal(http.StatusOK, status)
+	a.Equal("OK", res.Result)
+	currencies, _ := res.Payload.([]interface{})
+	a.Equal(3, len(currencies), "must return ones created by migration")
+}
+
+func testTransfersOfForeignAccount(t *testing.T) {
+	a := assert.New(t)
+	status, res, err := makeGet("/accounts/C4B0D7E2-6A1F-4F3E-9D8C-2B7A5E6F1D03/transfers/")
+	a.NoError(err)
+	a.Equal(http.StatusOK, status)
+	a.Equal("ERROR", res.Result)
+	a.Equal("account_not_exist", res.Error)
 }
 
 func generateCheckTransferCount(id string, incoming, outgoing int, totalTurnover string) func(t *testing.T) {
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- transfers in disabled currency aren't accepted, existing accounts and history stay readable
+ALTER TABLE currencies ADD COLUMN disabled boolean not null default false;
+
+-- +migrate Down
+ALTER TABLE currencies DROP COLUMN disabled;
@@ -0,0 +1,21 @@
+-- +migrate Up
+-- +migrate StatementBegin
+CREATE FUNCTION notify_currencies() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('currencies', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- replicas drop cached currencies on notification, it's delivered on commit
+CREATE TRIGGER currencies_notify
+    AFTER INSERT OR UPDATE OR DELETE
+    ON currencies
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE notify_currencies();
+
+-- +migrate Down
+DROP TRIGGER currencies_notify ON currencies;
+DROP FUNCTION notify_currencies();
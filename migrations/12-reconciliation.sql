@@ -0,0 +1,15 @@
+-- +migrate Up
+-- report of each reconciliation run, accounts whose balance differs from sum of their transfer parts
+CREATE TABLE reconciliation_reports
+(
+    id                  uuid PRIMARY KEY,
+    started_at          timestamp not null,
+    finished_at         timestamp not null,
+    discrepancies_count integer   not null,
+    discrepancies       jsonb     not null -- array of {account_id, currency_code, balance, expected}
+);
+CREATE INDEX reconciliation_reports_by_started_at ON reconciliation_reports (started_at);
+
+-- +migrate Down
+DROP INDEX reconciliation_reports_by_started_at;
+DROP TABLE reconciliation_reports;
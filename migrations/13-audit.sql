@@ -0,0 +1,45 @@
+-- +migrate Up
+-- tamper-evident trail of actions, each entry is chained to previous one by hash
+CREATE TABLE audit_log
+(
+    id            bigserial PRIMARY KEY,
+    -- text is unbounded, so client supplied values never fail recording
+    client_id     text        not null, -- empty for internal calls
+    customer_id   uuid,
+    action        text        not null,
+    payload       json        not null, -- not jsonb, text is kept as it's hashed
+    ip            text        not null,
+    forwarded_for text        not null,
+    user_agent    text        not null,
+    outcome       text        not null,
+    created_at    timestamp   not null,
+    prev_hash     varchar(64) not null,
+    hash          varchar(64) not null UNIQUE
+);
+
+-- +migrate StatementBegin
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER audit_log_no_update
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+EXECUTE PROCEDURE audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE audit_log_append_only();
+
+-- +migrate Down
+DROP TRIGGER audit_log_no_truncate ON audit_log;
+DROP TRIGGER audit_log_no_update ON audit_log;
+DROP FUNCTION audit_log_append_only();
+DROP TABLE audit_log;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- token buckets shared by replicas, e.g. client:<client id>, rows of idle keys may be deleted any time
+-- as absent bucket is full
+CREATE TABLE rate_limit_buckets
+(
+    key        text             PRIMARY KEY,
+    tokens     double precision not null,
+    allowed    boolean          not null, -- whether last call took token
+    updated_at timestamp        not null
+);
+
+-- +migrate Down
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,37 @@
+-- +migrate Up
+CREATE TYPE mandate_status AS ENUM ('ACTIVE', 'PAUSED', 'CANCELLED');
+
+CREATE TABLE mandates
+(
+    id                  uuid PRIMARY KEY,
+    sender_account_id   uuid           not null references accounts (id),
+    receiver_account_id uuid           not null references accounts (id),
+    amount              decimal        not null check ( amount > 0 ),
+    currency_code       varchar(4)     not null references currencies (code),
+    schedule            varchar(64)    not null,
+    status              mandate_status not null default 'ACTIVE',
+    next_run_at         timestamp      not null,
+    created_at          timestamp      not null default now(),
+    updated_at          timestamp      not null default now()
+);
+CREATE INDEX mandates_by_next_run_at on mandates (next_run_at) WHERE status = 'ACTIVE';
+
+CREATE TYPE occurrence_status AS ENUM ('SUCCEEDED', 'FAILED');
+
+CREATE TABLE mandate_occurrences
+(
+    mandate_id   uuid              not null references mandates (id),
+    scheduled_at timestamp         not null,
+    transfer_id  uuid              not null, -- deterministic, transfer may be absent in case of failure
+    status       occurrence_status not null,
+    error        varchar(64)       not null default '',
+    created_at   timestamp         not null default now(),
+    PRIMARY KEY (mandate_id, scheduled_at)
+);
+
+-- +migrate Down
+DROP TABLE mandate_occurrences;
+DROP TYPE occurrence_status;
+DROP INDEX mandates_by_next_run_at;
+DROP TABLE mandates;
+DROP TYPE mandate_status;
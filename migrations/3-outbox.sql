@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE outbox
+(
+    id           bigserial PRIMARY KEY,
+    type         varchar(64) not null,
+    account_ids  uuid[]      not null default '{}',
+    payload      jsonb       not null,
+    created_at   timestamp   not null default now(),
+    published_at timestamp
+);
+CREATE INDEX outbox_unpublished on outbox (id) WHERE published_at IS NULL;
+
+-- +migrate Down
+DROP INDEX outbox_unpublished;
+DROP TABLE outbox;
@@ -0,0 +1,36 @@
+-- +migrate Up
+CREATE TABLE webhook_subscriptions
+(
+    id          uuid PRIMARY KEY,
+    url         varchar(2048) not null,
+    event_types varchar(64)[] not null default '{}', -- empty means all events
+    secret      varchar(128)  not null,
+    active      boolean       not null default true,
+    created_at  timestamp     not null default now()
+);
+
+CREATE TYPE delivery_status AS ENUM ('PENDING', 'SUCCEEDED', 'DEAD');
+
+CREATE TABLE webhook_deliveries
+(
+    id               uuid PRIMARY KEY,
+    subscription_id  uuid            not null references webhook_subscriptions (id),
+    event_id         bigint          not null references outbox (id),
+    event_type       varchar(64)     not null,
+    body             jsonb           not null,
+    status           delivery_status not null default 'PENDING',
+    attempts         int             not null default 0,
+    last_status_code int             not null default 0,
+    last_error       varchar(1024)   not null default '',
+    next_attempt_at  timestamp       not null default now(),
+    created_at       timestamp       not null default now(),
+    updated_at       timestamp       not null default now(),
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX webhook_deliveries_pending on webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+
+-- +migrate Down
+DROP INDEX webhook_deliveries_pending;
+DROP TABLE webhook_deliveries;
+DROP TYPE delivery_status;
+DROP TABLE webhook_subscriptions;
@@ -0,0 +1,25 @@
+-- +migrate Up
+-- +migrate StatementBegin
+CREATE FUNCTION notify_outbox() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('outbox', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- notification is delivered on commit, duplicates inside transaction are folded
+CREATE TRIGGER outbox_notify
+    AFTER INSERT
+    ON outbox
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE notify_outbox();
+
+-- for resuming of account activity streams
+CREATE INDEX outbox_by_account_ids on outbox USING GIN (account_ids);
+
+-- +migrate Down
+DROP INDEX outbox_by_account_ids;
+DROP TRIGGER outbox_notify ON outbox;
+DROP FUNCTION notify_outbox();
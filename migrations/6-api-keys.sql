@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE api_keys
+(
+    id         uuid PRIMARY KEY,
+    name       varchar(256)  not null,
+    hash       varchar(64)   not null UNIQUE, -- sha256 of the key, key itself is never stored
+    scopes     varchar(64)[] not null default '{}',
+    created_at timestamp     not null default now(),
+    revoked_at timestamp
+);
+
+-- +migrate Down
+DROP TABLE api_keys;
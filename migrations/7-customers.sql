@@ -0,0 +1,24 @@
+-- +migrate Up
+CREATE TABLE customers
+(
+    id         uuid PRIMARY KEY,
+    name       varchar(256) not null,
+    created_at timestamp    not null default now()
+);
+
+-- customer is a tenant, accounts without one are accessible only internally
+ALTER TABLE accounts ADD COLUMN customer_id uuid references customers (id);
+CREATE INDEX accounts_by_customer_id on accounts (customer_id, updated_at);
+
+-- keys without customer own no accounts
+ALTER TABLE api_keys ADD COLUMN customer_id uuid references customers (id);
+
+-- subscriptions without customer get events of all accounts
+ALTER TABLE webhook_subscriptions ADD COLUMN customer_id uuid references customers (id);
+
+-- +migrate Down
+ALTER TABLE webhook_subscriptions DROP COLUMN customer_id;
+ALTER TABLE api_keys DROP COLUMN customer_id;
+DROP INDEX accounts_by_customer_id;
+ALTER TABLE accounts DROP COLUMN customer_id;
+DROP TABLE customers;
@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE api_keys ADD COLUMN signing_secret varchar(128); -- null if key isn't used for signing requests
+
+CREATE TABLE request_nonces
+(
+    key_id     uuid         not null,
+    nonce      varchar(128) not null,
+    expires_at timestamp    not null,
+    PRIMARY KEY (key_id, nonce)
+);
+
+-- +migrate Down
+DROP TABLE request_nonces;
+ALTER TABLE api_keys DROP COLUMN signing_secret;
@@ -0,0 +1,10 @@
+-- +migrate Up
+-- frozen accounts can't send or receive transfers, manual adjustments are still possible
+ALTER TABLE accounts ADD COLUMN frozen boolean not null default false;
+
+-- reason of manual adjustment, null for transfers ordered by clients
+ALTER TABLE transfers ADD COLUMN reason text;
+
+-- +migrate Down
+ALTER TABLE transfers DROP COLUMN reason;
+ALTER TABLE accounts DROP COLUMN frozen;
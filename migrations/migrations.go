@@ -0,0 +1,93 @@
+// Package migrations embeds SQL migrations of db schema, they are applied by `api migrate`
+// (or -autoMigrate) and are compatible with sql-migrate tool.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	_ "github.com/jackc/pgx/v4/stdlib" // pgx driver
+	_ "github.com/lib/pq"              // postgres driver
+	migrate "github.com/rubenv/sql-migrate"
+	_ "modernc.org/sqlite" // pure Go sqlite driver
+)
+
+// Table keeps applied migrations, it's the same as sql-migrate uses per dbconfig.yml,
+// so databases migrated by the tool keep working.
+const Table = "migrations"
+
+// Dialect of sql-migrate for postgres.
+const Dialect = "postgres"
+
+// SQLiteDialect of sql-migrate for sqlite.
+const SQLiteDialect = "sqlite3"
+
+// Supported database/sql drivers, Postgres (lib/pq) and Pgx work with the same postgres db.
+const (
+	Postgres = "postgres"
+	Pgx      = "pgx"
+	SQLite   = "sqlite"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// Source of all migrations service expects to be applied.
+var Source migrate.MigrationSource = &migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(files)}
+
+// SQLiteSource of migrations of tables wallet uses with sqlite.
+var SQLiteSource migrate.MigrationSource = &migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(sub(sqliteFiles, "sqlite"))}
+
+// Set applies migrations to Table.
+var Set = migrate.MigrationSet{TableName: Table}
+
+func sub(f fs.FS, dir string) fs.FS {
+	s, err := fs.Sub(f, dir)
+	if err != nil {
+		panic(err) // dir is embedded
+	}
+
+	return s
+}
+
+// For gives sql-migrate dialect and migrations of driver.
+func For(driver string) (string, migrate.MigrationSource, error) {
+	switch driver {
+	case Postgres, Pgx:
+		return Dialect, Source, nil
+	case SQLite:
+		return SQLiteDialect, SQLiteSource, nil
+	default:
+		return "", nil, fmt.Errorf("unknown db driver %q, use %s|%s|%s", driver, Postgres, Pgx, SQLite)
+	}
+}
+
+// sqliteParams are required by sqlite repositories: foreign keys are checked, locked database is waited for
+// and times are written in format sqlite functions understand.
+const sqliteParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+// Open gives db of driver, for sqlite dsn is a file path (optionally with driver params after '?').
+func Open(driver, dsn string) (*sql.DB, error) {
+	switch driver {
+	case Postgres, Pgx:
+		return sql.Open(driver, dsn)
+	case SQLite:
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+
+		return sql.Open(driver, dsn+separator+sqliteParams)
+	default:
+		_, _, err := For(driver)
+
+		return nil, err
+	}
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"path/filepath"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource(t *testing.T) {
+	a := assert.New(t)
+	files, err := filepath.Glob("*.sql")
+	a.NoError(err)
+
+	all, err := Source.FindMigrations()
+	a.NoError(err)
+	ids := make([]string, 0, len(all))
+	for _, m := range all {
+		ids = append(ids, m.Id)
+		a.NotEmpty(m.Up, m.Id+" has up statements")
+		a.NotEmpty(m.Down, m.Id+" has down statements")
+	}
+	a.ElementsMatch(files, ids, "all migrations are embedded")
+	a.Equal("1-initial.sql", ids[0], "migrations are ordered by number")
+}
+
+func TestSQLiteSource(t *testing.T) {
+	a := assert.New(t)
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "wallet.db"))
+	a.NoError(err)
+	defer db.Close()
+
+	dialect, source, err := For(SQLite)
+	a.NoError(err)
+	n, err := Set.Exec(db, dialect, source, migrate.Up)
+	a.NoError(err)
+	a.Equal(1, n)
+	var count int
+	a.NoError(db.QueryRow(`SELECT count(*) FROM currencies`).Scan(&count))
+	a.Equal(3, count)
+	records, err := Set.GetMigrationRecords(db, dialect)
+	a.NoError(err)
+	a.Len(records, 1)
+	a.False(records[0].AppliedAt.IsZero())
+	n, err = Set.Exec(db, dialect, source, migrate.Down)
+	a.NoError(err)
+	a.Equal(1, n, "migrations are reversible")
+
+	_, err = Open("mysql", "")
+	a.Error(err)
+}
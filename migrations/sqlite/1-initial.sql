@@ -0,0 +1,86 @@
+-- +migrate Up
+-- schema of tables wallet uses with sqlite (transfers, outbox and api keys) equivalent to postgres migrations,
+-- decimals are kept as text, so they aren't rounded to floats, timestamps are UTC text with milliseconds
+CREATE TABLE currencies
+(
+    code      text PRIMARY KEY,
+    precision integer not null,
+    disabled  boolean not null default false
+);
+INSERT INTO currencies(code, precision)
+VALUES ('USD', 2),
+       ('EUR', 2),
+       ('BTC', 8);
+
+CREATE TABLE customers
+(
+    id         text PRIMARY KEY,
+    name       text      not null,
+    created_at timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE accounts
+(
+    id            text PRIMARY KEY,
+    customer_id   text references customers (id),
+    currency_code text      not null references currencies (code),
+    balance       text      not null default '0' check ( cast(balance AS real) >= 0 ),
+    frozen        boolean   not null default false,
+    created_at    timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at    timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX accounts_by_updated_at on accounts (updated_at);
+CREATE INDEX accounts_by_customer_id on accounts (customer_id, updated_at);
+
+CREATE TABLE transfers
+(
+    id            text PRIMARY KEY,
+    type          text      not null check ( type IN ('DEPOSIT', 'WITHDRAW', 'INTERNAL') ),
+    amount        text      not null check ( cast(amount AS real) > 0 ),
+    currency_code text      not null references currencies (code),
+    reason        text,
+    created_at    timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE transfer_parts
+(
+    transfer_id              text not null references transfers (id),
+    account_id               text not null references accounts (id),
+    corresponding_account_id text references accounts (id), -- may be null in case of Deposit/Withdrawal
+    direction                text not null check ( direction IN ('INCOMING', 'OUTGOING') ),
+    PRIMARY KEY (transfer_id, account_id)
+);
+CREATE INDEX transfer_parts_by_account_id on transfer_parts (account_id);
+
+CREATE TABLE outbox
+(
+    id           integer PRIMARY KEY AUTOINCREMENT,
+    type         text      not null,
+    account_ids  text      not null default '[]', -- JSON array
+    payload      text      not null,
+    created_at   timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    published_at timestamp
+);
+
+CREATE TABLE api_keys
+(
+    id             text PRIMARY KEY,
+    customer_id    text references customers (id),
+    name           text      not null,
+    hash           text      not null UNIQUE, -- sha256 of the key, key itself is never stored
+    signing_secret text,                      -- null if key isn't used for signing requests
+    scopes         text      not null default '[]', -- JSON array
+    created_at     timestamp not null,
+    revoked_at     timestamp
+);
+
+-- +migrate Down
+DROP TABLE api_keys;
+DROP TABLE outbox;
+DROP TABLE transfer_parts;
+DROP TABLE transfers;
+DROP INDEX accounts_by_customer_id;
+DROP INDEX accounts_by_updated_at;
+DROP TABLE accounts;
+DROP TABLE customers;
+DROP TABLE currencies;
@@ -0,0 +1,100 @@
+package activity
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+// subscriber buffer, slow subscriber is dropped when it's exceeded.
+const subscriberBuffer = 64
+
+// Subscription receives events affecting the account until channel is closed.
+// Channel is closed when broker is closed or subscriber is too slow,
+// in both cases client is supposed to resume from the last received event.
+type Subscription struct {
+	AccountID uuid.UUID
+	Events    chan outbox.Event
+}
+
+// Broker fans out committed events to in-process subscribers of accounts.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[uuid.UUID]map[*Subscription]struct{})}
+}
+
+// Subscribe returns subscription with already closed channel if broker is closed.
+func (b *Broker) Subscribe(accountID uuid.UUID) *Subscription {
+	s := &Subscription{AccountID: accountID, Events: make(chan outbox.Event, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.Events)
+
+		return s
+	}
+	if b.subscribers[accountID] == nil {
+		b.subscribers[accountID] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[accountID][s] = struct{}{}
+
+	return s
+}
+
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s)
+}
+
+// remove must be called under lock.
+func (b *Broker) remove(s *Subscription) {
+	subscribers := b.subscribers[s.AccountID]
+	if _, ok := subscribers[s]; !ok {
+		return
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(b.subscribers, s.AccountID)
+	}
+	close(s.Events)
+}
+
+// Publish never blocks on subscribers, so broker is an outbox.EventPublisher.
+func (b *Broker) Publish(_ context.Context, events []outbox.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		for _, accountID := range e.AccountIDs {
+			for s := range b.subscribers[accountID] {
+				select {
+				case s.Events <- e:
+				default:
+					b.remove(s)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close ends all subscriptions, used on server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subscribers := range b.subscribers {
+		for s := range subscribers {
+			b.remove(s)
+		}
+	}
+}
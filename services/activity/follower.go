@@ -0,0 +1,97 @@
+package activity
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+const followBatchSize = 100
+
+// OutboxChannel is notified by trigger on each transaction inserting into outbox.
+const OutboxChannel = "outbox"
+
+// Follower tails outbox table of the whole system and passes committed events to broker,
+// so every replica streams every event unlike outbox relay publishing each one once.
+// Polling is triggered by ticker or by notification (e.g. pgnotify.Listen of OutboxChannel).
+type Follower struct {
+	repo     outbox.Repository
+	broker   *Broker
+	interval time.Duration
+	notify   <-chan struct{}
+	// sequence gap may be caused by not yet committed transaction,
+	// so the follower waits for it this long before skipping
+	gapTimeout time.Duration
+	logger     log.Logger
+
+	lastID   int64
+	gapSince time.Time
+}
+
+func NewFollower(
+	repo outbox.Repository, broker *Broker, interval, gapTimeout time.Duration,
+	notify <-chan struct{}, logger log.Logger) *Follower {
+	return &Follower{
+		repo: repo, broker: broker, interval: interval, gapTimeout: gapTimeout, notify: notify, logger: logger,
+	}
+}
+
+// Poll passes new events to broker, returns count of passed events.
+func (f *Follower) Poll(ctx context.Context, now time.Time) (int, error) {
+	events, err := f.repo.GetEventsAfter(ctx, f.lastID, followBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	ready := make([]outbox.Event, 0, len(events))
+	for _, e := range events {
+		if e.ID != f.lastID+1 {
+			if f.gapSince.IsZero() {
+				f.gapSince = now
+			}
+			if now.Sub(f.gapSince) < f.gapTimeout {
+				break
+			}
+		}
+		f.gapSince = time.Time{}
+		f.lastID = e.ID
+		ready = append(ready, e)
+	}
+
+	return len(ready), f.broker.Publish(ctx, ready)
+}
+
+// Run follows events appeared after start, blocks until ctx is done and closes broker after that.
+func (f *Follower) Run(ctx context.Context) error {
+	defer f.broker.Close()
+	lastID, err := f.repo.GetLastEventID(ctx)
+	if err != nil {
+		return err
+	}
+	f.lastID = lastID
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case _, ok := <-f.notify:
+			if !ok {
+				f.notify = nil // polling by ticker only
+			}
+		}
+		for {
+			count, err := f.Poll(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				_ = level.Error(f.logger).Log("msg", "outbox following failed", "err", err.Error())
+			}
+			if err != nil || count < followBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+const resumeBatchSize = 100
+
+var ErrStreamingNotSupported = errors.New("streaming_not_supported")
+
+// Accounts gives accounts accessible in request context, see transfers.Service.
+type Accounts interface {
+	GetAccount(ctx context.Context, accountID uuid.UUID) (transfers.Account, error)
+}
+
+type handler struct {
+	broker    *Broker
+	repo      outbox.Repository
+	accounts  Accounts
+	heartbeat time.Duration
+	logger    log.Logger
+}
+
+// NewHTTPHandler streams account activity as server-sent events, account id is taken
+// from `account_id` route variable, so handler is supposed to be mounted to
+// `/accounts/{account_id}/events`. Standard `Last-Event-ID` header resumes the stream.
+// Only accounts accessible via accounts are streamed.
+func NewHTTPHandler(
+	broker *Broker, repo outbox.Repository, accounts Accounts, heartbeat time.Duration, logger log.Logger,
+) http.Handler {
+	return handler{broker: broker, repo: repo, accounts: accounts, heartbeat: heartbeat, logger: logger}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(transfers.NewCommonResponse(nil, err))
+}
+
+func writeMessage(w http.ResponseWriter, m Message) error {
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.ID, m.Event, data)
+
+	return err
+}
+
+// stream writes event if it's meant for the account, returns id of the last written event.
+func (h handler) stream(w http.ResponseWriter, accountID uuid.UUID, lastID int64, e outbox.Event) (int64, error) {
+	if e.ID <= lastID {
+		return lastID, nil // already sent while resuming
+	}
+	m, ok, err := MessageFor(accountID, e)
+	if err != nil || !ok {
+		return lastID, err
+	}
+
+	return e.ID, writeMessage(w, m)
+}
+
+func (h handler) resume(ctx context.Context, w http.ResponseWriter, accountID uuid.UUID, lastID int64) (int64, error) {
+	for {
+		events, err := h.repo.GetAccountEventsAfter(ctx, accountID, lastID, resumeBatchSize)
+		if err != nil {
+			return lastID, err
+		}
+		for _, e := range events {
+			if lastID, err = h.stream(w, accountID, lastID, e); err != nil {
+				return lastID, err
+			}
+		}
+		if len(events) < resumeBatchSize {
+			return lastID, nil
+		}
+	}
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accountID, err := uuid.Parse(mux.Vars(r)["account_id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+	_, err = h.accounts.GetAccount(r.Context(), accountID)
+	if err == transfers.ErrAccountNotExists {
+		writeError(w, http.StatusNotFound, err)
+
+		return
+	}
+	if err != nil {
+		_ = level.Error(h.logger).Log("msg", "activity stream account check failed", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+	var lastID int64
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID != "" {
+		if lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, ErrStreamingNotSupported)
+
+		return
+	}
+
+	// subscribe before resuming, so nothing is lost in between
+	sub := h.broker.Subscribe(accountID)
+	defer h.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if lastEventID != "" {
+		if lastID, err = h.resume(r.Context(), w, accountID, lastID); err != nil {
+			_ = level.Error(h.logger).Log("msg", "activity stream resume failed", "err", err.Error())
+
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				return // server stops or client is too slow, client resumes with Last-Event-ID
+			}
+			if lastID, err = h.stream(w, accountID, lastID, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
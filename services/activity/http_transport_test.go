@@ -0,0 +1,226 @@
+package activity
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+// outboxMock keeps committed events in order.
+type outboxMock struct {
+	events []outbox.Event
+}
+
+func (m *outboxMock) PublishBatch(context.Context, uint, func([]outbox.Event) error) (int, error) {
+	return 0, nil
+}
+
+func (m *outboxMock) DeletePublished(context.Context, time.Duration, uint) (int, error) {
+	return 0, nil
+}
+
+func (m *outboxMock) GetEventsAfter(_ context.Context, afterID int64, limit uint) ([]outbox.Event, error) {
+	var res []outbox.Event
+	for _, e := range m.events {
+		if e.ID > afterID && uint(len(res)) < limit {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func (m *outboxMock) GetAccountEventsAfter(
+	ctx context.Context, accountID uuid.UUID, afterID int64, limit uint) ([]outbox.Event, error) {
+	var res []outbox.Event
+	for _, e := range m.events {
+		for _, id := range e.AccountIDs {
+			if id == accountID && e.ID > afterID && uint(len(res)) < limit {
+				res = append(res, e)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (m *outboxMock) GetLastEventID(context.Context) (int64, error) {
+	if len(m.events) == 0 {
+		return 0, nil
+	}
+	return m.events[len(m.events)-1].ID, nil
+}
+
+// accountsMock gives access to all accounts except foreign ones.
+type accountsMock struct {
+	foreign uuid.UUID
+}
+
+func (m accountsMock) GetAccount(_ context.Context, accountID uuid.UUID) (transfers.Account, error) {
+	if accountID == m.foreign {
+		return transfers.Account{}, transfers.ErrAccountNotExists
+	}
+	return transfers.Account{ID: accountID}, nil
+}
+
+func transferEvents(id int64, sender, receiver uuid.UUID) []outbox.Event {
+	transferID := uuid.New()
+	created, _ := outbox.NewEvent(transfers.TransferCreated, transfers.TransferCreatedEvent{
+		ID: transferID, Type: transfers.Internal, SenderAccountID: sender, ReceiverAccountID: receiver,
+		Amount: decimal.New(5, 0), CurrencyCode: "USD",
+	}, sender, receiver)
+	created.ID = id
+	balance, _ := outbox.NewEvent(transfers.BalanceChanged, transfers.BalanceChangedEvent{
+		AccountID: receiver, TransferID: transferID, Diff: decimal.New(5, 0), Balance: decimal.New(5, 0),
+		CurrencyCode: "USD",
+	}, receiver)
+	balance.ID = id + 1
+	return []outbox.Event{created, balance}
+}
+
+func newTestServer(broker *Broker, repo outbox.Repository, accounts Accounts) *httptest.Server {
+	r := mux.NewRouter()
+	r.Handle("/accounts/{account_id}/events", NewHTTPHandler(broker, repo, accounts, time.Hour, testLogger))
+	return httptest.NewServer(r)
+}
+
+type sse struct {
+	id    string
+	event string
+	data  map[string]interface{}
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) sse {
+	var e sse
+	for {
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.data))
+		}
+	}
+}
+
+func TestStream_LiveAndResume(t *testing.T) {
+	a := assert.New(t)
+	sender, receiver := uuid.New(), uuid.New()
+	repo := &outboxMock{events: transferEvents(1, sender, receiver)}
+	broker := NewBroker()
+	server := newTestServer(broker, repo, accountsMock{})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/accounts/"+receiver.String()+"/events", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	res, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	defer res.Body.Close()
+	a.Equal("text/event-stream", res.Header.Get("Content-Type"))
+	reader := bufio.NewReader(res.Body)
+
+	e := readEvent(t, reader)
+	a.Equal("1", e.id, "resumed from db")
+	a.Equal(TransferMessage, e.event)
+	a.Equal(transfers.Incoming, e.data["direction"])
+	a.Equal(sender.String(), e.data["corresponding_account_id"])
+	e = readEvent(t, reader)
+	a.Equal("2", e.id)
+	a.Equal(BalanceMessage, e.event)
+	a.Equal("5", e.data["balance"])
+
+	live := transferEvents(3, receiver, sender)
+	a.NoError(broker.Publish(context.Background(), live))
+	e = readEvent(t, reader)
+	a.Equal("3", e.id, "balance change of another account is skipped")
+	a.Equal(transfers.Outgoing, e.data["direction"])
+
+	broker.Close()
+	_, err = reader.ReadString('\n')
+	a.Error(err, "stream ends on broker close")
+}
+
+func TestStream_InvalidAccount(t *testing.T) {
+	a := assert.New(t)
+	server := newTestServer(NewBroker(), &outboxMock{}, accountsMock{})
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/accounts/not-uuid/events")
+	a.NoError(err)
+	defer res.Body.Close()
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+}
+
+func TestStream_ForeignAccount(t *testing.T) {
+	a := assert.New(t)
+	foreign := uuid.New()
+	server := newTestServer(NewBroker(), &outboxMock{}, accountsMock{foreign: foreign})
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/accounts/" + foreign.String() + "/events")
+	a.NoError(err)
+	defer res.Body.Close()
+	a.Equal(http.StatusNotFound, res.StatusCode)
+}
+
+func TestFollower_WaitsForGap(t *testing.T) {
+	a := assert.New(t)
+	account := uuid.New()
+	repo := &outboxMock{events: []outbox.Event{
+		{ID: 1, AccountIDs: []uuid.UUID{account}},
+		{ID: 3, AccountIDs: []uuid.UUID{account}},
+	}}
+	broker := NewBroker()
+	sub := broker.Subscribe(account)
+	follower := NewFollower(repo, broker, time.Second, 10*time.Second, nil, testLogger)
+
+	now := time.Now()
+	count, err := follower.Poll(context.Background(), now)
+	a.NoError(err)
+	a.Equal(1, count, "event after gap waits for possibly not committed one")
+	count, err = follower.Poll(context.Background(), now.Add(5*time.Second))
+	a.NoError(err)
+	a.Equal(0, count)
+	count, err = follower.Poll(context.Background(), now.Add(11*time.Second))
+	a.NoError(err)
+	a.Equal(1, count, "gap is skipped after timeout")
+	a.Equal(int64(1), (<-sub.Events).ID)
+	a.Equal(int64(3), (<-sub.Events).ID)
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	a := assert.New(t)
+	account := uuid.New()
+	broker := NewBroker()
+	sub := broker.Subscribe(account)
+	for i := 0; i <= subscriberBuffer; i++ {
+		a.NoError(broker.Publish(context.Background(), []outbox.Event{{ID: int64(i), AccountIDs: []uuid.UUID{account}}}))
+	}
+	var received int
+	for range sub.Events {
+		received++
+	}
+	a.Equal(subscriberBuffer, received, "channel closed after buffer is exceeded")
+	broker.Unsubscribe(sub) // safe after drop
+}
@@ -0,0 +1,67 @@
+package activity
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+// Server-sent event types.
+const (
+	TransferMessage = "transfer"
+	BalanceMessage  = "balance"
+)
+
+// Message is a single server-sent event for account stream.
+type Message struct {
+	ID    int64
+	Event string
+	Data  interface{}
+}
+
+// MessageFor converts outbox event to message of the account stream,
+// returns false if event must not be streamed to the account.
+func MessageFor(accountID uuid.UUID, e outbox.Event) (Message, bool, error) {
+	switch e.Type {
+	case transfers.TransferCreated:
+		var payload transfers.TransferCreatedEvent
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			return Message{}, false, err
+		}
+		info := transfers.TransferInfo{
+			ID:           payload.ID,
+			AccountID:    accountID,
+			Type:         payload.Type,
+			Amount:       payload.Amount,
+			CurrencyCode: payload.CurrencyCode,
+			CreatedAt:    e.CreatedAt,
+		}
+		switch accountID {
+		case payload.SenderAccountID:
+			info.Direction = transfers.Outgoing
+			info.CorrespondingAccountID = &payload.ReceiverAccountID
+		case payload.ReceiverAccountID:
+			info.Direction = transfers.Incoming
+			info.CorrespondingAccountID = &payload.SenderAccountID
+		default:
+			return Message{}, false, nil
+		}
+
+		return Message{ID: e.ID, Event: TransferMessage, Data: info}, true, nil
+	case transfers.BalanceChanged:
+		var payload transfers.BalanceChangedEvent
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			return Message{}, false, err
+		}
+		if payload.AccountID != accountID {
+			return Message{}, false, nil
+		}
+
+		return Message{ID: e.ID, Event: BalanceMessage, Data: payload}, true, nil
+	default:
+		return Message{}, false, nil
+	}
+}
@@ -0,0 +1,91 @@
+// Package audit keeps tamper-evident trail of actions changing wallet state: each entry is appended
+// to audit_log table, which rejects updates and deletes, and is chained to previous one by hash,
+// so rewriting history of db breaks chain from the changed entry on (see Service.Verify).
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Business logic level errors that provide enough information about what went wrong.
+var (
+	ErrChainBroken = errors.New("audit_chain_broken")
+)
+
+// OutcomeOK is outcome of successful action, failed ones have error as outcome.
+const OutcomeOK = "ok"
+
+// Entry of audit trail: who did what, when, from where and with what outcome.
+type Entry struct {
+	ID int64 `json:"id"`
+	// api client or operator (walletctl:<user>) acting, empty for internal calls
+	ClientID   string     `json:"client_id"`
+	CustomerID *uuid.UUID `json:"customer_id"`
+	// service and endpoint, e.g. transfers.CreateTransfer
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"payload"`
+	// remote address and X-Forwarded-For header as received, the header may be forged by client
+	IP           string    `json:"ip"`
+	ForwardedFor string    `json:"forwarded_for"`
+	UserAgent    string    `json:"user_agent"`
+	Outcome      string    `json:"outcome"`
+	CreatedAt    time.Time `json:"created_at"`
+	PrevHash     string    `json:"prev_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// computeHash gives hex sha256 of previous hash and JSON of all entry fields but ID and Hash.
+func (e Entry) computeHash() (string, error) {
+	hashed := e
+	hashed.ID, hashed.Hash = 0, ""
+	// time is hashed in the form db gives it back
+	hashed.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+	b, err := json.Marshal(hashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash), b...))
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verification of chain, if it's broken BrokenID is the first entry whose hash doesn't match.
+type Verification struct {
+	Entries  int    `json:"entries"`
+	LastHash string `json:"last_hash"`
+	BrokenID int64  `json:"broken_id,omitempty"`
+}
+
+type Service interface {
+	// appends entry chained to the last one, ID, CreatedAt, PrevHash and Hash are set by service
+	Record(ctx context.Context, e Entry) (Entry, error)
+	// recomputes hashes of all entries, returns ErrChainBroken with verification of entries before broken one
+	Verify(ctx context.Context) (Verification, error)
+}
+
+// RequestInfo is where request came from, it's put into context by HTTPMiddleware.
+type RequestInfo struct {
+	IP           string
+	ForwardedFor string
+	UserAgent    string
+}
+
+type requestInfoKey struct{}
+
+func NewContext(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// FromContext returns request info, empty one for internal calls.
+func FromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+
+	return info
+}
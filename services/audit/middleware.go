@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+// recordTimeout bounds recording of call, appends wait for each other on lock of audit_log.
+const recordTimeout = 5 * time.Second
+
+// Middleware gives endpoint middleware by endpoint name (see Endpoints.Wrap of services) recording
+// every call of service endpoints but reads ones, so endpoints added later are recorded by default.
+// Domain errors are taken from responses implementing endpoint.Failer. Entry is recorded after action
+// is done in own transaction, if it can't be recorded response of action is returned anyway: failure is
+// counted by failures (labelled by action) and logged with the whole entry, so it may be restored from logs.
+func Middleware(
+	svc Service, logger log.Logger, failures metrics.Counter, service string, reads map[string]bool,
+) func(name string) endpoint.Middleware {
+	return func(name string) endpoint.Middleware {
+		if reads[name] {
+			return func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+		}
+		action := service + "." + name
+
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, request interface{}) (interface{}, error) {
+				payload, err := json.Marshal(request)
+				if err != nil {
+					return nil, err
+				}
+				response, err := next(ctx, request)
+				failure := err
+				if f, ok := response.(endpoint.Failer); ok && failure == nil {
+					failure = f.Failed()
+				}
+				e := NewEntry(ctx, action, payload, failure)
+				// cancellation by client, which disconnected after action is done, mustn't skip recording
+				rctx, cancel := context.WithTimeout(detached{ctx}, recordTimeout)
+				defer cancel()
+				if _, rerr := svc.Record(rctx, e); rerr != nil {
+					failures.With("action", action).Add(1)
+					customer := ""
+					if e.CustomerID != nil {
+						customer = e.CustomerID.String()
+					}
+					_ = level.Error(logger).Log("msg", "audit entry isn't recorded", "err", rerr.Error(),
+						"action", e.Action, "client_id", e.ClientID, "customer_id", customer, "payload", string(e.Payload),
+						"ip", e.IP, "forwarded_for", e.ForwardedFor, "user_agent", e.UserAgent, "outcome", e.Outcome)
+				}
+
+				return response, err
+			}
+		}
+	}
+}
+
+// NewEntry gives entry of action done by principal of ctx from where its request came from.
+func NewEntry(ctx context.Context, action string, payload json.RawMessage, failure error) Entry {
+	info := FromContext(ctx)
+	e := Entry{
+		Action:       action,
+		Payload:      payload,
+		IP:           info.IP,
+		ForwardedFor: info.ForwardedFor,
+		UserAgent:    info.UserAgent,
+		Outcome:      OutcomeOK,
+	}
+	if p, ok := auth.FromContext(ctx); ok {
+		e.ClientID = p.ClientID
+		if p.HasCustomer() {
+			customerID := p.CustomerID
+			e.CustomerID = &customerID
+		}
+	}
+	if failure != nil {
+		e.Outcome = failure.Error()
+	}
+
+	return e
+}
+
+// detached keeps values of context, but not its deadline and cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// HTTPMiddleware puts RequestInfo of requests into their context.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := RequestInfo{
+			IP:           r.RemoteAddr,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			UserAgent:    r.UserAgent(),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			info.IP = host
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), info)))
+	})
+}
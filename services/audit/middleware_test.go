@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+type recorderMock struct {
+	entries []Entry
+	err     error
+	ctx     context.Context
+}
+
+func (m *recorderMock) Record(ctx context.Context, e Entry) (Entry, error) {
+	if ctx.Err() != nil {
+		return e, ctx.Err()
+	}
+	m.ctx = ctx
+	m.entries = append(m.entries, e)
+	return e, m.err
+}
+
+func (m *recorderMock) deadline() (time.Time, bool) {
+	return m.ctx.Deadline()
+}
+
+func (m *recorderMock) Verify(ctx context.Context) (Verification, error) {
+	return Verification{}, nil
+}
+
+// labelledCounter sums values by joined label names and values.
+type labelledCounter struct {
+	values map[string]float64
+	lvs    []string
+}
+
+func newLabelledCounter() *labelledCounter {
+	return &labelledCounter{values: make(map[string]float64)}
+}
+
+func (c *labelledCounter) With(labelValues ...string) metrics.Counter {
+	return &labelledCounter{values: c.values, lvs: append(append([]string(nil), c.lvs...), labelValues...)}
+}
+
+func (c *labelledCounter) Add(delta float64) {
+	c.values[strings.Join(c.lvs, ",")] += delta
+}
+
+type orderRequest struct {
+	ID     int    `json:"id"`
+	Amount string `json:"amount"`
+}
+
+type failedResponse struct {
+	Err error
+}
+
+func (r failedResponse) Failed() error { return r.Err }
+
+func TestMiddleware(t *testing.T) {
+	customerID := uuid.New()
+	ctx := NewContext(
+		auth.NewContext(context.Background(), auth.Principal{ClientID: "client", CustomerID: customerID}),
+		RequestInfo{IP: "10.0.0.1", ForwardedFor: "1.2.3.4", UserAgent: "curl"})
+	errFunds := errors.New("insufficient_funds")
+	tests := []struct {
+		name     string
+		endpoint string
+		response interface{}
+		err      error
+		recorded *Entry
+	}{
+		{"ok", "CreateTransfer", failedResponse{}, nil, &Entry{Outcome: OutcomeOK}},
+		{"domain error", "CreateTransfer", failedResponse{Err: errFunds}, nil, &Entry{Outcome: "insufficient_funds"}},
+		{"error", "CreateTransfer", nil, auth.ErrForbidden, &Entry{Outcome: auth.ErrForbidden.Error()}},
+		{"read", "GetAccounts", failedResponse{}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assert.New(t)
+			recorder := &recorderMock{}
+			e := Middleware(recorder, log.NewNopLogger(), newLabelledCounter(), "transfers",
+				map[string]bool{"GetAccounts": true})(tt.endpoint)(
+				func(context.Context, interface{}) (interface{}, error) { return tt.response, tt.err })
+			response, err := e(ctx, orderRequest{ID: 1, Amount: "10"})
+			a.Equal(tt.err, err)
+			a.Equal(tt.response, response)
+			if tt.recorded == nil {
+				a.Empty(recorder.entries)
+				return
+			}
+			a.Equal([]Entry{{
+				ClientID:     "client",
+				CustomerID:   &customerID,
+				Action:       "transfers.CreateTransfer",
+				Payload:      []byte(`{"id":1,"amount":"10"}`),
+				IP:           "10.0.0.1",
+				ForwardedFor: "1.2.3.4",
+				UserAgent:    "curl",
+				Outcome:      tt.recorded.Outcome,
+			}}, recorder.entries)
+		})
+	}
+}
+
+func TestMiddleware_Record(t *testing.T) {
+	a := assert.New(t)
+	recorder := &recorderMock{}
+	failures := newLabelledCounter()
+	var logs bytes.Buffer
+	var nop endpoint.Endpoint = func(context.Context, interface{}) (interface{}, error) { return failedResponse{}, nil }
+	e := Middleware(recorder, log.NewLogfmtLogger(&logs), failures, "walletctl", nil)("Adjust")(nop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := e(ctx, orderRequest{ID: 1, Amount: "10"})
+	a.NoError(err, "call is recorded after client is gone")
+	a.Len(recorder.entries, 1)
+	a.Equal("", recorder.entries[0].ClientID, "internal call")
+	a.Nil(recorder.entries[0].CustomerID)
+	deadline, ok := recorder.deadline()
+	a.True(ok, "recording has own deadline")
+	a.WithinDuration(time.Now().Add(recordTimeout), deadline, time.Second)
+
+	recorder.err = errors.New("db is down")
+	response, err := e(context.Background(), orderRequest{ID: 1, Amount: "10"})
+	a.NoError(err, "done action isn't failed by audit")
+	a.Equal(failedResponse{}, response)
+	a.Equal(map[string]float64{"action,walletctl.Adjust": 1}, failures.values)
+	a.Contains(logs.String(), `msg="audit entry isn't recorded" err="db is down" action=walletctl.Adjust`)
+	a.Contains(logs.String(), `payload="{\"id\":1,\"amount\":\"10\"}"`, "entry may be restored from logs")
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	a := assert.New(t)
+	var info RequestInfo
+	h := HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = FromContext(r.Context())
+	}))
+	r := httptest.NewRequest("POST", "/transfers/", nil)
+	r.RemoteAddr = "[::1]:5000"
+	r.Header.Set("User-Agent", "curl/7.68.0")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	a.Equal(RequestInfo{IP: "::1", ForwardedFor: "1.2.3.4, 10.0.0.1", UserAgent: "curl/7.68.0"}, info)
+}
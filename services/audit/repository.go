@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Repository interface {
+	// chains entry to the last one (sets PrevHash and Hash) and inserts it, appends are serialized
+	Append(ctx context.Context, e Entry) (Entry, error)
+	// entries in order of appending
+	GetEntries(ctx context.Context, afterID int64, limit uint) ([]Entry, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func (r repository) Append(ctx context.Context, e Entry) (entry Entry, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	// concurrent appends would chain to the same entry, reads aren't blocked
+	if _, err = tx.ExecContext(ctx, `LOCK TABLE audit_log IN EXCLUSIVE MODE`); err != nil {
+		return e, err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&e.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return e, err
+	}
+	if e.Hash, err = e.computeHash(); err != nil {
+		return e, err
+	}
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO audit_log(client_id, customer_id, action, payload, ip, forwarded_for, user_agent, outcome, created_at, prev_hash, hash)
+ VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		e.ClientID, e.CustomerID, e.Action, []byte(e.Payload), e.IP, e.ForwardedFor, e.UserAgent, e.Outcome,
+		e.CreatedAt, e.PrevHash, e.Hash,
+	).Scan(&e.ID)
+
+	return e, err
+}
+
+func (r repository) GetEntries(ctx context.Context, afterID int64, limit uint) ([]Entry, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, client_id, customer_id, action, payload, ip, forwarded_for, user_agent, outcome, created_at, prev_hash, hash
+ FROM audit_log WHERE id > $1 ORDER BY id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]Entry, 0, limit)
+	for rows.Next() {
+		var e Entry
+		var payload []byte
+		err = rows.Scan(&e.ID, &e.ClientID, &e.CustomerID, &e.Action, &payload, &e.IP, &e.ForwardedFor,
+			&e.UserAgent, &e.Outcome, &e.CreatedAt, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
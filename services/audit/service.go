@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const verifyBatch = 1000
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return service{repo: repo}
+}
+
+func (s service) Record(ctx context.Context, e Entry) (Entry, error) {
+	// payload is stored as is (not as jsonb), so it's hashed and read back in the same form
+	payload := []byte("null")
+	if len(e.Payload) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, e.Payload); err != nil {
+			return e, err
+		}
+		payload = buf.Bytes()
+	}
+	e.Payload = payload
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	return s.repo.Append(ctx, e)
+}
+
+func (s service) Verify(ctx context.Context) (Verification, error) {
+	var v Verification
+	var lastID int64
+	for {
+		entries, err := s.repo.GetEntries(ctx, lastID, verifyBatch)
+		if err != nil {
+			return v, err
+		}
+		for _, e := range entries {
+			hash, err := e.computeHash()
+			if err != nil {
+				return v, err
+			}
+			if e.PrevHash != v.LastHash || e.Hash != hash {
+				v.BrokenID = e.ID
+
+				return v, ErrChainBroken
+			}
+			v.Entries++
+			v.LastHash = e.Hash
+			lastID = e.ID
+		}
+		if len(entries) < verifyBatch {
+			return v, nil
+		}
+	}
+}
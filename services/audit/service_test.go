@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepare() (Service, sqlmock.Sqlmock, error, func()) {
+	db, mock, err := sqlmock.New()
+	service := NewService(NewRepository(db))
+	return service, mock, err, func() {
+		db.Close()
+	}
+}
+
+var entryColumns = []string{
+	"id", "client_id", "customer_id", "action", "payload", "ip", "forwarded_for", "user_agent", "outcome",
+	"created_at", "prev_hash", "hash",
+}
+
+// chain gives entries linked by hashes as they are appended.
+func chain(t *testing.T, entries ...Entry) []Entry {
+	prev := ""
+	for i := range entries {
+		entries[i].ID = int64(i + 1)
+		entries[i].PrevHash = prev
+		hash, err := entries[i].computeHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i].Hash = hash
+		prev = hash
+	}
+
+	return entries
+}
+
+func entryRows(entries []Entry) *sqlmock.Rows {
+	rows := sqlmock.NewRows(entryColumns)
+	for _, e := range entries {
+		var customerID driver.Value
+		if e.CustomerID != nil {
+			customerID = e.CustomerID.String()
+		}
+		rows.AddRow(e.ID, e.ClientID, customerID, e.Action, []byte(e.Payload), e.IP, e.ForwardedFor, e.UserAgent,
+			e.Outcome, e.CreatedAt, e.PrevHash, e.Hash)
+	}
+
+	return rows
+}
+
+func TestService_Record(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	customerID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE audit_log IN EXCLUSIVE MODE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("prev"))
+	mock.ExpectQuery(`INSERT INTO audit_log`).
+		WithArgs("client", customerID, "transfers.CreateTransfer", []byte(`{"id":1}`), "10.0.0.1", "", "curl",
+			OutcomeOK, sqlmock.AnyArg(), "prev", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectCommit()
+
+	e, err := svc.Record(context.Background(), Entry{
+		ClientID:   "client",
+		CustomerID: &customerID,
+		Action:     "transfers.CreateTransfer",
+		Payload:    json.RawMessage("{\n  \"id\": 1\n}"),
+		IP:         "10.0.0.1",
+		UserAgent:  "curl",
+		Outcome:    OutcomeOK,
+	})
+	a.NoError(err)
+	a.NoError(mock.ExpectationsWereMet())
+	a.Equal(int64(7), e.ID)
+	a.Equal("prev", e.PrevHash)
+	a.Len(e.Hash, 64)
+	hash, err := e.computeHash()
+	a.NoError(err)
+	a.Equal(hash, e.Hash)
+	a.WithinDuration(time.Now(), e.CreatedAt, time.Minute)
+
+	// first entry is chained to empty hash, absent payload is stored as null
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE audit_log`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT hash FROM audit_log`).WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	mock.ExpectQuery(`INSERT INTO audit_log`).
+		WithArgs("", nil, "walletctl.freeze", []byte(`null`), "", "", "", "account_frozen",
+			sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	e, err = svc.Record(context.Background(), Entry{Action: "walletctl.freeze", Outcome: "account_frozen"})
+	a.NoError(err)
+	a.NoError(mock.ExpectationsWereMet())
+	a.Equal("", e.PrevHash)
+
+	_, err = svc.Record(context.Background(), Entry{Payload: json.RawMessage("{")})
+	a.Error(err, "invalid payload isn't recorded")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_Verify(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	customerID := uuid.New()
+	createdAt := time.Date(2021, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	newEntries := func() []Entry {
+		return chain(t,
+			Entry{ClientID: "a", CustomerID: &customerID, Action: "transfers.CreateTransfer",
+				Payload: json.RawMessage(`{"amount":"10"}`), Outcome: OutcomeOK, CreatedAt: createdAt},
+			Entry{ClientID: "b", Action: "transfers.CreateTransfer",
+				Payload: json.RawMessage(`{"amount":"20"}`), Outcome: "insufficient_funds", CreatedAt: createdAt},
+			Entry{ClientID: "walletctl:root", Action: "walletctl.adjust", Payload: json.RawMessage(`["-amount","5"]`),
+				Outcome: OutcomeOK, CreatedAt: createdAt},
+		)
+	}
+
+	entries := newEntries()
+	mock.ExpectQuery(`SELECT .+ FROM audit_log WHERE id > \$1 ORDER BY id LIMIT \$2`).
+		WithArgs(0, verifyBatch).WillReturnRows(entryRows(entries))
+	v, err := svc.Verify(context.Background())
+	a.NoError(err)
+	a.NoError(mock.ExpectationsWereMet())
+	a.Equal(Verification{Entries: 3, LastHash: entries[2].Hash}, v)
+
+	tests := []struct {
+		name   string
+		tamper func([]Entry) []Entry
+		broken int64
+	}{
+		{"changed outcome", func(e []Entry) []Entry { e[1].Outcome = OutcomeOK; return e }, 2},
+		{"changed payload", func(e []Entry) []Entry { e[0].Payload = json.RawMessage(`{"amount":"1"}`); return e }, 1},
+		{"deleted entry", func(e []Entry) []Entry { return append(e[:1], e[2]) }, 3},
+		{"rehashed entry", func(e []Entry) []Entry {
+			e[1].ClientID = "c"
+			e[1].Hash, _ = e[1].computeHash()
+			return e
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assert.New(t)
+			mock.ExpectQuery(`SELECT .+ FROM audit_log`).WillReturnRows(entryRows(tt.tamper(newEntries())))
+			v, err := svc.Verify(context.Background())
+			a.Equal(ErrChainBroken, err)
+			a.NoError(mock.ExpectationsWereMet())
+			a.Equal(tt.broken, v.BrokenID)
+		})
+	}
+}
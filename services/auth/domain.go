@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// statusError is an error with http status, see go-kit httptransport.StatusCoder.
+type statusError struct {
+	code   string
+	status int
+}
+
+func (e statusError) Error() string {
+	return e.code
+}
+
+func (e statusError) StatusCode() int {
+	return e.status
+}
+
+// Authentication and authorization errors.
+var (
+	ErrUnauthenticated   error = statusError{"unauthenticated", http.StatusUnauthorized}
+	ErrForbidden         error = statusError{"forbidden", http.StatusForbidden}
+	ErrEmptyKeyName            = errors.New("api_key_name_is_empty")
+	ErrUnknownScope            = errors.New("api_key_scope_unknown")
+	ErrKeyNotExists            = errors.New("api_key_not_exist")
+	ErrEmptyCustomerName       = errors.New("customer_name_is_empty")
+	ErrEmptyCustomerID         = errors.New("customer_id_is_empty")
+	ErrCustomerNotExists       = errors.New("customer_not_exist")
+)
+
+// Scopes that are granted to clients.
+const (
+	ScopeAccountsRead   = "accounts:read"
+	ScopeTransfersWrite = "transfers:write"
+	ScopeMandatesRead   = "mandates:read"
+	ScopeMandatesWrite  = "mandates:write"
+	ScopeWebhooksManage = "webhooks:manage"
+	// currencies are shared by all customers, so the scope should be granted to operators only
+	ScopeCurrenciesManage = "currencies:manage"
+	// reports cover accounts of all customers, so the scope should be granted to operators only
+	ScopeReconciliationRead = "reconciliation:read"
+)
+
+// Scopes lists all known scopes.
+var Scopes = []string{
+	ScopeAccountsRead, ScopeTransfersWrite, ScopeMandatesRead, ScopeMandatesWrite, ScopeWebhooksManage,
+	ScopeCurrenciesManage, ScopeReconciliationRead,
+}
+
+// Principal is an authenticated client on whose behalf request is made.
+type Principal struct {
+	ClientID string
+	// tenant whose accounts client may access
+	CustomerID uuid.UUID
+	Scopes     []string
+}
+
+// HasCustomer reports whether principal is bound to customer, principal without one accesses no accounts.
+func (p Principal) HasCustomer() bool {
+	return p.CustomerID != uuid.Nil
+}
+
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns principal of the request, false for internal calls (e.g. from workers).
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+
+	return p, ok
+}
+
+// Customer owns accounts and acts as a tenant, clients of customer access only its accounts.
+type Customer struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey of client, only hash of the key itself is stored.
+type APIKey struct {
+	ID         uuid.UUID `json:"id"`
+	CustomerID uuid.UUID `json:"customer_id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"-"`
+	// secret of signed requests, shared with client unlike the key itself
+	SigningSecret string     `json:"-"`
+	Scopes        []string   `json:"scopes"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+// Authenticator checks credentials of request.
+type Authenticator interface {
+	// returns false if request has no credentials of the authenticator kind,
+	// ErrUnauthenticated if they are present but invalid
+	Authenticate(r *http.Request) (Principal, bool, error)
+}
+
+// Customers and API keys management actions.
+type Service interface {
+	CreateCustomer(ctx context.Context, name string) (Customer, error)
+	GetCustomers(ctx context.Context) ([]Customer, error)
+	// returns key itself only here
+	IssueAPIKey(ctx context.Context, customerID uuid.UUID, name string, scopes []string) (APIKey, string, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (Principal, error)
+	// replaces signing secret of key and returns it, it's shown only here
+	IssueSigningSecret(ctx context.Context, keyID uuid.UUID) (string, error)
+	// returns principal of key and its signing secret, ErrUnauthenticated if key can't sign requests
+	GetSigningSecret(ctx context.Context, keyID uuid.UUID) (Principal, string, error)
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ErrUnknownKeyID means token is signed by key that is absent in key set even after refresh.
+var ErrUnknownKeyID = errors.New("jwt_key_id_unknown")
+
+// jwk is a public key of JSON Web Key Set (RFC 7517), only signing RSA and P-256 EC keys are supported.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// ParseJWKS gives signing keys of the set by their ids, unsupported keys are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// KeySet keeps keys of JWKS loaded from file or url, it's refreshed periodically by Run
+// and on demand when token is signed by unknown key (at most once per minRefreshInterval).
+type KeySet struct {
+	source             string
+	client             *http.Client
+	interval           time.Duration
+	minRefreshInterval time.Duration
+	logger             log.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	refreshedAt time.Time
+	// on demand refreshes are serialized, so burst of tokens with unknown kid loads source once
+	refreshMu sync.Mutex
+}
+
+// NewKeySet for source that is either http(s) url or file path.
+func NewKeySet(source string, client *http.Client, interval time.Duration, logger log.Logger) *KeySet {
+	return &KeySet{
+		source:             source,
+		client:             client,
+		interval:           interval,
+		minRefreshInterval: interval / 10, // nolint gomnd
+		logger:             logger,
+	}
+}
+
+func (s *KeySet) load(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return ioutil.ReadFile(s.source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks responded with status %d", res.StatusCode)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// Refresh replaces keys with the current ones of source, keys are kept if source is unavailable.
+func (s *KeySet) Refresh(ctx context.Context) error {
+	data, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.refreshedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *KeySet) lookup(kid string) (crypto.PublicKey, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+
+	return key, s.refreshedAt, ok
+}
+
+// Key gives key by id, unknown id triggers refresh, so rotated keys are picked up immediately.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, _, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	key, refreshedAt, ok := s.lookup(kid)
+	if ok {
+		return key, nil
+	}
+	if time.Since(refreshedAt) < s.minRefreshInterval {
+		return nil, ErrUnknownKeyID
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, _, ok = s.lookup(kid); !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	return key, nil
+}
+
+// Run refreshes keys periodically until ctx is done.
+func (s *KeySet) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				_ = level.Error(s.logger).Log("msg", "jwks refresh failed", "err", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Claims of tokens issued by gateway, `sub` identifies client.
+type Claims struct {
+	jwt.RegisteredClaims
+	CustomerID uuid.UUID `json:"customer_id"`
+	// space separated scopes as in OAuth 2.0
+	Scope string `json:"scope"`
+}
+
+// Principal that claims are issued for.
+func (c Claims) Principal() Principal {
+	return Principal{ClientID: c.Subject, CustomerID: c.CustomerID, Scopes: strings.Fields(c.Scope)}
+}
+
+// JWTConfig restricts accepted tokens, empty values aren't checked.
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+}
+
+type jwtAuthenticator struct {
+	keys   *KeySet
+	config JWTConfig
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator validates `Authorization: Bearer <token>` signed with RS256 or ES256 key of the set.
+func NewJWTAuthenticator(keys *KeySet, config JWTConfig) Authenticator {
+	return jwtAuthenticator{
+		keys:   keys,
+		config: config,
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256"})),
+	}
+}
+
+func (a jwtAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	const scheme = "Bearer "
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, scheme) {
+		return Principal{}, false, nil
+	}
+	var claims Claims
+	_, err := a.parser.ParseWithClaims(strings.TrimPrefix(authorization, scheme), &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		return a.keys.Key(r.Context(), kid)
+	})
+	if err != nil || !a.valid(claims) {
+		return Principal{}, true, ErrUnauthenticated
+	}
+
+	return claims.Principal(), true, nil
+}
+
+func (a jwtAuthenticator) valid(c Claims) bool {
+	now := time.Now()
+	// token without customer_id would give principal of no customer rather than be rejected
+	if !c.VerifyExpiresAt(now, true) || c.Subject == "" || c.CustomerID == uuid.Nil {
+		return false
+	}
+	if a.config.Issuer != "" && !c.VerifyIssuer(a.config.Issuer, true) {
+		return false
+	}
+
+	return a.config.Audience == "" || c.VerifyAudience(a.config.Audience, true)
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func toJWK(kid string, key crypto.PublicKey) jwk {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kid: kid, Kty: "RSA", Use: "sig", N: encodeBigInt(k.N), E: encodeBigInt(big.NewInt(int64(k.E)))}
+	case *ecdsa.PublicKey:
+		return jwk{Kid: kid, Kty: "EC", Crv: "P-256", X: encodeBigInt(k.X), Y: encodeBigInt(k.Y)}
+	}
+	panic("unsupported key")
+}
+
+// jwksServer serves keys that may be rotated during test.
+type jwksServer struct {
+	mu       sync.Mutex
+	keys     []jwk
+	requests int
+}
+
+func (s *jwksServer) set(keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	return s
+}
+
+func validClaims(customerID uuid.UUID) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "gateway-client",
+			Issuer:    "https://gateway.local",
+			Audience:  jwt.ClaimStrings{"wallet-api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		CustomerID: customerID,
+		Scope:      ScopeAccountsRead + " " + ScopeTransfersWrite,
+	}
+}
+
+func authenticate(a Authenticator, token string) (Principal, bool, error) {
+	req, _ := http.NewRequest("GET", "/accounts/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return a.Authenticate(req)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	a := assert.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.NoError(err)
+	jwks := &jwksServer{}
+	jwks.set(toJWK("rsa-1", &rsaKey.PublicKey), toJWK("ec-1", &ecKey.PublicKey))
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	keys := NewKeySet(server.URL, server.Client(), time.Hour, testLogger)
+	authenticator := NewJWTAuthenticator(keys, JWTConfig{Issuer: "https://gateway.local", Audience: "wallet-api"})
+	customerID := uuid.New()
+
+	p, ok, err := authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims(customerID)))
+	a.NoError(err)
+	a.True(ok)
+	a.Equal("gateway-client", p.ClientID)
+	a.Equal(customerID, p.CustomerID)
+	a.Equal([]string{ScopeAccountsRead, ScopeTransfersWrite}, p.Scopes)
+
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodES256, "ec-1", ecKey, validClaims(customerID)))
+	a.NoError(err, "ES256 is supported")
+
+	expired := validClaims(customerID)
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, expired))
+	a.Equal(ErrUnauthenticated, err)
+
+	noExpiration := validClaims(customerID)
+	noExpiration.ExpiresAt = nil
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, noExpiration))
+	a.Equal(ErrUnauthenticated, err, "expiration is required")
+
+	otherAudience := validClaims(customerID)
+	otherAudience.Audience = jwt.ClaimStrings{"other-api"}
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, otherAudience))
+	a.Equal(ErrUnauthenticated, err)
+
+	// customer_id claim is omitted, not only zero
+	claims := validClaims(customerID)
+	noCustomer := jwt.MapClaims{"sub": claims.Subject, "iss": claims.Issuer, "aud": "wallet-api",
+		"exp": claims.ExpiresAt.Unix(), "scope": claims.Scope}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, noCustomer)
+	token.Header["kid"] = "rsa-1"
+	signed, err := token.SignedString(rsaKey)
+	a.NoError(err)
+	_, _, err = authenticate(authenticator, signed)
+	a.Equal(ErrUnauthenticated, err, "customer_id is required")
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims(uuid.Nil)))
+	a.Equal(ErrUnauthenticated, err, "nil customer_id is rejected")
+
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodES256, "rsa-1", ecKey, validClaims(customerID)))
+	a.Equal(ErrUnauthenticated, err, "key of other type")
+
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), validClaims(customerID)))
+	a.Equal(ErrUnauthenticated, err, "symmetric algorithms are not accepted")
+
+	req, _ := http.NewRequest("GET", "/accounts/", nil)
+	req.Header.Set(APIKeyHeader, "wk_key")
+	_, ok, err = authenticator.Authenticate(req)
+	a.NoError(err)
+	a.False(ok, "request without bearer token is left for other authenticators")
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	a := assert.New(t)
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.NoError(err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.NoError(err)
+	jwks := &jwksServer{}
+	jwks.set(toJWK("old", &oldKey.PublicKey))
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	keys := NewKeySet(server.URL, server.Client(), time.Hour, testLogger)
+	keys.minRefreshInterval = 0
+	authenticator := NewJWTAuthenticator(keys, JWTConfig{})
+	claims := validClaims(uuid.New())
+
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "old", oldKey, claims))
+	a.NoError(err)
+	a.Equal(1, jwks.requests, "keys are loaded on first use")
+
+	jwks.set(toJWK("old", &oldKey.PublicKey), toJWK("new", &newKey.PublicKey))
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "new", newKey, claims))
+	a.NoError(err, "unknown kid triggers refresh")
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "old", oldKey, claims))
+	a.NoError(err)
+	a.Equal(2, jwks.requests, "known keys don't trigger refresh")
+
+	jwks.set(toJWK("new", &newKey.PublicKey))
+	keys.minRefreshInterval = time.Hour
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "missing", newKey, claims))
+	a.Equal(ErrUnauthenticated, err)
+	a.Equal(2, jwks.requests, "refresh on unknown kid is rate limited")
+	a.NoError(keys.Refresh(context.Background()))
+	_, _, err = authenticate(authenticator, sign(t, jwt.SigningMethodRS256, "old", oldKey, claims))
+	a.Equal(ErrUnauthenticated, err, "removed key is not accepted after refresh")
+}
+
+func TestKeySet_File(t *testing.T) {
+	a := assert.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.NoError(err)
+	data, err := json.Marshal(map[string]interface{}{"keys": []jwk{
+		toJWK("ec", &key.PublicKey),
+		{Kid: "enc", Kty: "RSA", Use: "enc"},
+	}})
+	a.NoError(err)
+	dir, err := ioutil.TempDir("", "jwks")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	a.NoError(ioutil.WriteFile(path, data, 0600))
+
+	keys := NewKeySet(path, nil, time.Hour, testLogger)
+	a.NoError(keys.Refresh(context.Background()))
+	_, err = keys.Key(context.Background(), "ec")
+	a.NoError(err)
+	_, err = keys.Key(context.Background(), "enc")
+	a.Equal(ErrUnknownKeyID, err, "encryption keys are skipped")
+}
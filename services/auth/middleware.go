@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// APIKeyHeader carries API key, `Authorization: ApiKey <key>` is accepted as well.
+const APIKeyHeader = "X-API-Key"
+
+type apiKeyAuthenticator struct {
+	svc Service
+}
+
+func NewAPIKeyAuthenticator(svc Service) Authenticator {
+	return apiKeyAuthenticator{svc}
+}
+
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		const scheme = "ApiKey "
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, scheme) {
+			return Principal{}, false, nil
+		}
+		key = strings.TrimPrefix(authorization, scheme)
+	}
+	p, err := a.svc.AuthenticateAPIKey(r.Context(), key)
+
+	return p, true, err
+}
+
+// NewHTTPMiddleware rejects requests without valid credentials of any authenticator
+// and puts principal into context of the rest ones, requests already authenticated
+// by outer wrapper (e.g. signed ones) are passed as is.
+func NewHTTPMiddleware(errorEncoder httptransport.ErrorEncoder, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := FromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+			for _, a := range authenticators {
+				p, ok, err := a.Authenticate(r)
+				if err != nil {
+					errorEncoder(r.Context(), err, w)
+
+					return
+				}
+				if ok {
+					next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+
+					return
+				}
+			}
+			errorEncoder(r.Context(), ErrUnauthenticated, w)
+		})
+	}
+}
+
+func authorize(ctx context.Context, scope string) error {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !p.HasScope(scope) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// RequireScope rejects calls of principals without the scope.
+func RequireScope(scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := authorize(ctx, scope); err != nil {
+				return nil, err
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// ScopeMiddleware gives middleware for endpoint by its name requiring scope from the map,
+// endpoints absent in the map are forbidden for everyone.
+func ScopeMiddleware(scopes map[string]string) func(name string) endpoint.Middleware {
+	return func(name string) endpoint.Middleware {
+		scope, ok := scopes[name]
+		if !ok {
+			return func(endpoint.Endpoint) endpoint.Endpoint {
+				return func(context.Context, interface{}) (interface{}, error) {
+					return nil, ErrForbidden
+				}
+			}
+		}
+
+		return RequireScope(scope)
+	}
+}
+
+// RequireScopeHandler is RequireScope for plain http handlers (e.g. streaming ones).
+func RequireScopeHandler(scope string, errorEncoder httptransport.ErrorEncoder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authorize(r.Context(), scope); err != nil {
+			errorEncoder(r.Context(), err, w)
+
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
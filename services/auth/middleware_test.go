@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func testErrorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	svc := NewService(&memoryRepository{})
+	c, err := svc.CreateCustomer(ctx, "acme")
+	a.NoError(err)
+	_, key, err := svc.IssueAPIKey(ctx, c.ID, "client", []string{ScopeAccountsRead})
+	a.NoError(err)
+
+	var principal Principal
+	handler := NewHTTPMiddleware(testErrorEncoder, NewAPIKeyAuthenticator(svc))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = FromContext(r.Context())
+		}))
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong key", APIKeyHeader, "wk_wrong", http.StatusUnauthorized},
+		{"key header", APIKeyHeader, key, http.StatusOK},
+		{"authorization header", "Authorization", "ApiKey " + key, http.StatusOK},
+	} {
+		principal = Principal{}
+		req, _ := http.NewRequest("GET", "/accounts/", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		a.Equal(tc.status, response.Code, tc.name)
+		a.Equal(tc.status == http.StatusOK, principal.HasScope(ScopeAccountsRead), tc.name)
+	}
+}
+
+func TestScopeMiddleware(t *testing.T) {
+	a := assert.New(t)
+	mw := ScopeMiddleware(map[string]string{"GetAccounts": ScopeAccountsRead})
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	reader := NewContext(context.Background(), Principal{ClientID: "c", Scopes: []string{ScopeAccountsRead}})
+
+	res, err := mw("GetAccounts")(ok)(reader, nil)
+	a.NoError(err)
+	a.Equal("ok", res)
+
+	_, err = mw("GetAccounts")(ok)(context.Background(), nil)
+	a.Equal(ErrUnauthenticated, err)
+
+	_, err = mw("CreateTransfer")(ok)(reader, nil)
+	a.Equal(ErrForbidden, err, "endpoints without scope are forbidden")
+
+	writer := NewContext(context.Background(), Principal{ClientID: "c", Scopes: []string{ScopeTransfersWrite}})
+	_, err = mw("GetAccounts")(ok)(writer, nil)
+	a.Equal(ErrForbidden, err)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/risentveber/wallet-api/services/dberrors"
+)
+
+type Repository interface {
+	CreateCustomer(ctx context.Context, c Customer) error
+	GetCustomers(ctx context.Context) ([]Customer, error)
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	// For separation business logic errors from database errors
+	IsCustomerNotExistsError(err error) bool
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error)
+	// returns false if key doesn't exist or is already revoked
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) (bool, error)
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	GetAPIKey(ctx context.Context, id uuid.UUID) (APIKey, bool, error)
+	// returns false if key doesn't exist or is revoked
+	SetSigningSecret(ctx context.Context, id uuid.UUID, secret string) (bool, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func (r repository) CreateCustomer(ctx context.Context, c Customer) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO customers(id, name, created_at)
+ VALUES ($1, $2, $3)`, c.ID, c.Name, c.CreatedAt)
+
+	return err
+}
+
+func (r repository) GetCustomers(ctx context.Context) ([]Customer, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, name, created_at FROM customers ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var customers []Customer
+	for rows.Next() {
+		var c Customer
+		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		customers = append(customers, c)
+	}
+
+	return customers, rows.Err()
+}
+
+func (r repository) CreateAPIKey(ctx context.Context, k APIKey) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO api_keys(id, customer_id, name, hash, scopes, created_at)
+ VALUES ($1, $2, $3, $4, $5, $6)`, k.ID, k.CustomerID, k.Name, k.Hash, pq.Array(k.Scopes), k.CreatedAt)
+
+	return err
+}
+
+func (r repository) IsCustomerNotExistsError(err error) bool {
+	return dberrors.IsForeignKeyViolation(err, "api_keys_customer_id_fkey")
+}
+
+// customer_id is NULL for keys issued before customers were introduced, it's scanned as uuid.Nil
+const apiKeyColumns = `id, customer_id, name, hash, COALESCE(signing_secret, ''), scopes, created_at, revoked_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(s scanner) (APIKey, error) {
+	var k APIKey
+	err := s.Scan(&k.ID, &k.CustomerID, &k.Name, &k.Hash, &k.SigningSecret, pq.Array(&k.Scopes), &k.CreatedAt, &k.RevokedAt)
+
+	return k, err
+}
+
+func (r repository) GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	return r.getAPIKey(ctx, `hash=$1`, hash)
+}
+
+func (r repository) GetAPIKey(ctx context.Context, id uuid.UUID) (APIKey, bool, error) {
+	return r.getAPIKey(ctx, `id=$1`, id)
+}
+
+func (r repository) getAPIKey(ctx context.Context, condition string, arg interface{}) (APIKey, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+condition, arg)
+	k, err := scanAPIKey(row)
+	switch err {
+	case sql.ErrNoRows:
+		return k, false, nil
+	case nil:
+		return k, true, nil
+	default:
+		return k, false, err
+	}
+}
+
+func (r repository) RevokeAPIKey(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE api_keys SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+func (r repository) SetSigningSecret(ctx context.Context, id uuid.UUID, secret string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE api_keys SET signing_secret = $2
+WHERE id = $1 AND revoked_at IS NULL`, id, secret)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+func (r repository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	keyPrefix = "wk_"
+	keyLength = 32
+)
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return service{repo}
+}
+
+// HashKey gives the representation of key stored in db.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, keyLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func generateKey() (string, error) {
+	secret, err := generateSecret()
+
+	return keyPrefix + secret, err
+}
+
+func knownScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s service) CreateCustomer(ctx context.Context, name string) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, ErrEmptyCustomerName
+	}
+	c := Customer{ID: uuid.New(), Name: name, CreatedAt: time.Now().UTC()}
+	if err := s.repo.CreateCustomer(ctx, c); err != nil {
+		return Customer{}, err
+	}
+
+	return c, nil
+}
+
+func (s service) GetCustomers(ctx context.Context) ([]Customer, error) {
+	return s.repo.GetCustomers(ctx)
+}
+
+func (s service) IssueAPIKey(ctx context.Context, customerID uuid.UUID, name string, scopes []string) (APIKey, string, error) {
+	if customerID == uuid.Nil {
+		return APIKey{}, "", ErrEmptyCustomerID
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIKey{}, "", ErrEmptyKeyName
+	}
+	for _, scope := range scopes {
+		if !knownScope(scope) {
+			return APIKey{}, "", ErrUnknownScope
+		}
+	}
+	key, err := generateKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	apiKey := APIKey{
+		ID:         uuid.New(),
+		CustomerID: customerID,
+		Name:       name,
+		Hash:       HashKey(key),
+		Scopes:     scopes,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err = s.repo.CreateAPIKey(ctx, apiKey)
+	if s.repo.IsCustomerNotExistsError(err) {
+		return APIKey{}, "", ErrCustomerNotExists
+	}
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+func (s service) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	ok, err := s.repo.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyNotExists
+	}
+
+	return nil
+}
+
+func (s service) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return s.repo.GetAPIKeys(ctx)
+}
+
+func (s service) AuthenticateAPIKey(ctx context.Context, key string) (Principal, error) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return Principal{}, ErrUnauthenticated
+	}
+	apiKey, ok, err := s.repo.GetAPIKeyByHash(ctx, HashKey(key))
+	if err != nil {
+		return Principal{}, err
+	}
+	if !ok || apiKey.RevokedAt != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	return principalOf(apiKey), nil
+}
+
+func principalOf(k APIKey) Principal {
+	return Principal{ClientID: k.ID.String(), CustomerID: k.CustomerID, Scopes: k.Scopes}
+}
+
+func (s service) IssueSigningSecret(ctx context.Context, keyID uuid.UUID) (string, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.repo.SetSigningSecret(ctx, keyID, secret)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrKeyNotExists
+	}
+
+	return secret, nil
+}
+
+func (s service) GetSigningSecret(ctx context.Context, keyID uuid.UUID) (Principal, string, error) {
+	apiKey, ok, err := s.repo.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return Principal{}, "", err
+	}
+	if !ok || apiKey.RevokedAt != nil || apiKey.SigningSecret == "" {
+		return Principal{}, "", ErrUnauthenticated
+	}
+
+	return principalOf(apiKey), apiKey.SigningSecret, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var errCustomerFK = errors.New("customer foreign key")
+
+type memoryRepository struct {
+	mu        sync.Mutex
+	customers []Customer
+	keys      []APIKey
+}
+
+func (r *memoryRepository) CreateCustomer(_ context.Context, c Customer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.customers = append(r.customers, c)
+
+	return nil
+}
+
+func (r *memoryRepository) GetCustomers(context.Context) ([]Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Customer(nil), r.customers...), nil
+}
+
+func (r *memoryRepository) CreateAPIKey(_ context.Context, k APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.customers {
+		if c.ID == k.CustomerID {
+			r.keys = append(r.keys, k)
+
+			return nil
+		}
+	}
+
+	return errCustomerFK
+}
+
+func (r *memoryRepository) IsCustomerNotExistsError(err error) bool {
+	return err == errCustomerFK
+}
+
+func (r *memoryRepository) GetAPIKeyByHash(_ context.Context, hash string) (APIKey, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.Hash == hash {
+			return k, true, nil
+		}
+	}
+
+	return APIKey{}, false, nil
+}
+
+func (r *memoryRepository) RevokeAPIKey(_ context.Context, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.keys {
+		if k.ID == id && k.RevokedAt == nil {
+			now := time.Now()
+			r.keys[i].RevokedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *memoryRepository) GetAPIKeys(context.Context) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]APIKey(nil), r.keys...), nil
+}
+
+func (r *memoryRepository) GetAPIKey(_ context.Context, id uuid.UUID) (APIKey, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.ID == id {
+			return k, true, nil
+		}
+	}
+
+	return APIKey{}, false, nil
+}
+
+func (r *memoryRepository) SetSigningSecret(_ context.Context, id uuid.UUID, secret string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.keys {
+		if k.ID == id && k.RevokedAt == nil {
+			r.keys[i].SigningSecret = secret
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func TestIssueAndAuthenticate(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	repo := &memoryRepository{}
+	svc := NewService(repo)
+	c, err := svc.CreateCustomer(ctx, "acme")
+	a.NoError(err)
+
+	k, key, err := svc.IssueAPIKey(ctx, c.ID, " mobile ", []string{ScopeAccountsRead})
+	a.NoError(err)
+	a.Equal("mobile", k.Name)
+	a.True(strings.HasPrefix(key, keyPrefix))
+	a.NotContains(repo.keys[0].Hash, key, "key itself is not stored")
+
+	p, err := svc.AuthenticateAPIKey(ctx, key)
+	a.NoError(err)
+	a.Equal(k.ID.String(), p.ClientID)
+	a.Equal(c.ID, p.CustomerID)
+	a.True(p.HasScope(ScopeAccountsRead))
+	a.False(p.HasScope(ScopeTransfersWrite))
+
+	_, err = svc.AuthenticateAPIKey(ctx, key+"x")
+	a.Equal(ErrUnauthenticated, err)
+
+	a.NoError(svc.RevokeAPIKey(ctx, k.ID))
+	_, err = svc.AuthenticateAPIKey(ctx, key)
+	a.Equal(ErrUnauthenticated, err, "revoked key")
+	a.Equal(ErrKeyNotExists, svc.RevokeAPIKey(ctx, k.ID))
+}
+
+func TestIssueValidation(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	svc := NewService(&memoryRepository{})
+
+	_, err := svc.CreateCustomer(ctx, " ")
+	a.Equal(ErrEmptyCustomerName, err)
+	c, err := svc.CreateCustomer(ctx, "acme")
+	a.NoError(err)
+	_, _, err = svc.IssueAPIKey(ctx, uuid.Nil, "client", nil)
+	a.Equal(ErrEmptyCustomerID, err)
+	_, _, err = svc.IssueAPIKey(ctx, c.ID, "  ", nil)
+	a.Equal(ErrEmptyKeyName, err)
+	_, _, err = svc.IssueAPIKey(ctx, c.ID, "client", []string{"accounts:write"})
+	a.Equal(ErrUnknownScope, err)
+	_, _, err = svc.IssueAPIKey(ctx, uuid.New(), "client", nil)
+	a.Equal(ErrCustomerNotExists, err)
+}
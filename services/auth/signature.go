@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/client"
+)
+
+const (
+	maxSignedBody  = 1 << 20
+	maxNonceLength = 128
+)
+
+// NonceCache remembers nonces of signed requests until they expire.
+type NonceCache interface {
+	// returns false if nonce is already used by the key
+	Add(ctx context.Context, keyID uuid.UUID, nonce string, expiresAt time.Time) (bool, error)
+}
+
+type nonceKey struct {
+	keyID uuid.UUID
+	nonce string
+}
+
+// MemoryNonceCache protects from replays within single replica only.
+type MemoryNonceCache struct {
+	mu     sync.Mutex
+	nonces map[nonceKey]time.Time
+	swept  time.Time
+	now    func() time.Time
+}
+
+// nonceSweepInterval of MemoryNonceCache dropping expired nonces, till then they are just ignored.
+const nonceSweepInterval = time.Minute
+
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{nonces: make(map[nonceKey]time.Time), now: time.Now}
+}
+
+func (c *MemoryNonceCache) Add(_ context.Context, keyID uuid.UUID, nonce string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	k := nonceKey{keyID, nonce}
+	if exp, ok := c.nonces[k]; ok && exp.After(now) {
+		return false, nil
+	}
+	if now.Sub(c.swept) > nonceSweepInterval {
+		for k, exp := range c.nonces {
+			if !exp.After(now) {
+				delete(c.nonces, k)
+			}
+		}
+		c.swept = now
+	}
+	c.nonces[k] = expiresAt
+
+	return true, nil
+}
+
+type postgresNonceCache struct {
+	db *sql.DB
+}
+
+// NewPostgresNonceCache protects from replays across all replicas.
+func NewPostgresNonceCache(db *sql.DB) NonceCache {
+	return postgresNonceCache{db}
+}
+
+func (c postgresNonceCache) Add(ctx context.Context, keyID uuid.UUID, nonce string, expiresAt time.Time) (bool, error) {
+	// expired nonces of the key are cleaned up on the way
+	_, err := c.db.ExecContext(ctx, `DELETE FROM request_nonces WHERE key_id = $1 AND expires_at < now()`, keyID)
+	if err != nil {
+		return false, err
+	}
+	res, err := c.db.ExecContext(ctx, `
+INSERT INTO request_nonces(key_id, nonce, expires_at)
+ VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING`, keyID, nonce, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+type signatureAuthenticator struct {
+	svc     Service
+	nonces  NonceCache
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewSignatureAuthenticator validates requests signed by client.Signer, timestamp of request
+// may differ from server time by maxSkew at most and nonce can't be reused meanwhile.
+func NewSignatureAuthenticator(svc Service, nonces NonceCache, maxSkew time.Duration) Authenticator {
+	return signatureAuthenticator{svc: svc, nonces: nonces, maxSkew: maxSkew, now: time.Now}
+}
+
+func (a signatureAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	if r.Header.Get(client.KeyIDHeader) == "" {
+		return Principal{}, false, nil
+	}
+	p, err := a.verify(r)
+
+	return p, true, err
+}
+
+func (a signatureAuthenticator) verify(r *http.Request) (Principal, error) {
+	keyID, err := uuid.Parse(r.Header.Get(client.KeyIDHeader))
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	timestamp := r.Header.Get(client.TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := a.now().Sub(signedAt); skew > a.maxSkew || skew < -a.maxSkew {
+		return Principal{}, ErrUnauthenticated
+	}
+	nonce := r.Header.Get(client.NonceHeader)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return Principal{}, ErrUnauthenticated
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxSignedBody))
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	_ = r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	p, secret, err := a.svc.GetSigningSecret(r.Context(), keyID)
+	if err != nil {
+		return Principal{}, err
+	}
+	expected := client.Signature(secret, client.StringToSign(r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(client.SignatureHeader))) {
+		return Principal{}, ErrUnauthenticated
+	}
+	// nonce is remembered only for valid signatures, so it can't be burned by others
+	fresh, err := a.nonces.Add(r.Context(), keyID, nonce, signedAt.Add(a.maxSkew))
+	if err != nil {
+		return Principal{}, err
+	}
+	if !fresh {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	return p, nil
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/client"
+)
+
+// signedServer echoes body and principal of requests authenticated by signature.
+func signedServer(a Authenticator) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := a.Authenticate(r)
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Client-Id", p.ClientID)
+		_, _ = w.Write(body)
+	}))
+}
+
+func TestSignatureAuthenticator(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	svc := NewService(&memoryRepository{})
+	c, err := svc.CreateCustomer(ctx, "partner")
+	a.NoError(err)
+	k, _, err := svc.IssueAPIKey(ctx, c.ID, "server", []string{ScopeTransfersWrite})
+	a.NoError(err)
+	secret, err := svc.IssueSigningSecret(ctx, k.ID)
+	a.NoError(err)
+
+	server := signedServer(NewSignatureAuthenticator(svc, NewMemoryNonceCache(), time.Minute))
+	defer server.Close()
+	httpClient := &http.Client{Transport: client.Transport{Signer: client.NewSigner(k.ID.String(), secret)}}
+
+	res, err := httpClient.Post(server.URL+"/transfers/?dry=1", "application/json", bytes.NewBufferString(`{"amount":"1"}`))
+	a.NoError(err)
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	a.Equal(http.StatusOK, res.StatusCode)
+	a.Equal(`{"amount":"1"}`, string(body), "body is available to handler")
+	a.Equal(k.ID.String(), res.Header.Get("X-Client-Id"))
+
+	send := func(r *http.Request) int {
+		res, err := http.DefaultClient.Do(r)
+		a.NoError(err)
+		_ = res.Body.Close()
+
+		return res.StatusCode
+	}
+	signed := func(signer client.Signer, body string) *http.Request {
+		r, _ := http.NewRequest("POST", server.URL+"/transfers/", bytes.NewBufferString(body))
+		a.NoError(signer.Sign(r))
+
+		return r
+	}
+	signer := client.NewSigner(k.ID.String(), secret)
+
+	r := signed(signer, `{"amount":"1"}`)
+	replay := r.Clone(ctx)
+	replay.Body, _ = r.GetBody()
+	a.Equal(http.StatusOK, send(r))
+	a.Equal(http.StatusUnauthorized, send(replay), "nonce can't be reused")
+
+	tampered := signed(signer, `{"amount":"1"}`)
+	tampered.Body = ioutil.NopCloser(bytes.NewBufferString(`{"amount":"100"}`))
+	tampered.ContentLength, tampered.GetBody = 16, nil
+	a.Equal(http.StatusUnauthorized, send(tampered))
+
+	otherPath := signed(signer, "")
+	otherPath.URL.Path = "/accounts/"
+	a.Equal(http.StatusUnauthorized, send(otherPath))
+
+	stale := signer
+	stale.Now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+	a.Equal(http.StatusUnauthorized, send(signed(stale, "")), "timestamp out of skew window")
+
+	a.Equal(http.StatusUnauthorized, send(signed(client.NewSigner(k.ID.String(), "wrong"), "")))
+	a.Equal(http.StatusUnauthorized, send(signed(client.NewSigner(uuid.New().String(), secret), "")), "unknown key")
+
+	a.NoError(svc.RevokeAPIKey(ctx, k.ID))
+	a.Equal(http.StatusUnauthorized, send(signed(signer, "")), "revoked key")
+
+	unsigned, _ := http.NewRequest("GET", server.URL+"/accounts/", nil)
+	_, ok, err := NewSignatureAuthenticator(svc, NewMemoryNonceCache(), time.Minute).Authenticate(unsigned)
+	a.NoError(err)
+	a.False(ok, "unsigned request is left for other authenticators")
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	cache := NewMemoryNonceCache()
+	cache.now = func() time.Time { return now }
+	keyID := uuid.New()
+
+	ok, _ := cache.Add(ctx, keyID, "n", now.Add(time.Minute))
+	a.True(ok)
+	ok, _ = cache.Add(ctx, keyID, "n", now.Add(time.Minute))
+	a.False(ok)
+	ok, _ = cache.Add(ctx, uuid.New(), "n", now.Add(time.Minute))
+	a.True(ok, "nonces are per key")
+
+	now = now.Add(2 * time.Minute)
+	ok, _ = cache.Add(ctx, keyID, "n", now.Add(time.Minute))
+	a.True(ok, "expired nonce is forgotten")
+	a.Len(cache.nonces, 1, "expired nonces are cleaned up")
+
+	ok, _ = cache.Add(ctx, keyID, "short", now.Add(time.Second))
+	a.True(ok)
+	now = now.Add(10 * time.Second)
+	ok, _ = cache.Add(ctx, keyID, "short", now.Add(time.Second))
+	a.True(ok, "expired nonce is forgotten before sweep")
+	ok, _ = cache.Add(ctx, keyID, "other", now.Add(time.Second))
+	a.True(ok)
+	a.Len(cache.nonces, 3, "nonces aren't swept on each call")
+	now = now.Add(2 * time.Minute)
+	ok, _ = cache.Add(ctx, keyID, "last", now.Add(time.Second))
+	a.True(ok)
+	a.Len(cache.nonces, 1, "expired nonces are swept once per interval")
+}
+
+func TestSignature_RequestURIWithQuery(t *testing.T) {
+	a := assert.New(t)
+	s := client.StringToSign("get", "/accounts/?limit=1", "1", "nonce", nil)
+	a.Equal("GET\n/accounts/?limit=1\n1\nnonce\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", s)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// NewSQLiteRepository gives Repository over db opened by migrations.Open with sqlite driver,
+// scopes are kept there as JSON array.
+func NewSQLiteRepository(db *sql.DB) Repository {
+	return sqliteRepository{db}
+}
+
+// sqliteRepository has the same layout as repository, so queries portable to sqlite are shared by conversion.
+type sqliteRepository struct {
+	db *sql.DB
+}
+
+func (r sqliteRepository) CreateCustomer(ctx context.Context, c Customer) error {
+	return repository(r).CreateCustomer(ctx, c)
+}
+
+func (r sqliteRepository) GetCustomers(ctx context.Context) ([]Customer, error) {
+	return repository(r).GetCustomers(ctx)
+}
+
+func (r sqliteRepository) CreateAPIKey(ctx context.Context, k APIKey) error {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+INSERT INTO api_keys(id, customer_id, name, hash, scopes, created_at)
+ VALUES ($1, $2, $3, $4, $5, $6)`, k.ID, k.CustomerID, k.Name, k.Hash, string(scopes), k.CreatedAt)
+
+	return err
+}
+
+func (r sqliteRepository) IsCustomerNotExistsError(err error) bool {
+	// the only foreign key of api_keys, sqlite doesn't name violated constraint
+	return err != nil && err.Error() == `constraint failed: FOREIGN KEY constraint failed (787)`
+}
+
+func scanSQLiteAPIKey(s scanner) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	err := s.Scan(&k.ID, &k.CustomerID, &k.Name, &k.Hash, &k.SigningSecret, &scopes, &k.CreatedAt, &k.RevokedAt)
+	if err != nil {
+		return k, err
+	}
+	err = json.Unmarshal([]byte(scopes), &k.Scopes)
+
+	return k, err
+}
+
+func (r sqliteRepository) GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	return r.getAPIKey(ctx, `hash=$1`, hash)
+}
+
+func (r sqliteRepository) GetAPIKey(ctx context.Context, id uuid.UUID) (APIKey, bool, error) {
+	return r.getAPIKey(ctx, `id=$1`, id)
+}
+
+func (r sqliteRepository) getAPIKey(ctx context.Context, condition string, arg interface{}) (APIKey, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+condition, arg)
+	k, err := scanSQLiteAPIKey(row)
+	switch err {
+	case sql.ErrNoRows:
+		return k, false, nil
+	case nil:
+		return k, true, nil
+	default:
+		return k, false, err
+	}
+}
+
+func (r sqliteRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE api_keys SET revoked_at = strftime('%Y-%m-%d %H:%M:%f', 'now')
+WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+func (r sqliteRepository) SetSigningSecret(ctx context.Context, id uuid.UUID, secret string) (bool, error) {
+	return repository(r).SetSigningSecret(ctx, id, secret)
+}
+
+func (r sqliteRepository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanSQLiteAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/migrations"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	db, err := migrations.Open(migrations.SQLite, filepath.Join(t.TempDir(), "wallet.db"))
+	a.NoError(err)
+	defer db.Close()
+	_, err = migrations.Set.Exec(db, migrations.SQLiteDialect, migrations.SQLiteSource, migrate.Up)
+	a.NoError(err)
+	svc := NewService(NewSQLiteRepository(db))
+
+	c, err := svc.CreateCustomer(ctx, "acme")
+	a.NoError(err)
+	customers, err := svc.GetCustomers(ctx)
+	a.NoError(err)
+	a.Len(customers, 1)
+	a.Equal(c.ID, customers[0].ID)
+
+	_, _, err = svc.IssueAPIKey(ctx, uuid.New(), "mobile", []string{ScopeAccountsRead})
+	a.Equal(ErrCustomerNotExists, err)
+	k, key, err := svc.IssueAPIKey(ctx, c.ID, "mobile", []string{ScopeAccountsRead, ScopeTransfersWrite})
+	a.NoError(err)
+	p, err := svc.AuthenticateAPIKey(ctx, key)
+	a.NoError(err)
+	a.Equal(c.ID, p.CustomerID)
+	a.Equal([]string{ScopeAccountsRead, ScopeTransfersWrite}, p.Scopes)
+
+	secret, err := svc.IssueSigningSecret(ctx, k.ID)
+	a.NoError(err)
+	_, got, err := svc.GetSigningSecret(ctx, k.ID)
+	a.NoError(err)
+	a.Equal(secret, got)
+
+	a.NoError(svc.RevokeAPIKey(ctx, k.ID))
+	_, err = svc.AuthenticateAPIKey(ctx, key)
+	a.Equal(ErrUnauthenticated, err, "revoked key")
+	keys, err := svc.GetAPIKeys(ctx)
+	a.NoError(err)
+	a.Len(keys, 1)
+	a.NotNil(keys[0].RevokedAt)
+}
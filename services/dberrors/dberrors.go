@@ -0,0 +1,44 @@
+// Package dberrors recognizes constraint violations of postgres regardless of driver (lib/pq or pgx),
+// so repositories don't depend on error messages.
+package dberrors
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/lib/pq"
+)
+
+// SQLSTATE codes of constraint violations.
+const (
+	ForeignKeyViolation = "23503"
+	UniqueViolation     = "23505"
+)
+
+// Constraint gives SQLSTATE code and name of constraint violated by statement, ok is false for other errors.
+func Constraint(err error) (code, constraint string, ok bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code), pqErr.Constraint, true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, pgErr.ConstraintName, true
+	}
+
+	return "", "", false
+}
+
+// IsUniqueViolation reports whether err is violation of unique constraint (e.g. "transfers_pkey").
+func IsUniqueViolation(err error, constraint string) bool {
+	code, name, ok := Constraint(err)
+
+	return ok && code == UniqueViolation && name == constraint
+}
+
+// IsForeignKeyViolation reports whether err is violation of foreign key constraint (e.g. "mandates_sender_account_id_fkey").
+func IsForeignKeyViolation(err error, constraint string) bool {
+	code, name, ok := Constraint(err)
+
+	return ok && code == ForeignKeyViolation && name == constraint
+}
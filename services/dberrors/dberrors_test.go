@@ -0,0 +1,33 @@
+package dberrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	a := assert.New(t)
+	a.True(IsUniqueViolation(&pq.Error{Code: UniqueViolation, Constraint: "transfers_pkey"}, "transfers_pkey"))
+	a.True(IsUniqueViolation(&pgconn.PgError{Code: UniqueViolation, ConstraintName: "transfers_pkey"}, "transfers_pkey"))
+	a.True(IsUniqueViolation(fmt.Errorf("insert: %w",
+		&pgconn.PgError{Code: UniqueViolation, ConstraintName: "transfers_pkey"}), "transfers_pkey"), "wrapped")
+	a.False(IsUniqueViolation(&pq.Error{Code: UniqueViolation, Constraint: "mandates_pkey"}, "transfers_pkey"))
+	a.False(IsUniqueViolation(&pq.Error{Code: ForeignKeyViolation, Constraint: "transfers_pkey"}, "transfers_pkey"))
+	a.False(IsUniqueViolation(errors.New(`duplicate key value violates unique constraint "transfers_pkey"`), "transfers_pkey"))
+	a.False(IsUniqueViolation(nil, "transfers_pkey"))
+}
+
+func TestIsForeignKeyViolation(t *testing.T) {
+	a := assert.New(t)
+	a.True(IsForeignKeyViolation(&pq.Error{Code: ForeignKeyViolation, Constraint: "api_keys_customer_id_fkey"},
+		"api_keys_customer_id_fkey"))
+	a.True(IsForeignKeyViolation(&pgconn.PgError{Code: ForeignKeyViolation, ConstraintName: "api_keys_customer_id_fkey"},
+		"api_keys_customer_id_fkey"))
+	a.False(IsForeignKeyViolation(&pq.Error{Code: UniqueViolation, Constraint: "api_keys_customer_id_fkey"},
+		"api_keys_customer_id_fkey"))
+}
@@ -0,0 +1,139 @@
+// Package health provides liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// ErrShuttingDown makes instance not ready as soon as shutdown begins, so traffic is drained.
+var ErrShuttingDown = errors.New("shutting_down")
+
+// Check of dependency, nil means it's healthy.
+type Check func(ctx context.Context) error
+
+// DBCheck ensures db is reachable.
+func DBCheck(db *sql.DB) Check {
+	return db.PingContext
+}
+
+// MigrationsCheck ensures all migrations of source are applied, i.e. db schema is the one service expects.
+// Applied migrations are read from table that sql-migrate uses.
+func MigrationsCheck(db *sql.DB, table string, source migrate.MigrationSource) Check {
+	return func(ctx context.Context) error {
+		expected, err := source.FindMigrations()
+		if err != nil {
+			return err
+		}
+		rows, err := db.QueryContext(ctx, `SELECT id FROM `+table)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		applied := make(map[string]bool)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			applied[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, m := range expected {
+			if !applied[m.Id] {
+				return fmt.Errorf("migration %s is not applied", m.Id)
+			}
+		}
+
+		return nil
+	}
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs checks of readiness, it's safe for concurrent use.
+type Checker struct {
+	checks       []namedCheck
+	timeout      time.Duration
+	shuttingDown int32
+}
+
+// NewChecker with timeout of all checks together.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add check, it's supposed to be called before checker is used.
+func (c *Checker) Add(name string, check Check) *Checker {
+	c.checks = append(c.checks, namedCheck{name, check})
+
+	return c
+}
+
+// ShutDown makes instance not ready permanently.
+func (c *Checker) ShutDown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+// Status of readiness with results of each check ("ok" or error).
+type Status struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (c *Checker) Ready(ctx context.Context) Status {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	s := Status{Ready: true, Checks: make(map[string]string, len(c.checks)+1)}
+	result := func(name string, err error) {
+		if err != nil {
+			s.Ready = false
+			s.Checks[name] = err.Error()
+		} else {
+			s.Checks[name] = "ok"
+		}
+	}
+	var err error
+	if atomic.LoadInt32(&c.shuttingDown) == 1 {
+		err = ErrShuttingDown
+	}
+	result("shutdown", err)
+	for _, nc := range c.checks {
+		result(nc.name, nc.check(ctx))
+	}
+
+	return s
+}
+
+// NewHTTPHandler serves `/healthz` that responds while process is alive
+// and `/readyz` that responds 503 unless all checks pass.
+func NewHTTPHandler(c *Checker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"alive":true}` + "\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s := c.Ready(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !s.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(s)
+	})
+
+	return mux
+}
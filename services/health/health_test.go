@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+var source = &migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{
+	{Id: "1-initial.sql"},
+	{Id: "2-mandates.sql"},
+}}
+
+func get(h http.Handler, path string) (int, Status) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	var s Status
+	_ = json.NewDecoder(w.Body).Decode(&s)
+
+	return w.Code, s
+}
+
+func TestReadiness(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	a.NoError(err)
+	defer db.Close()
+	checker := NewChecker(time.Second).
+		Add("db", DBCheck(db)).
+		Add("migrations", MigrationsCheck(db, "migrations", source))
+	h := NewHTTPHandler(checker)
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1-initial.sql").AddRow("2-mandates.sql"))
+	code, s := get(h, "/readyz")
+	a.Equal(http.StatusOK, code)
+	a.Equal(Status{Ready: true, Checks: map[string]string{"db": "ok", "migrations": "ok", "shutdown": "ok"}}, s)
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1-initial.sql"))
+	code, s = get(h, "/readyz")
+	a.Equal(http.StatusServiceUnavailable, code)
+	a.False(s.Ready)
+	a.Equal("connection refused", s.Checks["db"])
+	a.Equal("migration 2-mandates.sql is not applied", s.Checks["migrations"])
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1-initial.sql").AddRow("2-mandates.sql"))
+	checker.ShutDown()
+	code, s = get(h, "/readyz")
+	a.Equal(http.StatusServiceUnavailable, code, "not ready as soon as shutdown begins")
+	a.Equal(ErrShuttingDown.Error(), s.Checks["shutdown"])
+	a.NoError(mock.ExpectationsWereMet())
+
+	code, _ = get(h, "/healthz")
+	a.Equal(http.StatusOK, code, "process is alive while shutting down")
+}
+
+func TestReadiness_Timeout(t *testing.T) {
+	a := assert.New(t)
+	checker := NewChecker(10*time.Millisecond).Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	s := checker.Ready(context.Background())
+	a.False(s.Ready)
+	a.Equal(context.DeadlineExceeded.Error(), s.Checks["slow"])
+}
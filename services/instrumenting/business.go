@@ -0,0 +1,27 @@
+package instrumenting
+
+import (
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewTransferVolume registers counter of transferred amount labelled by currency.
+func NewTransferVolume() metrics.Counter {
+	return kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "transfers",
+		Name:      "volume_total",
+		Help:      "Amount transferred between accounts.",
+	}, []string{"currency"})
+}
+
+// NewAuditFailures registers counter of calls whose audit entry isn't recorded labelled by action.
+func NewAuditFailures() metrics.Counter {
+	return kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "audit",
+		Name:      "record_failures_total",
+		Help:      "Number of calls done without audit entry recorded.",
+	}, []string{"action"})
+}
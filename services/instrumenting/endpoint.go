@@ -0,0 +1,91 @@
+// Package instrumenting provides prometheus metrics of endpoints, db and business events.
+package instrumenting
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace of all metrics of the service.
+const Namespace = "wallet"
+
+// InternalError labels errors that aren't domain ones (e.g. db failures),
+// so label cardinality is bounded by set of domain errors.
+const InternalError = "internal"
+
+// domain errors are snake_case codes returned to clients, e.g. insufficient_funds
+var domainError = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ErrorLabel gives domain error code or InternalError.
+func ErrorLabel(err error) string {
+	if domainError.MatchString(err.Error()) {
+		return err.Error()
+	}
+
+	return InternalError
+}
+
+// EndpointMetrics are labelled by service and method (endpoint name), errors also by error.
+type EndpointMetrics struct {
+	Requests metrics.Counter
+	Errors   metrics.Counter
+	Duration metrics.Histogram
+}
+
+// NewEndpointMetrics registers endpoint metrics in default prometheus registry.
+func NewEndpointMetrics() EndpointMetrics {
+	return EndpointMetrics{
+		Requests: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "endpoint",
+			Name:      "requests_total",
+			Help:      "Number of endpoint calls.",
+		}, []string{"service", "method"}),
+		Errors: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "endpoint",
+			Name:      "errors_total",
+			Help:      "Number of endpoint calls failed with error, including domain ones.",
+		}, []string{"service", "method", "error"}),
+		Duration: kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "endpoint",
+			Name:      "duration_seconds",
+			Help:      "Latency of endpoint calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "method"}),
+	}
+}
+
+// Middleware gives endpoint middleware by endpoint name (see Endpoints.Wrap of services).
+// Domain errors are taken from responses implementing endpoint.Failer.
+func (m EndpointMetrics) Middleware(service string) func(name string) endpoint.Middleware {
+	return func(name string) endpoint.Middleware {
+		requests := m.Requests.With("service", service, "method", name)
+		duration := m.Duration.With("service", service, "method", name)
+
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+				defer func(begin time.Time) {
+					requests.Add(1)
+					duration.Observe(time.Since(begin).Seconds())
+					failure := err
+					if f, ok := response.(endpoint.Failer); ok && failure == nil {
+						failure = f.Failed()
+					}
+					if failure != nil {
+						m.Errors.With("service", service, "method", name, "error", ErrorLabel(failure)).Add(1)
+					}
+				}(time.Now())
+
+				return next(ctx, request)
+			}
+		}
+	}
+}
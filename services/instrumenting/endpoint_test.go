@@ -0,0 +1,86 @@
+package instrumenting
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder sums values of counters and histograms by joined label values.
+type recorder struct {
+	mu     *sync.Mutex
+	values map[string]float64
+	lvs    []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{mu: &sync.Mutex{}, values: map[string]float64{}}
+}
+
+func (r *recorder) with(labelValues ...string) *recorder {
+	return &recorder{mu: r.mu, values: r.values, lvs: append(append([]string(nil), r.lvs...), labelValues...)}
+}
+
+func (r *recorder) add(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[strings.Join(r.lvs, ",")] += v
+}
+
+type counter struct{ *recorder }
+
+func (c counter) With(labelValues ...string) metrics.Counter { return counter{c.with(labelValues...)} }
+func (c counter) Add(delta float64)                          { c.add(delta) }
+
+type histogram struct{ *recorder }
+
+func (h histogram) With(labelValues ...string) metrics.Histogram {
+	return histogram{h.with(labelValues...)}
+}
+func (h histogram) Observe(float64) { h.add(1) }
+
+type response struct {
+	Err error
+}
+
+func (r response) Failed() error { return r.Err }
+
+func TestErrorLabel(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("insufficient_funds", ErrorLabel(errors.New("insufficient_funds")))
+	a.Equal(InternalError, ErrorLabel(errors.New(`pq: relation "accounts" does not exist`)))
+}
+
+func TestEndpointMetrics_Middleware(t *testing.T) {
+	a := assert.New(t)
+	requests, errs, duration := newRecorder(), newRecorder(), newRecorder()
+	m := EndpointMetrics{Requests: counter{requests}, Errors: counter{errs}, Duration: histogram{duration}}
+	mw := m.Middleware("transfers")("CreateTransfer")
+	ctx := context.Background()
+
+	_, _ = mw(func(context.Context, interface{}) (interface{}, error) {
+		return response{}, nil
+	})(ctx, nil)
+	_, _ = mw(func(context.Context, interface{}) (interface{}, error) {
+		return response{Err: errors.New("insufficient_funds")}, nil
+	})(ctx, nil)
+	_, _ = mw(func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("forbidden")
+	})(ctx, nil)
+	_, _ = mw(func(context.Context, interface{}) (interface{}, error) {
+		return response{Err: errors.New("sql: connection is already closed")}, nil
+	})(ctx, nil)
+
+	a.Equal(map[string]float64{"service,transfers,method,CreateTransfer": 4}, requests.values)
+	a.Equal(map[string]float64{"service,transfers,method,CreateTransfer": 4}, duration.values)
+	a.Equal(map[string]float64{
+		"service,transfers,method,CreateTransfer,error,insufficient_funds": 1,
+		"service,transfers,method,CreateTransfer,error,forbidden":          1,
+		"service,transfers,method,CreateTransfer,error,internal":           1,
+	}, errs.values)
+}
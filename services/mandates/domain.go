@@ -0,0 +1,91 @@
+package mandates
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+// Business logic level errors that provide enough information about what went wrong.
+var (
+	ErrEmptyMandateID       = errors.New("mandate_id_is_empty")
+	ErrInvalidSchedule      = errors.New("mandate_schedule_invalid")
+	ErrMandateNotExists     = errors.New("mandate_not_exist")
+	ErrMandateCancelled     = errors.New("mandate_cancelled")
+	ErrMandateStatusChanged = errors.New("mandate_status_changed_concurrently")
+	ErrMandateIDUsed        = errors.New("mandate_id_used_by_other_order")
+)
+
+// Mandate status enums.
+const (
+	Active    = "ACTIVE"
+	Paused    = "PAUSED"
+	Cancelled = "CANCELLED"
+)
+
+// Occurrence status enums.
+const (
+	Succeeded = "SUCCEEDED"
+	Failed    = "FAILED"
+)
+
+// Mandate order for system to register standing transfer between two accounts.
+type MandateOrder struct {
+	ID                uuid.UUID       `json:"id"`
+	SenderAccountID   uuid.UUID       `json:"sender_account_id"`
+	ReceiverAccountID uuid.UUID       `json:"receiver_account_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	CurrencyCode      string          `json:"currency_code"`
+	// cron expression (e.g. "0 9 * * 1") or descriptor (@daily, @weekly, @monthly)
+	Schedule string `json:"schedule"`
+	// optional, occurrences are generated strictly after this moment, now by default or if it's in the past
+	StartAt *time.Time `json:"start_at"`
+}
+
+// Mandate (standing order) that generates inner transfers by schedule.
+type Mandate struct {
+	ID                uuid.UUID       `json:"id"`
+	SenderAccountID   uuid.UUID       `json:"sender_account_id"`
+	ReceiverAccountID uuid.UUID       `json:"receiver_account_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	CurrencyCode      string          `json:"currency_code"`
+	Schedule          string          `json:"schedule"`
+	Status            string          `json:"status"` // Active, Paused, Cancelled
+	NextRunAt         time.Time       `json:"next_run_at"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+// Occurrence is an outcome of single scheduled execution of mandate.
+type Occurrence struct {
+	MandateID   uuid.UUID `json:"mandate_id"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	TransferID  uuid.UUID `json:"transfer_id"`
+	Status      string    `json:"status"` // Succeeded, Failed
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TransferGetter finds transfer by id regardless of principal, it's implemented by transfers.AdminService.
+type TransferGetter interface {
+	// returns transfers.ErrTransferNotExists if there is no transfer
+	GetTransfer(ctx context.Context, transferID uuid.UUID) (transfers.TransferDetails, error)
+}
+
+// Business actions.
+type Service interface {
+	CreateMandate(ctx context.Context, order MandateOrder) error
+	GetMandate(ctx context.Context, id uuid.UUID) (Mandate, error)
+	PauseMandate(ctx context.Context, id uuid.UUID) error
+	ResumeMandate(ctx context.Context, id uuid.UUID) error
+	CancelMandate(ctx context.Context, id uuid.UUID) error
+	GetOccurrences(ctx context.Context, mandateID uuid.UUID) ([]Occurrence, error)
+	// executes occurrences that are due at the moment, returns count of executed ones,
+	// occurrences failed by infrastructure are left for next call and reported by error after the others are executed
+	ExecuteDueOccurrences(ctx context.Context, now time.Time) (int, error)
+}
@@ -0,0 +1,152 @@
+package mandates
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+type CreateMandateRequest struct {
+	MandateOrder
+}
+
+type CreateMandateResponse struct {
+	Err error
+}
+
+func (r CreateMandateResponse) Failed() error { return r.Err }
+
+func MakeCreateMandateEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateMandateRequest)
+		err := s.CreateMandate(ctx, req.MandateOrder)
+
+		return CreateMandateResponse{Err: err}, nil
+	}
+}
+
+type GetMandateRequest struct {
+	MandateID uuid.UUID
+}
+
+type GetMandateResponse struct {
+	Mandate *Mandate
+	Err     error
+}
+
+func (r GetMandateResponse) Failed() error { return r.Err }
+
+func MakeGetMandateEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetMandateRequest)
+		m, err := s.GetMandate(ctx, req.MandateID)
+		if err != nil {
+			return GetMandateResponse{Err: err}, nil
+		}
+
+		return GetMandateResponse{Mandate: &m}, nil
+	}
+}
+
+// ChangeMandateStatusRequest is used for pause, resume and cancel actions.
+type ChangeMandateStatusRequest struct {
+	MandateID uuid.UUID
+}
+
+type ChangeMandateStatusResponse struct {
+	Err error
+}
+
+func (r ChangeMandateStatusResponse) Failed() error { return r.Err }
+
+func makeChangeStatusEndpoint(change func(ctx context.Context, id uuid.UUID) error) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChangeMandateStatusRequest)
+		err := change(ctx, req.MandateID)
+
+		return ChangeMandateStatusResponse{Err: err}, nil
+	}
+}
+
+func MakePauseMandateEndpoint(s Service) endpoint.Endpoint {
+	return makeChangeStatusEndpoint(s.PauseMandate)
+}
+
+func MakeResumeMandateEndpoint(s Service) endpoint.Endpoint {
+	return makeChangeStatusEndpoint(s.ResumeMandate)
+}
+
+func MakeCancelMandateEndpoint(s Service) endpoint.Endpoint {
+	return makeChangeStatusEndpoint(s.CancelMandate)
+}
+
+type GetOccurrencesRequest struct {
+	MandateID uuid.UUID
+}
+
+type GetOccurrencesResponse struct {
+	Occurrences []Occurrence
+	Err         error
+}
+
+func (r GetOccurrencesResponse) Failed() error { return r.Err }
+
+func MakeGetOccurrencesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetOccurrencesRequest)
+		occurrences, err := s.GetOccurrences(ctx, req.MandateID)
+
+		return GetOccurrencesResponse{Occurrences: occurrences, Err: err}, nil
+	}
+}
+
+func NewEndpoints(s Service) Endpoints {
+	return Endpoints{
+		CreateMandate:  MakeCreateMandateEndpoint(s),
+		GetMandate:     MakeGetMandateEndpoint(s),
+		PauseMandate:   MakePauseMandateEndpoint(s),
+		ResumeMandate:  MakeResumeMandateEndpoint(s),
+		CancelMandate:  MakeCancelMandateEndpoint(s),
+		GetOccurrences: MakeGetOccurrencesEndpoint(s),
+	}
+}
+
+type Endpoints struct {
+	CreateMandate  endpoint.Endpoint
+	GetMandate     endpoint.Endpoint
+	PauseMandate   endpoint.Endpoint
+	ResumeMandate  endpoint.Endpoint
+	CancelMandate  endpoint.Endpoint
+	GetOccurrences endpoint.Endpoint
+}
+
+// EndpointScopes required by endpoints, see auth.ScopeMiddleware.
+var EndpointScopes = map[string]string{
+	"CreateMandate":  auth.ScopeMandatesWrite,
+	"GetMandate":     auth.ScopeMandatesRead,
+	"PauseMandate":   auth.ScopeMandatesWrite,
+	"ResumeMandate":  auth.ScopeMandatesWrite,
+	"CancelMandate":  auth.ScopeMandatesWrite,
+	"GetOccurrences": auth.ScopeMandatesRead,
+}
+
+// ReadEndpoints don't change state, so they aren't recorded by audit.Middleware.
+var ReadEndpoints = map[string]bool{
+	"GetMandate":     true,
+	"GetOccurrences": true,
+}
+
+// Wrap decorates each endpoint with middleware built for its name.
+func (e Endpoints) Wrap(mw func(name string) endpoint.Middleware) Endpoints {
+	e.CreateMandate = mw("CreateMandate")(e.CreateMandate)
+	e.GetMandate = mw("GetMandate")(e.GetMandate)
+	e.PauseMandate = mw("PauseMandate")(e.PauseMandate)
+	e.ResumeMandate = mw("ResumeMandate")(e.ResumeMandate)
+	e.CancelMandate = mw("CancelMandate")(e.CancelMandate)
+	e.GetOccurrences = mw("GetOccurrences")(e.GetOccurrences)
+
+	return e
+}
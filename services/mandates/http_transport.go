@@ -0,0 +1,110 @@
+package mandates
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+func NewHTTPHandler(endpoints Endpoints, logger log.Logger) http.Handler {
+	r := mux.NewRouter().StrictSlash(true)
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(transfers.ErrorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+	r.Handle("/mandates/",
+		httptransport.NewServer(endpoints.CreateMandate,
+			DecodeCreateMandateRequest, EncodeErrorOnlyResponse, options...)).
+		Methods("POST")
+	r.Handle("/mandates/{mandate_id}/",
+		httptransport.NewServer(endpoints.GetMandate,
+			DecodeGetMandateRequest, EncodeGetMandateResponse, options...)).
+		Methods("GET")
+	r.Handle("/mandates/{mandate_id}/pause",
+		httptransport.NewServer(endpoints.PauseMandate,
+			DecodeChangeMandateStatusRequest, EncodeErrorOnlyResponse, options...)).
+		Methods("POST")
+	r.Handle("/mandates/{mandate_id}/resume",
+		httptransport.NewServer(endpoints.ResumeMandate,
+			DecodeChangeMandateStatusRequest, EncodeErrorOnlyResponse, options...)).
+		Methods("POST")
+	r.Handle("/mandates/{mandate_id}/cancel",
+		httptransport.NewServer(endpoints.CancelMandate,
+			DecodeChangeMandateStatusRequest, EncodeErrorOnlyResponse, options...)).
+		Methods("POST")
+	r.Handle("/mandates/{mandate_id}/occurrences/",
+		httptransport.NewServer(endpoints.GetOccurrences,
+			DecodeGetOccurrencesRequest, EncodeGetOccurrencesResponse, options...)).
+		Methods("GET")
+
+	return r
+}
+
+func mandateIDFrom(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(mux.Vars(r)["mandate_id"])
+}
+
+func DecodeCreateMandateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req CreateMandateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	return req, err
+}
+
+func DecodeGetMandateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := mandateIDFrom(r)
+
+	return GetMandateRequest{MandateID: id}, err
+}
+
+func DecodeChangeMandateStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := mandateIDFrom(r)
+
+	return ChangeMandateStatusRequest{MandateID: id}, err
+}
+
+func DecodeGetOccurrencesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := mandateIDFrom(r)
+
+	return GetOccurrencesRequest{MandateID: id}, err
+}
+
+// errorResponse is implemented by responses that carry only business error.
+type errorResponse interface {
+	error() error
+}
+
+func (r CreateMandateResponse) error() error       { return r.Err }
+func (r ChangeMandateStatusResponse) error() error { return r.Err }
+
+func EncodeErrorOnlyResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var err error
+	if response, ok := res.(errorResponse); ok {
+		err = response.error()
+	}
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(nil, err))
+}
+
+func EncodeGetMandateResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetMandateResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Mandate, response.Err))
+}
+
+func EncodeGetOccurrencesResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetOccurrencesResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Occurrences, response.Err))
+}
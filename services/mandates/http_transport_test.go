@@ -0,0 +1,113 @@
+package mandates
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type svcMock struct{}
+
+func (m svcMock) CreateMandate(ctx context.Context, order MandateOrder) error {
+	return nil
+}
+
+func (m svcMock) GetMandate(ctx context.Context, id uuid.UUID) (Mandate, error) {
+	return Mandate{}, ErrMandateNotExists
+}
+
+func (m svcMock) PauseMandate(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (m svcMock) ResumeMandate(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (m svcMock) CancelMandate(ctx context.Context, id uuid.UUID) error {
+	return ErrMandateCancelled
+}
+
+func (m svcMock) GetOccurrences(ctx context.Context, mandateID uuid.UUID) ([]Occurrence, error) {
+	return []Occurrence{{
+		MandateID: mandateID, TransferID: mandateID, Status: Failed, Error: "insufficient_funds",
+	}}, nil
+}
+
+func (m svcMock) ExecuteDueOccurrences(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+func TestCreateMandate(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("POST", "/mandates/",
+		bytes.NewBuffer([]byte(`{"id":"AB363360-632B-4643-B93F-0486B764E98D","schedule":"@monthly"}`)))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.Equal("application/json; charset=utf-8", response.Header().Get("content-type"))
+	a.JSONEq(`{"result":"OK"}`, response.Body.String())
+}
+
+func TestGetMandateNotExists(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/mandates/AB363360-632B-4643-B93F-0486B764E98D/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"mandate_not_exist"}`, response.Body.String())
+}
+
+func TestCancelMandate(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("POST", "/mandates/AB363360-632B-4643-B93F-0486B764E98D/cancel", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"mandate_cancelled"}`, response.Body.String())
+}
+
+func TestPauseMandateInvalidID(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("POST", "/mandates/not-uuid/pause", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"invalid UUID length: 8"}`, response.Body.String())
+}
+
+func TestGetOccurrences(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/mandates/AB363360-632B-4643-B93F-0486B764E98D/occurrences/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{
+  "result": "OK",
+  "payload": [
+    {
+      "mandate_id": "ab363360-632b-4643-b93f-0486b764e98d",
+      "scheduled_at": "0001-01-01T00:00:00Z",
+      "transfer_id": "ab363360-632b-4643-b93f-0486b764e98d",
+      "status": "FAILED",
+      "error": "insufficient_funds",
+      "created_at": "0001-01-01T00:00:00Z"
+    }
+  ]
+}`, response.Body.String())
+}
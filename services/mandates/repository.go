@@ -0,0 +1,180 @@
+package mandates
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/dberrors"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+// Foreign key constraints of mandates table.
+const (
+	SenderAccountConstraint   = "mandates_sender_account_id_fkey"
+	ReceiverAccountConstraint = "mandates_receiver_account_id_fkey"
+	CurrencyConstraint        = "mandates_currency_code_fkey"
+)
+
+type Repository interface {
+	CreateMandate(ctx context.Context, m Mandate) error
+	// For separation business logic errors from database errors
+	IsMandateIDUsedError(err error) bool
+	IsForeignKeyError(err error, constraint string) bool
+	GetMandate(ctx context.Context, id uuid.UUID) (Mandate, bool, error)
+	// changes status only if it is equal to from, returns transfers.ErrNoRowsAffected otherwise
+	UpdateMandateStatus(ctx context.Context, id uuid.UUID, from, to string, nextRunAt time.Time) error
+	// active mandates with next run not later than now
+	GetDueMandates(ctx context.Context, now time.Time, limit uint) ([]Mandate, error)
+	// records occurrence outcome, succeeded occurrence is never overwritten
+	CreateOccurrence(ctx context.Context, o Occurrence) error
+	// moves next run only if it is equal to from, returns transfers.ErrNoRowsAffected otherwise
+	AdvanceNextRun(ctx context.Context, id uuid.UUID, from, to time.Time) error
+	GetOccurrences(ctx context.Context, mandateID uuid.UUID, limit uint) ([]Occurrence, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func validateAffected(res sql.Result) error {
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return transfers.ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+func (r repository) CreateMandate(ctx context.Context, m Mandate) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO mandates(id, sender_account_id, receiver_account_id, amount, currency_code, schedule, status, next_run_at)
+ VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		m.ID, m.SenderAccountID, m.ReceiverAccountID, m.Amount, m.CurrencyCode, m.Schedule, m.Status, m.NextRunAt)
+
+	return err
+}
+
+func (r repository) IsMandateIDUsedError(err error) bool {
+	return dberrors.IsUniqueViolation(err, "mandates_pkey")
+}
+
+func (r repository) IsForeignKeyError(err error, constraint string) bool {
+	return dberrors.IsForeignKeyViolation(err, constraint)
+}
+
+const mandateColumns = `id, sender_account_id, receiver_account_id, amount, currency_code,
+ schedule, status, next_run_at, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMandate(s scanner) (Mandate, error) {
+	var m Mandate
+	err := s.Scan(&m.ID, &m.SenderAccountID, &m.ReceiverAccountID, &m.Amount, &m.CurrencyCode,
+		&m.Schedule, &m.Status, &m.NextRunAt, &m.CreatedAt, &m.UpdatedAt)
+
+	return m, err
+}
+
+func (r repository) GetMandate(ctx context.Context, id uuid.UUID) (Mandate, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+mandateColumns+` FROM mandates WHERE id=$1`, id)
+	m, err := scanMandate(row)
+	switch err {
+	case sql.ErrNoRows:
+		return m, false, nil
+	case nil:
+		return m, true, nil
+	default:
+		return m, false, err
+	}
+}
+
+func (r repository) UpdateMandateStatus(
+	ctx context.Context, id uuid.UUID, from, to string, nextRunAt time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE mandates
+SET status = $1, next_run_at = $2, updated_at = now()
+WHERE id = $3 AND status = $4`, to, nextRunAt, id, from)
+	if err != nil {
+		return err
+	}
+
+	return validateAffected(res)
+}
+
+func (r repository) GetDueMandates(ctx context.Context, now time.Time, limit uint) ([]Mandate, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+mandateColumns+` FROM mandates
+WHERE status = 'ACTIVE' AND next_run_at <= $1 ORDER BY next_run_at LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mandates := make([]Mandate, 0, limit)
+	for rows.Next() {
+		m, err := scanMandate(rows)
+		if err != nil {
+			return nil, err
+		}
+		mandates = append(mandates, m)
+	}
+
+	return mandates, rows.Err()
+}
+
+func (r repository) CreateOccurrence(ctx context.Context, o Occurrence) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO mandate_occurrences(mandate_id, scheduled_at, transfer_id, status, error)
+ VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (mandate_id, scheduled_at) DO UPDATE
+SET status = EXCLUDED.status, error = EXCLUDED.error, created_at = now()
+WHERE mandate_occurrences.status = 'FAILED'`,
+		o.MandateID, o.ScheduledAt, o.TransferID, o.Status, o.Error)
+
+	return err
+}
+
+func (r repository) AdvanceNextRun(ctx context.Context, id uuid.UUID, from, to time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE mandates
+SET next_run_at = $1, updated_at = now()
+WHERE id = $2 AND next_run_at = $3`, to, id, from)
+	if err != nil {
+		return err
+	}
+
+	return validateAffected(res)
+}
+
+func (r repository) GetOccurrences(ctx context.Context, mandateID uuid.UUID, limit uint) ([]Occurrence, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT mandate_id, scheduled_at, transfer_id, status, error, created_at FROM mandate_occurrences
+WHERE mandate_id = $1 ORDER BY scheduled_at DESC
+LIMIT $2`, mandateID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	occurrences := make([]Occurrence, 0, limit)
+	var o Occurrence
+	for rows.Next() {
+		err := rows.Scan(&o.MandateID, &o.ScheduledAt, &o.TransferID, &o.Status, &o.Error, &o.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, o)
+	}
+
+	return occurrences, rows.Err()
+}
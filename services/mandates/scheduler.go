@@ -0,0 +1,41 @@
+package mandates
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Scheduler periodically executes due occurrences of mandates.
+// Several schedulers may run concurrently (e.g. one per replica) without double payments.
+type Scheduler struct {
+	svc      Service
+	interval time.Duration
+	logger   log.Logger
+}
+
+func NewScheduler(svc Service, interval time.Duration, logger log.Logger) Scheduler {
+	return Scheduler{svc: svc, interval: interval, logger: logger}
+}
+
+// Run blocks until ctx is done.
+func (s Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		executed, err := s.svc.ExecuteDueOccurrences(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			_ = level.Error(s.logger).Log("msg", "mandates execution failed", "err", err.Error())
+		}
+		if executed > 0 {
+			_ = level.Debug(s.logger).Log("msg", "mandate occurrences executed", "count", executed)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package mandates
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+const dueBatchSize = 100
+
+type service struct {
+	repo      Repository
+	transfers transfers.Service
+	getter    TransferGetter
+}
+
+func NewService(repo Repository, transfersService transfers.Service, getter TransferGetter) Service {
+	return service{repo: repo, transfers: transfersService, getter: getter}
+}
+
+// OccurrenceTransferID gives deterministic idempotency id of transfer for mandate occurrence,
+// so repeated execution of the same occurrence never creates second transfer.
+func OccurrenceTransferID(mandateID uuid.UUID, scheduledAt time.Time) uuid.UUID {
+	return uuid.NewSHA1(mandateID, []byte(scheduledAt.UTC().Format(time.RFC3339)))
+}
+
+func nextRunAfter(schedule string, after time.Time) (time.Time, error) {
+	s, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, ErrInvalidSchedule
+	}
+	next := s.Next(after.UTC())
+	if next.IsZero() {
+		return time.Time{}, ErrInvalidSchedule
+	}
+
+	return next, nil
+}
+
+func (s service) CreateMandate(ctx context.Context, o MandateOrder) error {
+	if o.ID == uuid.Nil {
+		return ErrEmptyMandateID
+	}
+	if o.ReceiverAccountID == uuid.Nil {
+		return transfers.ErrEmptyReceiverAccountID
+	}
+	if o.SenderAccountID == uuid.Nil {
+		return transfers.ErrEmptySenderAccountID
+	}
+	if o.ReceiverAccountID == o.SenderAccountID {
+		return transfers.ErrAccountsMustBeDifferent
+	}
+	if !o.Amount.IsPositive() {
+		return transfers.ErrAmountMustBePositive
+	}
+	// only owner of sender account may create mandate, receiver may be any one
+	_, err := s.transfers.GetAccount(ctx, o.SenderAccountID)
+	if err == transfers.ErrAccountNotExists {
+		return transfers.ErrSenderNotExists
+	}
+	if err != nil {
+		return err
+	}
+	// occurrences before now are never generated, otherwise every missed one would be paid by scheduler
+	startAt := time.Now()
+	if o.StartAt != nil && o.StartAt.After(startAt) {
+		startAt = *o.StartAt
+	}
+	nextRunAt, err := nextRunAfter(o.Schedule, startAt)
+	if err != nil {
+		return err
+	}
+
+	m := Mandate{
+		ID:                o.ID,
+		SenderAccountID:   o.SenderAccountID,
+		ReceiverAccountID: o.ReceiverAccountID,
+		Amount:            o.Amount,
+		CurrencyCode:      strings.ToUpper(o.CurrencyCode),
+		Schedule:          o.Schedule,
+		Status:            Active,
+		NextRunAt:         nextRunAt,
+	}
+	err = s.repo.CreateMandate(ctx, m)
+	switch {
+	case s.repo.IsMandateIDUsedError(err):
+		// repeated creation is OK, mandate of other customer looks like nonexistent one
+		existing, err := s.GetMandate(ctx, o.ID)
+		if err != nil {
+			return err
+		}
+		if !sameOrder(existing, m) {
+			return ErrMandateIDUsed
+		}
+
+		return nil
+	case s.repo.IsForeignKeyError(err, SenderAccountConstraint):
+		return transfers.ErrSenderNotExists
+	case s.repo.IsForeignKeyError(err, ReceiverAccountConstraint):
+		return transfers.ErrReceiverNotExists
+	case s.repo.IsForeignKeyError(err, CurrencyConstraint):
+		return transfers.ErrUnsupportedCurrency
+	}
+
+	return err
+}
+
+// sameOrder tells whether mandate is created by the same order, its status and next run may change since then.
+func sameOrder(existing, m Mandate) bool {
+	return existing.SenderAccountID == m.SenderAccountID && existing.ReceiverAccountID == m.ReceiverAccountID &&
+		existing.Amount.Equal(m.Amount) && existing.CurrencyCode == m.CurrencyCode && existing.Schedule == m.Schedule
+}
+
+func (s service) GetMandate(ctx context.Context, id uuid.UUID) (Mandate, error) {
+	m, ok, err := s.repo.GetMandate(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if !ok {
+		return m, ErrMandateNotExists
+	}
+	// mandate is accessible the same way as its sender account
+	_, err = s.transfers.GetAccount(ctx, m.SenderAccountID)
+	if err == transfers.ErrAccountNotExists {
+		return Mandate{}, ErrMandateNotExists
+	}
+	if err != nil {
+		return Mandate{}, err
+	}
+
+	return m, nil
+}
+
+func (s service) changeStatus(ctx context.Context, id uuid.UUID, status string) error {
+	m, err := s.GetMandate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if m.Status == status {
+		return nil
+	}
+	if m.Status == Cancelled {
+		return ErrMandateCancelled
+	}
+	nextRunAt := m.NextRunAt
+	if status == Active && nextRunAt.Before(time.Now()) {
+		// occurrences missed while paused are skipped
+		nextRunAt, err = nextRunAfter(m.Schedule, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	err = s.repo.UpdateMandateStatus(ctx, id, m.Status, status, nextRunAt)
+	if err == transfers.ErrNoRowsAffected {
+		return ErrMandateStatusChanged
+	}
+
+	return err
+}
+
+func (s service) PauseMandate(ctx context.Context, id uuid.UUID) error {
+	return s.changeStatus(ctx, id, Paused)
+}
+
+func (s service) ResumeMandate(ctx context.Context, id uuid.UUID) error {
+	return s.changeStatus(ctx, id, Active)
+}
+
+func (s service) CancelMandate(ctx context.Context, id uuid.UUID) error {
+	return s.changeStatus(ctx, id, Cancelled)
+}
+
+func (s service) GetOccurrences(ctx context.Context, mandateID uuid.UUID) ([]Occurrence, error) {
+	if _, err := s.GetMandate(ctx, mandateID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetOccurrences(ctx, mandateID, 100)
+}
+
+// executeOccurrence is safe to be called several times for the same occurrence
+// (e.g. after restart or by concurrent schedulers) because transfer id is deterministic.
+// Transfer applied by previous call is looked up first: its retry could fail by checks
+// of current state (e.g. funds spent since then) and record paid occurrence as failed.
+func (s service) executeOccurrence(ctx context.Context, m Mandate) error {
+	o := Occurrence{
+		MandateID:   m.ID,
+		ScheduledAt: m.NextRunAt,
+		TransferID:  OccurrenceTransferID(m.ID, m.NextRunAt),
+		Status:      Succeeded,
+	}
+	_, err := s.getter.GetTransfer(ctx, o.TransferID)
+	switch err {
+	case nil:
+		// applied by previous call that didn't record occurrence
+	case transfers.ErrTransferNotExists:
+		err = s.transfers.CreateTransfer(ctx, transfers.InnerTransferOrder{
+			ID:                o.TransferID,
+			SenderAccountID:   m.SenderAccountID,
+			ReceiverAccountID: m.ReceiverAccountID,
+			Amount:            m.Amount,
+			CurrencyCode:      m.CurrencyCode,
+		})
+		if err != nil {
+			if !transfers.IsBusinessError(err) {
+				return err // infrastructure problem, occurrence is retried later
+			}
+			o.Status = Failed
+			o.Error = err.Error()
+		}
+	default:
+		return err
+	}
+	err = s.repo.CreateOccurrence(ctx, o)
+	if err != nil {
+		return err
+	}
+	nextRunAt, err := nextRunAfter(m.Schedule, m.NextRunAt)
+	if err != nil {
+		return err
+	}
+	err = s.repo.AdvanceNextRun(ctx, m.ID, m.NextRunAt, nextRunAt)
+	if err == transfers.ErrNoRowsAffected {
+		return nil // already advanced by someone else
+	}
+
+	return err
+}
+
+func (s service) ExecuteDueOccurrences(ctx context.Context, now time.Time) (int, error) {
+	due, err := s.repo.GetDueMandates(ctx, now.UTC(), dueBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var executed int
+	var failures []string
+	for _, m := range due {
+		// failing mandate stays first of due ones, so it's skipped rather than blocking the others
+		if err = s.executeOccurrence(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return executed, err
+			}
+			failures = append(failures, "mandate "+m.ID.String()+": "+err.Error())
+
+			continue
+		}
+		executed++
+	}
+	if len(failures) > 0 {
+		return executed, errors.New(strings.Join(failures, "; "))
+	}
+
+	return executed, nil
+}
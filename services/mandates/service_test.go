@@ -0,0 +1,389 @@
+package mandates
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/dberrors"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+type transfersMock struct {
+	orders []transfers.InnerTransferOrder
+	err    error
+	// accounts inaccessible via GetAccount
+	foreign map[uuid.UUID]bool
+	// transfers found by GetTransfer
+	applied map[uuid.UUID]bool
+	// errors of transfers by sender account
+	failing map[uuid.UUID]error
+}
+
+func (m *transfersMock) GetTransfer(ctx context.Context, transferID uuid.UUID) (transfers.TransferDetails, error) {
+	if !m.applied[transferID] {
+		return transfers.TransferDetails{}, transfers.ErrTransferNotExists
+	}
+
+	return transfers.TransferDetails{Transfer: transfers.Transfer{ID: transferID}}, nil
+}
+
+func (m *transfersMock) CreateTransfer(ctx context.Context, order transfers.InnerTransferOrder) error {
+	m.orders = append(m.orders, order)
+	if err, ok := m.failing[order.SenderAccountID]; ok {
+		return err
+	}
+	return m.err
+}
+
+func (m *transfersMock) GetTransfersForAccount(ctx context.Context, accountID uuid.UUID) ([]transfers.TransferInfo, error) {
+	return nil, nil
+}
+
+func (m *transfersMock) GetAccounts(ctx context.Context) ([]transfers.Account, error) {
+	return nil, nil
+}
+
+func (m *transfersMock) GetAccount(ctx context.Context, accountID uuid.UUID) (transfers.Account, error) {
+	if m.foreign[accountID] {
+		return transfers.Account{}, transfers.ErrAccountNotExists
+	}
+
+	return transfers.Account{ID: accountID}, nil
+}
+
+func (m *transfersMock) GetCurrencies(ctx context.Context) ([]transfers.Currency, error) {
+	return nil, nil
+}
+
+func (m *transfersMock) SaveCurrency(ctx context.Context, c transfers.Currency) (transfers.Currency, error) {
+	return c, nil
+}
+
+func (m *transfersMock) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (transfers.Currency, error) {
+	return transfers.Currency{}, nil
+}
+
+func prepare() (Service, *transfersMock, sqlmock.Sqlmock, error, func()) {
+	db, mock, err := sqlmock.New()
+	tm := &transfersMock{}
+	service := NewService(NewRepository(db), tm, tm)
+	return service, tm, mock, err, func() {
+		db.Close()
+	}
+}
+
+func newValidOrder() MandateOrder {
+	return MandateOrder{
+		ID:                uuid.New(),
+		SenderAccountID:   uuid.New(),
+		ReceiverAccountID: uuid.New(),
+		Amount:            decimal.New(10, 0),
+		CurrencyCode:      "usd",
+		Schedule:          "@weekly",
+	}
+}
+
+var mandateColumnNames = []string{
+	"id", "sender_account_id", "receiver_account_id", "amount", "currency_code",
+	"schedule", "status", "next_run_at", "created_at", "updated_at",
+}
+
+func TestOccurrenceTransferID(t *testing.T) {
+	a := assert.New(t)
+	id := uuid.New()
+	at := time.Date(2020, 10, 5, 0, 0, 0, 0, time.UTC)
+	a.Equal(OccurrenceTransferID(id, at), OccurrenceTransferID(id, at.In(time.FixedZone("X", 3600))))
+	a.NotEqual(OccurrenceTransferID(id, at), OccurrenceTransferID(id, at.AddDate(0, 0, 7)))
+	a.NotEqual(OccurrenceTransferID(id, at), OccurrenceTransferID(uuid.New(), at))
+}
+
+func TestService_CreateMandate_validate(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	order := newValidOrder()
+	order.ID = uuid.Nil
+	a.Equal(ErrEmptyMandateID, svc.CreateMandate(context.Background(), order))
+	order = newValidOrder()
+	order.SenderAccountID = order.ReceiverAccountID
+	a.Equal(transfers.ErrAccountsMustBeDifferent, svc.CreateMandate(context.Background(), order))
+	order = newValidOrder()
+	order.Amount = decimal.Zero
+	a.Equal(transfers.ErrAmountMustBePositive, svc.CreateMandate(context.Background(), order))
+	order = newValidOrder()
+	order.Schedule = "every tuesday"
+	a.Equal(ErrInvalidSchedule, svc.CreateMandate(context.Background(), order))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CreateMandate(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	order := newValidOrder()
+	start := time.Date(2120, 10, 3, 12, 0, 0, 0, time.UTC) // Thursday
+	order.StartAt = &start
+	mock.ExpectExec("INSERT INTO mandates").
+		WithArgs(order.ID, order.SenderAccountID, order.ReceiverAccountID, order.Amount, "USD",
+			"@weekly", Active, time.Date(2120, 10, 6, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	a.NoError(svc.CreateMandate(context.Background(), order))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+// nextRunAtArg matches next run within a minute from now.
+type nextRunAtArg struct{}
+
+func (nextRunAtArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+
+	return ok && t.After(time.Now()) && t.Before(time.Now().Add(time.Minute))
+}
+
+func TestService_CreateMandate_PastStart(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	order := newValidOrder()
+	order.Schedule = "* * * * *"
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	order.StartAt = &start
+	mock.ExpectExec("INSERT INTO mandates").
+		WithArgs(order.ID, order.SenderAccountID, order.ReceiverAccountID, order.Amount, "USD",
+			"* * * * *", Active, nextRunAtArg{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	a.NoError(svc.CreateMandate(context.Background(), order))
+	a.NoError(mock.ExpectationsWereMet(), "missed occurrences since start aren't generated")
+}
+
+func TestService_CreateMandate_IDUsed(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	order := newValidOrder()
+	used := &pq.Error{Code: dberrors.UniqueViolation, Constraint: "mandates_pkey"}
+	existing := func(sender uuid.UUID, amount string) *sqlmock.Rows {
+		return sqlmock.NewRows(mandateColumnNames).AddRow(order.ID, sender, order.ReceiverAccountID, amount, "USD",
+			"@weekly", Paused, time.Now(), time.Now(), time.Now())
+	}
+
+	mock.ExpectExec("INSERT INTO mandates").WillReturnError(used)
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WithArgs(order.ID).WillReturnRows(existing(order.SenderAccountID, "10"))
+	a.NoError(svc.CreateMandate(context.Background(), order), "repeated creation is OK")
+
+	mock.ExpectExec("INSERT INTO mandates").WillReturnError(used)
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WithArgs(order.ID).WillReturnRows(existing(order.SenderAccountID, "20"))
+	a.Equal(ErrMandateIDUsed, svc.CreateMandate(context.Background(), order), "id of other order")
+
+	foreign := uuid.New()
+	tm.foreign = map[uuid.UUID]bool{foreign: true}
+	mock.ExpectExec("INSERT INTO mandates").WillReturnError(used)
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WithArgs(order.ID).WillReturnRows(existing(foreign, "10"))
+	a.Equal(ErrMandateNotExists, svc.CreateMandate(context.Background(), order), "id of other customer mandate")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CreateMandate_SenderNotExists(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	mock.ExpectExec("INSERT INTO mandates").WillReturnError(
+		&pq.Error{Code: dberrors.ForeignKeyViolation, Constraint: "mandates_sender_account_id_fkey"})
+
+	a.Equal(transfers.ErrSenderNotExists, svc.CreateMandate(context.Background(), newValidOrder()))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CreateMandate_ForeignSender(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	order := newValidOrder()
+	tm.foreign = map[uuid.UUID]bool{order.SenderAccountID: true}
+
+	a.Equal(transfers.ErrSenderNotExists, svc.CreateMandate(context.Background(), order))
+	a.NoError(mock.ExpectationsWereMet(), "mandate is not created")
+}
+
+func TestService_PauseMandate_ForeignSender(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	id, sender := uuid.New(), uuid.New()
+	tm.foreign = map[uuid.UUID]bool{sender: true}
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, sender, uuid.New(), "10", "USD", "@weekly", Active, time.Now(), time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+
+	a.Equal(ErrMandateNotExists, svc.PauseMandate(context.Background(), id))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CancelMandate_AlreadyCancelled(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	id := uuid.New()
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, uuid.New(), uuid.New(), "10", "USD", "@weekly", Cancelled, time.Now(), time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+
+	a.NoError(svc.CancelMandate(context.Background(), id), "cancel is idempotent")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_PauseMandate_Cancelled(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	id := uuid.New()
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, uuid.New(), uuid.New(), "10", "USD", "@weekly", Cancelled, time.Now(), time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+
+	a.Equal(ErrMandateCancelled, svc.PauseMandate(context.Background(), id))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_PauseMandate_NotExists(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(sqlmock.NewRows(mandateColumnNames))
+
+	a.Equal(ErrMandateNotExists, svc.PauseMandate(context.Background(), uuid.New()))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_ExecuteDueOccurrences(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	id := uuid.New()
+	scheduledAt := time.Date(2020, 10, 4, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, uuid.New(), uuid.New(), "10", "USD", "@weekly", Active, scheduledAt, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+	mock.ExpectExec("INSERT INTO mandate_occurrences").
+		WithArgs(id, scheduledAt, OccurrenceTransferID(id, scheduledAt), Succeeded, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mandates").
+		WithArgs(scheduledAt.AddDate(0, 0, 7), id, scheduledAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	executed, err := svc.ExecuteDueOccurrences(context.Background(), scheduledAt.Add(time.Hour))
+	a.NoError(err)
+	a.Equal(1, executed)
+	a.Equal(1, len(tm.orders))
+	a.Equal(OccurrenceTransferID(id, scheduledAt), tm.orders[0].ID, "deterministic idempotency id")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_ExecuteDueOccurrences_BusinessFailure(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	tm.err = transfers.ErrInsufficientFunds
+
+	id := uuid.New()
+	scheduledAt := time.Date(2020, 10, 4, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, uuid.New(), uuid.New(), "10", "USD", "@weekly", Active, scheduledAt, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+	mock.ExpectExec("INSERT INTO mandate_occurrences").
+		WithArgs(id, scheduledAt, OccurrenceTransferID(id, scheduledAt), Failed, "insufficient_funds").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mandates").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	executed, err := svc.ExecuteDueOccurrences(context.Background(), scheduledAt)
+	a.NoError(err)
+	a.Equal(1, executed)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_ExecuteDueOccurrences_AppliedTransfer(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	// transfer is committed, but occurrence isn't recorded before crash, funds are spent since then
+	id := uuid.New()
+	scheduledAt := time.Date(2020, 10, 4, 0, 0, 0, 0, time.UTC)
+	tm.applied = map[uuid.UUID]bool{OccurrenceTransferID(id, scheduledAt): true}
+	tm.err = transfers.ErrInsufficientFunds
+
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(id, uuid.New(), uuid.New(), "10", "USD", "@weekly", Active, scheduledAt, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+	mock.ExpectExec("INSERT INTO mandate_occurrences").
+		WithArgs(id, scheduledAt, OccurrenceTransferID(id, scheduledAt), Succeeded, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mandates").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	executed, err := svc.ExecuteDueOccurrences(context.Background(), scheduledAt)
+	a.NoError(err)
+	a.Equal(1, executed)
+	a.Empty(tm.orders, "applied transfer isn't retried")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_ExecuteDueOccurrences_InfrastructureFailure(t *testing.T) {
+	a := assert.New(t)
+	svc, tm, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	failing, next := uuid.New(), uuid.New()
+	sender := uuid.New()
+	scheduledAt := time.Date(2020, 10, 4, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(mandateColumnNames).
+		AddRow(failing, sender, uuid.New(), "10", "USD", "@weekly", Active, scheduledAt, time.Now(), time.Now()).
+		AddRow(next, uuid.New(), uuid.New(), "10", "USD", "@weekly", Active, scheduledAt, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM mandates").WillReturnRows(rows)
+	// occurrence of failing mandate is neither recorded nor advanced, next one is executed anyway
+	mock.ExpectExec("INSERT INTO mandate_occurrences").
+		WithArgs(next, scheduledAt, OccurrenceTransferID(next, scheduledAt), Succeeded, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mandates").WithArgs(scheduledAt.AddDate(0, 0, 7), next, scheduledAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tm.failing = map[uuid.UUID]error{sender: errors.New("connection refused")}
+
+	executed, err := svc.ExecuteDueOccurrences(context.Background(), scheduledAt)
+	a.EqualError(err, "mandate "+failing.String()+": connection refused")
+	a.Equal(1, executed)
+	a.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,33 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a fact that happened inside the system written to outbox table
+// in the same transaction as the fact itself.
+type Event struct {
+	ID         int64           `json:"id"` // sequential, assigned by storage
+	Type       string          `json:"type"`
+	AccountIDs []uuid.UUID     `json:"account_ids"` // accounts affected by the event
+	Payload    json.RawMessage `json:"payload"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// NewEvent serializes payload of the event.
+func NewEvent(eventType string, payload interface{}, accountIDs ...uuid.UUID) (Event, error) {
+	data, err := json.Marshal(payload)
+
+	return Event{Type: eventType, AccountIDs: accountIDs, Payload: data}, err
+}
+
+// EventPublisher delivers events to downstream consumers. Delivery is at-least-once,
+// events may be published again if relay fails after publishing, so consumers
+// should deduplicate them by ID.
+type EventPublisher interface {
+	Publish(ctx context.Context, events []Event) error
+}
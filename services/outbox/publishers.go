@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// WriterPublisher writes events as JSON lines, e.g. to stdout.
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+func (p *WriterPublisher) Publish(_ context.Context, events []Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	encoder := json.NewEncoder(p.w)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MemoryPublisher keeps published events in process, useful for tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, events []Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, events...)
+
+	return nil
+}
+
+// Events returns copy of all published events.
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Event(nil), p.events...)
+}
+
+// HTTPPublisher posts batch of events as JSON array to the webhook url,
+// any response except 2xx is treated as failure.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPPublisher(url string, client *http.Client) HTTPPublisher {
+	return HTTPPublisher{url: url, client: client}
+}
+
+func (p HTTPPublisher) Publish(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// MultiPublisher publishes events to each publisher in order, stops on first failure,
+// so publishers must tolerate repeated events.
+type MultiPublisher []EventPublisher
+
+func NewMultiPublisher(publishers ...EventPublisher) MultiPublisher {
+	return publishers
+}
+
+func (p MultiPublisher) Publish(ctx context.Context, events []Event) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, events); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
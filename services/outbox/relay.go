@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// sweepInterval is how often published events out of retention are deleted.
+const sweepInterval = time.Hour
+
+// Relay periodically moves events from outbox table to publisher
+// and deletes published ones after retention (0 keeps them forever).
+type Relay struct {
+	repo      Repository
+	publisher EventPublisher
+	interval  time.Duration
+	batchSize uint
+	retention time.Duration
+	logger    log.Logger
+}
+
+func NewRelay(
+	repo Repository, publisher EventPublisher, interval time.Duration, batchSize uint, retention time.Duration,
+	logger log.Logger) Relay {
+	return Relay{
+		repo: repo, publisher: publisher, interval: interval, batchSize: batchSize, retention: retention, logger: logger,
+	}
+}
+
+// RelayOnce publishes batches until outbox is drained, returns count of published events.
+func (r Relay) RelayOnce(ctx context.Context) (int, error) {
+	var total int
+	for {
+		count, err := r.repo.PublishBatch(ctx, r.batchSize, func(events []Event) error {
+			return r.publisher.Publish(ctx, events)
+		})
+		total += count
+		if err != nil || count < int(r.batchSize) {
+			return total, err
+		}
+	}
+}
+
+// Sweep deletes batches of published events out of retention, returns count of deleted events.
+func (r Relay) Sweep(ctx context.Context) (int, error) {
+	var total int
+	for {
+		count, err := r.repo.DeletePublished(ctx, r.retention, r.batchSize)
+		total += count
+		if err != nil || count < int(r.batchSize) {
+			return total, err
+		}
+	}
+}
+
+// Run blocks until ctx is done.
+func (r Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	var swept time.Time
+	for {
+		count, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			_ = level.Error(r.logger).Log("msg", "outbox relay failed", "err", err.Error())
+		}
+		if count > 0 {
+			_ = level.Debug(r.logger).Log("msg", "outbox events published", "count", count)
+		}
+		if r.retention > 0 && time.Since(swept) >= sweepInterval {
+			swept = time.Now()
+			count, err = r.Sweep(ctx)
+			if err != nil && ctx.Err() == nil {
+				_ = level.Error(r.logger).Log("msg", "outbox sweep failed", "err", err.Error())
+			}
+			if count > 0 {
+				_ = level.Debug(r.logger).Log("msg", "outbox events deleted", "count", count)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
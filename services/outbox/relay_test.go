@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+var eventColumns = []string{"id", "type", "account_ids", "payload", "created_at"}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New()
+	a.NoError(err, "mock initialized")
+	defer db.Close()
+
+	accountID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM outbox").WithArgs(10).WillReturnRows(
+		sqlmock.NewRows(eventColumns).
+			AddRow(1, "TransferCreated", "{"+accountID.String()+"}", []byte(`{"id":1}`), time.Now()).
+			AddRow(2, "BalanceChanged", "{}", []byte(`{"id":2}`), time.Now()))
+	mock.ExpectExec("UPDATE outbox SET published_at").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	publisher := NewMemoryPublisher()
+	relay := NewRelay(NewRepository(db), publisher, time.Second, 10, 0, testLogger)
+	count, err := relay.RelayOnce(context.Background())
+	a.NoError(err)
+	a.Equal(2, count)
+	events := publisher.Events()
+	a.Equal(2, len(events))
+	a.Equal([]uuid.UUID{accountID}, events[0].AccountIDs)
+	a.JSONEq(`{"id":2}`, string(events[1].Payload))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, []Event) error {
+	return errors.New("unavailable")
+}
+
+func TestRelay_RelayOnce_PublishFailed(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New()
+	a.NoError(err, "mock initialized")
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM outbox").WillReturnRows(
+		sqlmock.NewRows(eventColumns).AddRow(1, "TransferCreated", "{}", []byte(`{}`), time.Now()))
+	mock.ExpectRollback()
+
+	relay := NewRelay(NewRepository(db), failingPublisher{}, time.Second, 10, 0, testLogger)
+	count, err := relay.RelayOnce(context.Background())
+	a.EqualError(err, "unavailable")
+	a.Equal(0, count, "events stay unpublished")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestRelay_Sweep(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New()
+	a.NoError(err, "mock initialized")
+	defer db.Close()
+
+	retention := 48 * time.Hour
+	mock.ExpectExec("DELETE FROM outbox (.+) published_at IS NOT NULL").WithArgs(retention.Seconds(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM outbox").WithArgs(retention.Seconds(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	relay := NewRelay(NewRepository(db), NewMemoryPublisher(), time.Second, 2, retention, testLogger)
+	count, err := relay.Sweep(context.Background())
+	a.NoError(err)
+	a.Equal(3, count, "batches are deleted until the last incomplete one")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	a := assert.New(t)
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		a.NoError(json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	e, err := NewEvent("TransferCreated", map[string]string{"id": "1"})
+	a.NoError(err)
+	err = NewHTTPPublisher(server.URL, server.Client()).Publish(context.Background(), []Event{e})
+	a.NoError(err)
+	a.Equal(1, len(received))
+	a.Equal("TransferCreated", received[0].Type)
+}
+
+func TestHTTPPublisher_Failure(t *testing.T) {
+	a := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := NewHTTPPublisher(server.URL, server.Client()).Publish(context.Background(), []Event{{}})
+	a.EqualError(err, "webhook responded with status 503")
+}
+
+func TestWriterPublisher(t *testing.T) {
+	a := assert.New(t)
+	var buf bytes.Buffer
+	err := NewWriterPublisher(&buf).Publish(context.Background(), []Event{{ID: 1, Type: "A"}, {ID: 2, Type: "B"}})
+	a.NoError(err)
+	a.Equal(2, bytes.Count(buf.Bytes(), []byte("\n")), "json lines")
+}
@@ -0,0 +1,206 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/risentveber/wallet-api/services/tracing"
+)
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func uuidsToStrings(ids []uuid.UUID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.String())
+	}
+
+	return res
+}
+
+func stringsToUUIDs(ids []string) ([]uuid.UUID, error) {
+	res := make([]uuid.UUID, 0, len(ids))
+	for _, s := range ids {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+
+	return res, nil
+}
+
+// Write adds event to outbox, it's supposed to be called inside business transaction.
+func Write(ctx context.Context, tx Execer, e Event) (err error) {
+	const query = `
+INSERT INTO outbox(type, account_ids, payload)
+ VALUES ($1, $2, $3)`
+	ctx, span := tracing.StartDB(ctx, tracing.PostgreSQL, "INSERT outbox", query)
+	defer func() { tracing.End(span, err) }()
+	_, err = tx.ExecContext(ctx, query, e.Type, pq.Array(uuidsToStrings(e.AccountIDs)), []byte(e.Payload))
+
+	return err
+}
+
+// WriteSQLite is Write for sqlite schema, where account ids are kept as JSON array.
+func WriteSQLite(ctx context.Context, tx Execer, e Event) (err error) {
+	const query = `
+INSERT INTO outbox(type, account_ids, payload)
+ VALUES ($1, $2, $3)`
+	ctx, span := tracing.StartDB(ctx, tracing.PostgreSQL, "INSERT outbox", query)
+	defer func() { tracing.End(span, err) }()
+	accountIDs, err := json.Marshal(uuidsToStrings(e.AccountIDs))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, e.Type, string(accountIDs), string(e.Payload))
+
+	return err
+}
+
+type Repository interface {
+	// locks batch of unpublished events in order and marks them published
+	// if publish succeeds, returns count of published events
+	PublishBatch(ctx context.Context, limit uint, publish func([]Event) error) (int, error)
+	// committed events with id greater than afterID in order regardless of publishing
+	GetEventsAfter(ctx context.Context, afterID int64, limit uint) ([]Event, error)
+	// the same as GetEventsAfter but only ones affecting the account
+	GetAccountEventsAfter(ctx context.Context, accountID uuid.UUID, afterID int64, limit uint) ([]Event, error)
+	GetLastEventID(ctx context.Context) (int64, error)
+	// deletes batch of published events created more than retention ago except the last event,
+	// so GetLastEventID still gives it, returns count of deleted events
+	DeletePublished(ctx context.Context, retention time.Duration, limit uint) (int, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func scanEvents(rows *sql.Rows, limit uint) ([]Event, error) {
+	defer rows.Close()
+	events := make([]Event, 0, limit)
+	for rows.Next() {
+		var e Event
+		var accountIDs []string
+		var payload []byte
+		err := rows.Scan(&e.ID, &e.Type, pq.Array(&accountIDs), &payload, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		if e.AccountIDs, err = stringsToUUIDs(accountIDs); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (r repository) PublishBatch(ctx context.Context, limit uint, publish func([]Event) error) (count int, err error) {
+	var tx *sql.Tx
+	var rows *sql.Rows
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// SKIP LOCKED allows several relays to work concurrently
+	rows, err = tx.QueryContext(ctx, `
+SELECT id, type, account_ids, payload, created_at FROM outbox
+WHERE published_at IS NULL ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return
+	}
+	events, err := scanEvents(rows, limit)
+	if err != nil || len(events) == 0 {
+		return
+	}
+	if err = publish(events); err != nil {
+		return
+	}
+	ids := make([]int64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE outbox SET published_at = now() WHERE id = ANY($1)`, pq.Array(ids))
+
+	return len(events), err
+}
+
+func (r repository) GetEventsAfter(ctx context.Context, afterID int64, limit uint) ([]Event, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, type, account_ids, payload, created_at FROM outbox
+WHERE id > $1 ORDER BY id
+LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEvents(rows, limit)
+}
+
+func (r repository) GetAccountEventsAfter(
+	ctx context.Context, accountID uuid.UUID, afterID int64, limit uint) ([]Event, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, type, account_ids, payload, created_at FROM outbox
+WHERE id > $1 AND account_ids @> ARRAY[$2::uuid] ORDER BY id
+LIMIT $3`, afterID, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEvents(rows, limit)
+}
+
+func (r repository) GetLastEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `SELECT coalesce(max(id), 0) FROM outbox`).Scan(&id)
+
+	return id, err
+}
+
+func (r repository) DeletePublished(ctx context.Context, retention time.Duration, limit uint) (int, error) {
+	res, err := r.db.ExecContext(ctx, `
+DELETE FROM outbox
+WHERE id IN (
+	SELECT id FROM outbox
+	WHERE published_at IS NOT NULL AND created_at < now() - $1::float8 * interval '1 second'
+	AND id < (SELECT max(id) FROM outbox)
+	ORDER BY id
+	LIMIT $2
+)`, retention.Seconds(), limit)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+
+	return int(count), err
+}
@@ -0,0 +1,40 @@
+// Package pgnotify wakes workers up by Postgres notifications (LISTEN/NOTIFY),
+// e.g. activity follower on outbox inserts and currencies cache on their changes.
+package pgnotify
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/lib/pq"
+)
+
+// Listen subscribes to Postgres notifications of the channel, returned channel
+// gets a value on each notification and on reconnect (some notifications may be lost then).
+// Values are coalesced, so a slow reader gets a single one for notifications made meanwhile.
+func Listen(dsn, channel string, logger log.Logger) (<-chan struct{}, func() error, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			_ = level.Warn(logger).Log("msg", "postgres listener", "channel", channel, "err", err.Error())
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		_ = listener.Close()
+
+		return nil, nil, err
+	}
+	wakeup := make(chan struct{}, 1)
+	go func() {
+		defer close(wakeup)
+		for range listener.Notify {
+			// nil notification means reconnect, it wakes reader up as well
+			select {
+			case wakeup <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return wakeup, listener.Close, nil
+}
@@ -0,0 +1,169 @@
+// Package ratelimit limits calls of endpoints by token buckets: bucket of each key (e.g. API client)
+// holds up to Burst tokens refilled by Rate per second, each call takes one token or is rejected.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit of bucket, it's disabled if Rate isn't positive.
+type Limit struct {
+	// tokens per second
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// LimitedError rejects call over limit, it's encoded as 429 with Retry-After header.
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LimitedError) Error() string {
+	return "rate_limited"
+}
+
+func (e LimitedError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+// Headers give Retry-After in whole seconds, rounded up, so retry isn't rejected again.
+func (e LimitedError) Headers() http.Header {
+	h := http.Header{}
+	h.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(e.RetryAfter.Seconds())), 10))
+
+	return h
+}
+
+// Limiter keeps buckets by keys.
+type Limiter interface {
+	// takes token from bucket of key, returns how long to wait for next token if there is none
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// bucket of MemoryLimiter.
+type bucket struct {
+	limit     Limit
+	tokens    float64
+	updatedAt time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.updatedAt = now
+	}
+}
+
+// wait gives time till bucket with tokens has one.
+func wait(tokens float64, limit Limit) time.Duration {
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
+
+// MemoryLimiter limits calls to single replica only.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// sweepInterval of MemoryLimiter dropping buckets that are full again, they are the same as absent ones.
+const sweepInterval = time.Minute
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (l *MemoryLimiter) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.swept) > sweepInterval {
+		for k, b := range l.buckets {
+			b.refill(now)
+			if b.tokens >= float64(b.limit.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false, wait(b.tokens, limit), nil
+	}
+	b.tokens--
+
+	return true, 0, nil
+}
+
+type postgresLimiter struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	swept time.Time
+	now   func() time.Time
+}
+
+// idleTTL after which postgresLimiter deletes buckets, ones of limits refilled within it are full again
+// and the same as absent ones.
+const idleTTL = time.Hour
+
+// NewPostgresLimiter shares buckets by all replicas.
+func NewPostgresLimiter(db *sql.DB) Limiter {
+	return &postgresLimiter{db: db, now: time.Now}
+}
+
+// sweep deletes idle buckets once per sweepInterval of each replica.
+func (l *postgresLimiter) sweep(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+	if now.Sub(l.swept) <= sweepInterval {
+		l.mu.Unlock()
+		return nil
+	}
+	l.swept = now
+	l.mu.Unlock()
+	_, err := l.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1::float8 * interval '1 second'`,
+		idleTTL.Seconds())
+
+	return err
+}
+
+// refilled is tokens of bucket b at now() (time of db, so clocks of replicas don't matter), $2 is burst, $3 is rate.
+const refilled = `LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8)`
+
+func (l *postgresLimiter) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if err := l.sweep(ctx); err != nil {
+		return false, 0, err
+	}
+	// single statement takes token atomically, allowed keeps whether it's taken as the row is all it returns
+	var ok bool
+	var tokens float64
+	err := l.db.QueryRowContext(ctx, `
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+ VALUES ($1, $2::float8 - 1, true, now())
+ON CONFLICT (key) DO UPDATE SET
+ tokens = `+refilled+` - CASE WHEN `+refilled+` >= 1 THEN 1 ELSE 0 END,
+ allowed = `+refilled+` >= 1,
+ updated_at = now()
+RETURNING allowed, tokens`, key, float64(limit.Burst), limit.Rate).Scan(&ok, &tokens)
+	if err != nil || ok {
+		return ok, 0, err
+	}
+
+	return false, wait(tokens, limit), nil
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	l := NewMemoryLimiter()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := l.Take(ctx, "client:a", limit)
+		a.NoError(err)
+		a.True(ok, "burst is allowed at once")
+	}
+	ok, retryAfter, err := l.Take(ctx, "client:a", limit)
+	a.NoError(err)
+	a.False(ok)
+	a.Equal(500*time.Millisecond, retryAfter, "token is refilled each 1/rate")
+	ok, _, _ = l.Take(ctx, "client:b", limit)
+	a.True(ok, "buckets of keys are independent")
+
+	now = now.Add(250 * time.Millisecond)
+	ok, retryAfter, _ = l.Take(ctx, "client:a", limit)
+	a.False(ok)
+	a.Equal(250*time.Millisecond, retryAfter, "rejected call doesn't take partial token")
+	now = now.Add(250 * time.Millisecond)
+	ok, _, _ = l.Take(ctx, "client:a", limit)
+	a.True(ok)
+
+	now = now.Add(time.Hour)
+	ok, _, _ = l.Take(ctx, "client:c", limit)
+	a.True(ok)
+	a.Len(l.buckets, 1, "full buckets are swept")
+	for i := 0; i < 3; i++ {
+		ok, _, _ = l.Take(ctx, "client:a", limit)
+		a.True(ok, "bucket is refilled up to burst")
+	}
+}
+
+func TestPostgresLimiter(t *testing.T) {
+	a := assert.New(t)
+	db, mock, err := sqlmock.New()
+	a.NoError(err, "mock initialized")
+	defer db.Close()
+	l := NewPostgresLimiter(db).(*postgresLimiter)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	limit := Limit{Rate: 0.5, Burst: 10}
+
+	mock.ExpectExec(`DELETE FROM rate_limit_buckets WHERE updated_at < now\(\) - \$1::float8 \* interval '1 second'`).
+		WithArgs(3600.0).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`INSERT INTO rate_limit_buckets AS b .+ ON CONFLICT \(key\) DO UPDATE SET .+ RETURNING allowed, tokens`).
+		WithArgs("sender:a", 10.0, 0.5).
+		WillReturnRows(sqlmock.NewRows([]string{"allowed", "tokens"}).AddRow(true, 9))
+	ok, retryAfter, err := l.Take(context.Background(), "sender:a", limit)
+	a.NoError(err)
+	a.True(ok)
+	a.Zero(retryAfter)
+
+	mock.ExpectQuery(`INSERT INTO rate_limit_buckets`).
+		WillReturnRows(sqlmock.NewRows([]string{"allowed", "tokens"}).AddRow(false, 0.25))
+	ok, retryAfter, err = l.Take(context.Background(), "sender:a", limit)
+	a.NoError(err)
+	a.False(ok)
+	a.Equal(1500*time.Millisecond, retryAfter, "idle buckets aren't swept again within interval")
+
+	now = now.Add(2 * time.Minute)
+	mock.ExpectExec(`DELETE FROM rate_limit_buckets`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO rate_limit_buckets`).
+		WillReturnRows(sqlmock.NewRows([]string{"allowed", "tokens"}).AddRow(true, 0.5))
+	ok, _, err = l.Take(context.Background(), "sender:a", limit)
+	a.NoError(err)
+	a.True(ok)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestLimitedError(t *testing.T) {
+	a := assert.New(t)
+	err := LimitedError{RetryAfter: 1500 * time.Millisecond}
+	a.Equal("rate_limited", err.Error())
+	a.Equal(429, err.StatusCode())
+	a.Equal("2", err.Headers().Get("Retry-After"), "rounded up")
+}
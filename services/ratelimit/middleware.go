@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+// KeyFunc gives key of bucket call is limited by, false if call isn't limited.
+type KeyFunc func(ctx context.Context, request interface{}) (string, bool)
+
+// Rule limits calls of endpoint by buckets of keys.
+type Rule struct {
+	Endpoint string
+	Limit    Limit
+	Key      KeyFunc
+}
+
+// Middleware gives endpoint middleware by endpoint name (see Endpoints.Wrap of services) rejecting calls
+// by LimitedError once any bucket of its rules is empty, rules with disabled limits are skipped.
+// Rejected call still takes tokens of buckets checked before, so rules should be ordered from broadest.
+func Middleware(l Limiter, rules ...Rule) func(name string) endpoint.Middleware {
+	return func(name string) endpoint.Middleware {
+		var applied []Rule
+		for _, r := range rules {
+			if r.Endpoint == name && r.Limit.Enabled() {
+				applied = append(applied, r)
+			}
+		}
+
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			if len(applied) == 0 {
+				return next
+			}
+
+			return func(ctx context.Context, request interface{}) (interface{}, error) {
+				for _, r := range applied {
+					key, ok := r.Key(ctx, request)
+					if !ok {
+						continue
+					}
+					ok, retryAfter, err := l.Take(ctx, key, r.Limit)
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						return nil, LimitedError{RetryAfter: retryAfter}
+					}
+				}
+
+				return next(ctx, request)
+			}
+		}
+	}
+}
+
+// ByClient keys calls by API client of principal, internal calls aren't limited.
+func ByClient(ctx context.Context, _ interface{}) (string, bool) {
+	p, ok := auth.FromContext(ctx)
+
+	return "client:" + p.ClientID, ok
+}
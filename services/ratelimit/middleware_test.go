@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+type limiterMock struct {
+	taken []string
+	empty map[string]bool
+	err   error
+}
+
+func (m *limiterMock) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	m.taken = append(m.taken, key)
+	return !m.empty[key], time.Second, m.err
+}
+
+func nop(context.Context, interface{}) (interface{}, error) {
+	return "done", nil
+}
+
+func TestMiddleware(t *testing.T) {
+	a := assert.New(t)
+	limiter := &limiterMock{empty: map[string]bool{}}
+	byRequest := func(_ context.Context, request interface{}) (string, bool) {
+		key, ok := request.(string)
+		return key, ok
+	}
+	mw := Middleware(limiter,
+		Rule{Endpoint: "CreateTransfer", Limit: Limit{Rate: 1, Burst: 1}, Key: ByClient},
+		Rule{Endpoint: "CreateTransfer", Limit: Limit{Rate: 1, Burst: 1}, Key: byRequest},
+		Rule{Endpoint: "CreateTransfer", Limit: Limit{Burst: 1}, Key: byRequest},
+	)
+	ctx := auth.NewContext(context.Background(), auth.Principal{ClientID: "a"})
+
+	response, err := mw("GetAccounts")(nop)(ctx, "sender:1")
+	a.NoError(err)
+	a.Equal("done", response)
+	a.Empty(limiter.taken, "other endpoints aren't limited")
+
+	create := mw("CreateTransfer")(nop)
+	response, err = create(ctx, "sender:1")
+	a.NoError(err)
+	a.Equal("done", response)
+	a.Equal([]string{"client:a", "sender:1"}, limiter.taken, "disabled limit is skipped")
+
+	limiter.taken = nil
+	_, err = create(context.Background(), 1)
+	a.NoError(err)
+	a.Empty(limiter.taken, "calls without keys aren't limited")
+
+	limiter.empty["client:a"] = true
+	_, err = create(ctx, "sender:1")
+	a.Equal(LimitedError{RetryAfter: time.Second}, err)
+	a.Equal([]string{"client:a"}, limiter.taken, "first empty bucket rejects call")
+
+	limiter.err = errors.New("db is down")
+	_, err = create(ctx, "sender:1")
+	a.Equal(limiter.err, err)
+}
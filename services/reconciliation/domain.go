@@ -0,0 +1,45 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+// Business logic level errors that provide enough information about what went wrong.
+var (
+	ErrReportNotExists = errors.New("reconciliation_report_not_exist")
+)
+
+// Report of single reconciliation run, it's stored even if there are no discrepancies.
+type Report struct {
+	ID         uuid.UUID `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// accounts whose balance differs from sum of their transfer parts
+	Discrepancies []transfers.BalanceMismatch `json:"discrepancies"`
+}
+
+// BalanceChecker recomputes balances of all accounts and totals of currencies, it's implemented by transfers.AdminService.
+type BalanceChecker interface {
+	CheckBalances(ctx context.Context) ([]transfers.BalanceMismatch, error)
+	CheckConservation(ctx context.Context) ([]transfers.CurrencyTotals, error)
+}
+
+// Reconciliation of account balances with transfers, reports are accessible by auth.ScopeReconciliationRead.
+type Service interface {
+	// recomputes balances of all accounts and stores report
+	Reconcile(ctx context.Context) (Report, error)
+	// reconciles unless other replica is reconciling or last report started within half of interval before now,
+	// so workers of several replicas don't duplicate each other, returns false if reconciliation is skipped
+	ReconcileDue(ctx context.Context, now time.Time, interval time.Duration) (Report, bool, error)
+	// latest reports first
+	GetReports(ctx context.Context) ([]Report, error)
+	GetReport(ctx context.Context, id uuid.UUID) (Report, error)
+	// gives totals of each currency, money isn't conserved in ones with Conserved false
+	CheckConservation(ctx context.Context) ([]transfers.CurrencyTotals, error)
+}
@@ -0,0 +1,107 @@
+package reconciliation
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+type GetReportsRequest struct{}
+
+type GetReportsResponse struct {
+	Reports []Report
+	Err     error
+}
+
+func (r GetReportsResponse) Failed() error { return r.Err }
+
+func MakeGetReportsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(GetReportsRequest)
+		reports, err := s.GetReports(ctx)
+
+		return GetReportsResponse{Reports: reports, Err: err}, nil
+	}
+}
+
+type GetReportRequest struct {
+	ReportID uuid.UUID
+}
+
+type GetReportResponse struct {
+	Report *Report
+	Err    error
+}
+
+func (r GetReportResponse) Failed() error { return r.Err }
+
+func MakeGetReportEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetReportRequest)
+		report, err := s.GetReport(ctx, req.ReportID)
+		if err != nil {
+			return GetReportResponse{Err: err}, nil
+		}
+
+		return GetReportResponse{Report: &report}, nil
+	}
+}
+
+type CheckConservationRequest struct{}
+
+type CheckConservationResponse struct {
+	Totals []transfers.CurrencyTotals
+	Err    error
+}
+
+func (r CheckConservationResponse) Failed() error { return r.Err }
+
+func MakeCheckConservationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(CheckConservationRequest)
+		totals, err := s.CheckConservation(ctx)
+
+		return CheckConservationResponse{Totals: totals, Err: err}, nil
+	}
+}
+
+func NewEndpoints(s Service) Endpoints {
+	return Endpoints{
+		GetReports:        MakeGetReportsEndpoint(s),
+		GetReport:         MakeGetReportEndpoint(s),
+		CheckConservation: MakeCheckConservationEndpoint(s),
+	}
+}
+
+type Endpoints struct {
+	GetReports        endpoint.Endpoint
+	GetReport         endpoint.Endpoint
+	CheckConservation endpoint.Endpoint
+}
+
+// EndpointScopes required by endpoints, see auth.ScopeMiddleware.
+var EndpointScopes = map[string]string{
+	"GetReports":        auth.ScopeReconciliationRead,
+	"GetReport":         auth.ScopeReconciliationRead,
+	"CheckConservation": auth.ScopeReconciliationRead,
+}
+
+// ReadEndpoints don't change state, so they aren't recorded by audit.Middleware.
+var ReadEndpoints = map[string]bool{
+	"GetReports":        true,
+	"GetReport":         true,
+	"CheckConservation": true,
+}
+
+// Wrap decorates each endpoint with middleware built for its name.
+func (e Endpoints) Wrap(mw func(name string) endpoint.Middleware) Endpoints {
+	e.GetReports = mw("GetReports")(e.GetReports)
+	e.GetReport = mw("GetReport")(e.GetReport)
+	e.CheckConservation = mw("CheckConservation")(e.CheckConservation)
+
+	return e
+}
@@ -0,0 +1,72 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+func NewHTTPHandler(endpoints Endpoints, logger log.Logger) http.Handler {
+	r := mux.NewRouter().StrictSlash(true)
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(transfers.ErrorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+	r.Handle("/reconciliation/reports/",
+		httptransport.NewServer(endpoints.GetReports,
+			DecodeGetReportsRequest, EncodeGetReportsResponse, options...)).
+		Methods("GET")
+	r.Handle("/reconciliation/reports/{report_id}/",
+		httptransport.NewServer(endpoints.GetReport,
+			DecodeGetReportRequest, EncodeGetReportResponse, options...)).
+		Methods("GET")
+	r.Handle("/reconciliation/conservation/",
+		httptransport.NewServer(endpoints.CheckConservation,
+			DecodeCheckConservationRequest, EncodeCheckConservationResponse, options...)).
+		Methods("GET")
+
+	return r
+}
+
+func DecodeGetReportsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return GetReportsRequest{}, nil
+}
+
+func DecodeGetReportRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := uuid.Parse(mux.Vars(r)["report_id"])
+
+	return GetReportRequest{ReportID: id}, err
+}
+
+func DecodeCheckConservationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return CheckConservationRequest{}, nil
+}
+
+func EncodeGetReportsResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetReportsResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Reports, response.Err))
+}
+
+func EncodeGetReportResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetReportResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Report, response.Err))
+}
+
+func EncodeCheckConservationResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(CheckConservationResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Totals, response.Err))
+}
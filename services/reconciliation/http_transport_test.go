@@ -0,0 +1,116 @@
+package reconciliation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+type svcMock struct{}
+
+var reportID = uuid.MustParse("ab363360-632b-4643-b93f-0486b764e98d")
+
+func (m svcMock) Reconcile(ctx context.Context) (Report, error) {
+	return Report{}, nil
+}
+
+func (m svcMock) ReconcileDue(ctx context.Context, now time.Time, interval time.Duration) (Report, bool, error) {
+	return Report{}, false, nil
+}
+
+func (m svcMock) GetReports(ctx context.Context) ([]Report, error) {
+	started := time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)
+	return []Report{{
+		ID: reportID, StartedAt: started, FinishedAt: started.Add(time.Second),
+		Discrepancies: []transfers.BalanceMismatch{{
+			AccountID: reportID, CurrencyCode: "USD", Balance: decimal.New(15, 0), Expected: decimal.New(10, 0),
+		}},
+	}}, nil
+}
+
+func (m svcMock) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	return Report{}, ErrReportNotExists
+}
+
+func (m svcMock) CheckConservation(ctx context.Context) ([]transfers.CurrencyTotals, error) {
+	return []transfers.CurrencyTotals{{
+		CurrencyCode: "USD", Balances: decimal.New(90, 0), Deposits: decimal.New(100, 0), Withdrawals: decimal.New(10, 0),
+		Conserved: true,
+	}}, nil
+}
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+func TestGetReports(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/reconciliation/reports/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{
+  "result": "OK",
+  "payload": [
+    {
+      "id": "ab363360-632b-4643-b93f-0486b764e98d",
+      "started_at": "2020-09-01T10:00:00Z",
+      "finished_at": "2020-09-01T10:00:01Z",
+      "discrepancies": [
+        {
+          "account_id": "ab363360-632b-4643-b93f-0486b764e98d",
+          "currency_code": "USD",
+          "balance": "15",
+          "expected": "10"
+        }
+      ]
+    }
+  ]
+}`, response.Body.String())
+}
+
+func TestGetReport_NotExists(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/reconciliation/reports/"+reportID.String()+"/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"ERROR","error":"reconciliation_report_not_exist"}`, response.Body.String())
+}
+
+func TestGetReports_ScopeRequired(t *testing.T) {
+	a := assert.New(t)
+	endpoints := NewEndpoints(svcMock{}).Wrap(auth.ScopeMiddleware(EndpointScopes))
+	handler := NewHTTPHandler(endpoints, testLogger)
+	req, _ := http.NewRequest("GET", "/reconciliation/reports/", nil)
+	req = req.WithContext(auth.NewContext(req.Context(), auth.Principal{Scopes: []string{auth.ScopeAccountsRead}}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusForbidden, response.Code)
+}
+
+func TestCheckConservation(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/reconciliation/conservation/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{
+  "result": "OK",
+  "payload": [
+    {"currency_code": "USD", "balances": "90", "deposits": "100", "withdrawals": "10", "conserved": true}
+  ]
+}`, response.Body.String())
+}
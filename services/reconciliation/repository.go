@@ -0,0 +1,111 @@
+package reconciliation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type Repository interface {
+	CreateReport(ctx context.Context, r Report) error
+	// latest reports first
+	GetReports(ctx context.Context, limit uint) ([]Report, error)
+	GetReport(ctx context.Context, id uuid.UUID) (Report, bool, error)
+	// runs f holding lock shared by replicas, returns false without running it if other replica holds the lock
+	TryLock(ctx context.Context, f func(ctx context.Context) error) (bool, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func (r repository) CreateReport(ctx context.Context, report Report) error {
+	discrepancies, err := json.Marshal(report.Discrepancies)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+INSERT INTO reconciliation_reports(id, started_at, finished_at, discrepancies_count, discrepancies)
+ VALUES ($1, $2, $3, $4, $5)`,
+		report.ID, report.StartedAt, report.FinishedAt, len(report.Discrepancies), discrepancies)
+
+	return err
+}
+
+const reportColumns = `id, started_at, finished_at, discrepancies`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanReport(s scanner) (Report, error) {
+	var r Report
+	var discrepancies []byte
+	if err := s.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &discrepancies); err != nil {
+		return r, err
+	}
+	err := json.Unmarshal(discrepancies, &r.Discrepancies)
+
+	return r, err
+}
+
+func (r repository) GetReports(ctx context.Context, limit uint) ([]Report, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+reportColumns+` FROM reconciliation_reports ORDER BY started_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := make([]Report, 0, limit)
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+func (r repository) GetReport(ctx context.Context, id uuid.UUID) (Report, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+reportColumns+` FROM reconciliation_reports WHERE id = $1`, id)
+	report, err := scanReport(row)
+	switch err {
+	case sql.ErrNoRows:
+		return report, false, nil
+	case nil:
+		return report, true, nil
+	default:
+		return report, false, err
+	}
+}
+
+// lockKey of advisory lock of reconciliation, "reconcil" in ASCII.
+const lockKey int64 = 0x7265636f6e63696c
+
+// TryLock holds transaction level advisory lock while f runs, so it's released on commit or on lost connection.
+func (r repository) TryLock(ctx context.Context, f func(ctx context.Context) error) (locked bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if !locked || err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	if err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, lockKey).Scan(&locked); err != nil || !locked {
+		return false, err
+	}
+
+	return true, f(ctx)
+}
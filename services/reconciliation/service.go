@@ -0,0 +1,76 @@
+package reconciliation
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+const reportsLimit = 100
+
+type service struct {
+	repo    Repository
+	checker BalanceChecker
+}
+
+func NewService(repo Repository, checker BalanceChecker) Service {
+	return service{repo: repo, checker: checker}
+}
+
+func (s service) Reconcile(ctx context.Context) (Report, error) {
+	report := Report{ID: uuid.New(), StartedAt: time.Now().UTC()}
+	discrepancies, err := s.checker.CheckBalances(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	report.FinishedAt = time.Now().UTC()
+	// stored and shown as empty array rather than null
+	report.Discrepancies = append([]transfers.BalanceMismatch{}, discrepancies...)
+
+	return report, s.repo.CreateReport(ctx, report)
+}
+
+func (s service) ReconcileDue(ctx context.Context, now time.Time, interval time.Duration) (Report, bool, error) {
+	var report Report
+	var reconciled bool
+	// check and insert of report are under the lock, so replicas ticking at the same time don't both reconcile
+	_, err := s.repo.TryLock(ctx, func(ctx context.Context) error {
+		last, err := s.repo.GetReports(ctx, 1)
+		if err != nil {
+			return err
+		}
+		// half of interval tolerates ticks of worker coming slightly earlier than interval after its own last run
+		if len(last) > 0 && now.Sub(last[0].StartedAt) < interval/2 {
+			return nil
+		}
+		report, err = s.Reconcile(ctx)
+		reconciled = err == nil
+
+		return err
+	})
+
+	return report, reconciled, err
+}
+
+func (s service) GetReports(ctx context.Context) ([]Report, error) {
+	return s.repo.GetReports(ctx, reportsLimit)
+}
+
+func (s service) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	report, ok, err := s.repo.GetReport(ctx, id)
+	if err != nil {
+		return Report{}, err
+	}
+	if !ok {
+		return Report{}, ErrReportNotExists
+	}
+
+	return report, nil
+}
+
+func (s service) CheckConservation(ctx context.Context) ([]transfers.CurrencyTotals, error) {
+	return s.checker.CheckConservation(ctx)
+}
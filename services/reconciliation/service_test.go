@@ -0,0 +1,181 @@
+package reconciliation
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+type checkerMock struct {
+	mismatches []transfers.BalanceMismatch
+	err        error
+	calls      int
+}
+
+func (m *checkerMock) CheckBalances(ctx context.Context) ([]transfers.BalanceMismatch, error) {
+	m.calls++
+	return m.mismatches, m.err
+}
+
+func (m *checkerMock) CheckConservation(ctx context.Context) ([]transfers.CurrencyTotals, error) {
+	return nil, m.err
+}
+
+func prepare() (Service, *checkerMock, sqlmock.Sqlmock, error, func()) {
+	db, mock, err := sqlmock.New()
+	checker := &checkerMock{}
+	service := NewService(NewRepository(db), checker)
+	return service, checker, mock, err, func() {
+		db.Close()
+	}
+}
+
+// jsonArg matches JSON argument regardless of formatting.
+type jsonArg string
+
+func (j jsonArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	var expected, actual interface{}
+
+	return json.Unmarshal([]byte(j), &expected) == nil && json.Unmarshal(b, &actual) == nil &&
+		assert.ObjectsAreEqual(expected, actual)
+}
+
+func TestService_Reconcile(t *testing.T) {
+	a := assert.New(t)
+	svc, checker, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	accountID := uuid.MustParse("3aa42e32-1117-4533-a1b2-86714e9f842e")
+	checker.mismatches = []transfers.BalanceMismatch{{
+		AccountID: accountID, CurrencyCode: "USD", Balance: decimal.New(15, 0), Expected: decimal.New(10, 0),
+	}}
+	mock.ExpectExec("INSERT INTO reconciliation_reports").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, jsonArg(
+			`[{"account_id":"3aa42e32-1117-4533-a1b2-86714e9f842e","currency_code":"USD","balance":"15","expected":"10"}]`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	report, err := svc.Reconcile(context.Background())
+	a.NoError(err)
+	a.Len(report.Discrepancies, 1)
+	a.False(report.FinishedAt.Before(report.StartedAt))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_Reconcile_NoDiscrepancies(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	mock.ExpectExec("INSERT INTO reconciliation_reports").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 0, jsonArg(`[]`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	report, err := svc.Reconcile(context.Background())
+	a.NoError(err)
+	a.NotNil(report.Discrepancies, "empty report has empty array")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_Reconcile_CheckFailed(t *testing.T) {
+	a := assert.New(t)
+	svc, checker, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	checker.err = errors.New("connection refused")
+	_, err = svc.Reconcile(context.Background())
+	a.EqualError(err, "connection refused")
+	a.NoError(mock.ExpectationsWereMet(), "report isn't stored")
+}
+
+var reportColumnNames = []string{"id", "started_at", "finished_at", "discrepancies"}
+
+func TestService_ReconcileDue(t *testing.T) {
+	a := assert.New(t)
+	svc, checker, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	now := time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)
+	lastRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(reportColumnNames).
+			AddRow(uuid.New(), now.Add(-20*time.Minute), now.Add(-19*time.Minute), []byte(`[]`))
+	}
+
+	lock := func(locked bool) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(\$1\)`).WithArgs(lockKey).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(locked))
+	}
+
+	lock(false)
+	mock.ExpectRollback()
+	_, reconciled, err := svc.ReconcileDue(context.Background(), now, time.Hour)
+	a.NoError(err)
+	a.False(reconciled, "other replica is reconciling")
+
+	lock(true)
+	mock.ExpectQuery("SELECT .+ FROM reconciliation_reports ORDER BY started_at DESC").
+		WithArgs(1).WillReturnRows(lastRows())
+	mock.ExpectCommit()
+	_, reconciled, err = svc.ReconcileDue(context.Background(), now, time.Hour)
+	a.NoError(err)
+	a.False(reconciled, "other replica reconciled recently")
+	a.Equal(0, checker.calls)
+
+	lock(true)
+	mock.ExpectQuery("SELECT .+ FROM reconciliation_reports ORDER BY started_at DESC").
+		WithArgs(1).WillReturnRows(lastRows())
+	mock.ExpectExec("INSERT INTO reconciliation_reports").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	_, reconciled, err = svc.ReconcileDue(context.Background(), now, 30*time.Minute)
+	a.NoError(err)
+	a.True(reconciled)
+	a.Equal(1, checker.calls)
+
+	checker.err = errors.New("connection refused")
+	lock(true)
+	mock.ExpectQuery("SELECT .+ FROM reconciliation_reports ORDER BY started_at DESC").
+		WithArgs(1).WillReturnRows(sqlmock.NewRows(reportColumnNames))
+	mock.ExpectRollback()
+	_, reconciled, err = svc.ReconcileDue(context.Background(), now, 30*time.Minute)
+	a.EqualError(err, "connection refused")
+	a.False(reconciled)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_GetReport(t *testing.T) {
+	a := assert.New(t)
+	svc, _, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	id := uuid.New()
+	mock.ExpectQuery("SELECT .+ FROM reconciliation_reports WHERE id").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(reportColumnNames).AddRow(id, time.Now(), time.Now(),
+			[]byte(`[{"account_id":"3aa42e32-1117-4533-a1b2-86714e9f842e","currency_code":"USD","balance":"15","expected":"10"}]`)))
+	report, err := svc.GetReport(context.Background(), id)
+	a.NoError(err)
+	a.Equal(id, report.ID)
+	a.Len(report.Discrepancies, 1)
+	a.Equal("5", report.Discrepancies[0].Balance.Sub(report.Discrepancies[0].Expected).String())
+
+	mock.ExpectQuery("SELECT .+ FROM reconciliation_reports WHERE id").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(reportColumnNames))
+	_, err = svc.GetReport(context.Background(), id)
+	a.Equal(ErrReportNotExists, err)
+}
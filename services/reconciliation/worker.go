@@ -0,0 +1,43 @@
+package reconciliation
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Worker periodically reconciles balances, discrepancies are logged as warning besides being reported.
+type Worker struct {
+	svc      Service
+	interval time.Duration
+	logger   log.Logger
+}
+
+func NewWorker(svc Service, interval time.Duration, logger log.Logger) Worker {
+	return Worker{svc: svc, interval: interval, logger: logger}
+}
+
+// Run blocks until ctx is done.
+func (w Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		report, reconciled, err := w.svc.ReconcileDue(ctx, time.Now(), w.interval)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			_ = level.Error(w.logger).Log("msg", "reconciliation failed", "err", err.Error())
+		case reconciled && len(report.Discrepancies) > 0:
+			_ = level.Warn(w.logger).Log("msg", "balance discrepancies found",
+				"report_id", report.ID, "count", len(report.Discrepancies))
+		case reconciled:
+			_ = level.Debug(w.logger).Log("msg", "balances reconciled", "report_id", report.ID)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
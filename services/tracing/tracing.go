@@ -0,0 +1,66 @@
+// Package tracing provides OpenTelemetry spans for endpoints and db statements,
+// spans are exported by globally registered tracer provider (no-op one if not registered).
+package tracing
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/risentveber/wallet-api"
+
+// Start gives child span of span in ctx (if any).
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Values of db.system attribute by db of repository.
+const (
+	PostgreSQL = "postgresql"
+	SQLite     = "sqlite"
+)
+
+// StartDB gives client span of db statement, system is PostgreSQL or SQLite.
+func StartDB(ctx context.Context, system, operation, statement string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.statement", statement),
+		))
+}
+
+// End records error (if any) and ends span, convenient to be deferred with named error result.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EndpointMiddleware gives endpoint middleware by endpoint name (see Endpoints.Wrap of services),
+// domain errors are taken from responses implementing endpoint.Failer.
+func EndpointMiddleware(service string) func(name string) endpoint.Middleware {
+	return func(name string) endpoint.Middleware {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+				ctx, span := Start(ctx, service+"."+name)
+				defer func() {
+					failure := err
+					if f, ok := response.(endpoint.Failer); ok && failure == nil {
+						failure = f.Failed()
+					}
+					End(span, failure)
+				}()
+
+				return next(ctx, request)
+			}
+		}
+	}
+}
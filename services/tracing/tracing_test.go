@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type response struct {
+	Err error
+}
+
+func (r response) Failed() error { return r.Err }
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return recorder
+}
+
+func TestEndpointMiddleware(t *testing.T) {
+	a := assert.New(t)
+	recorder := record(t)
+
+	// request of caller with W3C trace context
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	ep := EndpointMiddleware("transfers")("CreateTransfer")(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		_, span := StartDB(ctx, SQLite, "INSERT transfers", "INSERT INTO transfers")
+		End(span, nil)
+
+		return response{Err: errors.New("insufficient_funds")}, nil
+	})
+	_, err := ep(ctx, nil)
+	a.NoError(err)
+
+	spans := recorder.Ended()
+	a.Len(spans, 2)
+	db, endpoint := spans[0], spans[1]
+	a.Equal("transfers.CreateTransfer", endpoint.Name())
+	a.Equal("4bf92f3577b34da6a3ce929d0e0e4736", endpoint.SpanContext().TraceID().String(), "trace of caller is continued")
+	a.Equal("00f067aa0ba902b7", endpoint.Parent().SpanID().String())
+	a.Equal(codes.Error, endpoint.Status().Code)
+	a.Equal("insufficient_funds", endpoint.Status().Description, "domain error of response is recorded")
+	a.Equal(endpoint.SpanContext().SpanID(), db.Parent().SpanID())
+	a.Equal(codes.Unset, db.Status().Code)
+	a.Contains(db.Attributes(), attribute.String("db.system", "sqlite"), "system is given by repository")
+}
+
+func TestFileExporter(t *testing.T) {
+	a := assert.New(t)
+	var buf bytes.Buffer
+	exporter, err := NewFileExporter(context.Background(), &buf)
+	a.NoError(err)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := provider.Tracer("test").Start(context.Background(), "transfers.CreateTransfer")
+	span.End()
+	a.NoError(provider.Shutdown(context.Background()))
+
+	// each batch is line of OTLP JSON
+	var request struct {
+		ResourceSpans []struct {
+			InstrumentationLibrarySpans []struct {
+				Spans []struct {
+					TraceID string `json:"traceId"`
+					Name    string `json:"name"`
+				} `json:"spans"`
+			} `json:"instrumentationLibrarySpans"`
+		} `json:"resourceSpans"`
+	}
+	a.True(bytes.HasSuffix(buf.Bytes(), []byte("}\n")))
+	a.NoError(json.Unmarshal(buf.Bytes(), &request))
+	a.Len(request.ResourceSpans, 1)
+	a.Len(request.ResourceSpans[0].InstrumentationLibrarySpans, 1)
+	spans := request.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans
+	a.Len(spans, 1)
+	a.Equal("transfers.CreateTransfer", spans[0].Name)
+	a.NotEmpty(spans[0].TraceID)
+}
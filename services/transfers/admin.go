@@ -0,0 +1,170 @@
+package transfers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+func NewAdminService(repo Repository) AdminService {
+	return service{repo: repo, volume: discard.NewCounter()}
+}
+
+func (s service) CreateAccount(ctx context.Context, customerID *uuid.UUID, currencyCode string) (Account, error) {
+	currencyCode = strings.ToUpper(currencyCode)
+	currency, ok, err := s.repo.GetCurrency(ctx, currencyCode)
+	if err != nil {
+		return Account{}, err
+	}
+	if !ok {
+		return Account{}, ErrUnsupportedCurrency
+	}
+	if currency.Disabled {
+		return Account{}, ErrCurrencyDisabled
+	}
+
+	return s.repo.CreateAccount(ctx, Account{ID: uuid.New(), CustomerID: customerID, CurrencyCode: currencyCode})
+}
+
+// adjustmentFrom gives transfer and its only part adjustment is stored as.
+func adjustmentFrom(adj Adjustment, currencyCode string) (Transfer, TransferPart) {
+	t := Transfer{ID: adj.ID, Type: Deposit, Amount: adj.Amount.Abs(), CurrencyCode: currencyCode, Reason: &adj.Reason}
+	tp := TransferPart{TransferID: adj.ID, AccountID: adj.AccountID, Direction: Incoming}
+	if adj.Amount.IsNegative() {
+		t.Type, tp.Direction = Withdraw, Outgoing
+	}
+
+	return t, tp
+}
+
+func adjustmentEventsFrom(t Transfer, account Account, balance decimal.Decimal) ([]outbox.Event, error) {
+	created := TransferCreatedEvent{ID: t.ID, Type: t.Type, Amount: t.Amount, CurrencyCode: t.CurrencyCode}
+	diff := t.Amount
+	if t.Type == Withdraw {
+		created.SenderAccountID, diff = account.ID, diff.Neg()
+	} else {
+		created.ReceiverAccountID = account.ID
+	}
+	transferCreated, err := outbox.NewEvent(TransferCreated, created, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	balanceChanged, err := outbox.NewEvent(BalanceChanged, BalanceChangedEvent{
+		AccountID:    account.ID,
+		TransferID:   t.ID,
+		Diff:         diff,
+		Balance:      balance,
+		CurrencyCode: t.CurrencyCode,
+	}, account.ID)
+
+	return []outbox.Event{transferCreated, balanceChanged}, err
+}
+
+func (s service) Adjust(ctx context.Context, adj Adjustment) error {
+	if adj.ID == uuid.Nil {
+		return ErrEmptyTransferID
+	}
+	if adj.AccountID == uuid.Nil {
+		return ErrEmptyAccountID
+	}
+	if adj.Reason = strings.TrimSpace(adj.Reason); adj.Reason == "" {
+		return ErrEmptyReason
+	}
+	account, ok, err := s.repo.GetAccount(ctx, adj.AccountID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAccountNotExists
+	}
+	// disabled currency may be adjusted, e.g. to pay out remaining balances
+	currency, _, err := s.repo.GetCurrency(ctx, account.CurrencyCode)
+	if err != nil {
+		return err
+	}
+	adj.Amount = adj.Amount.Round(int32(currency.Precision))
+	if adj.Amount.IsZero() {
+		return ErrZeroAdjustment
+	}
+
+	err = s.repo.CreateAccountTransactionWithLock(ctx, adj.AccountID, func(account Account, a InnerTransferActions) error {
+		balance := account.Balance.Add(adj.Amount)
+		if balance.IsNegative() {
+			return ErrInsufficientFunds
+		}
+		t, tp := adjustmentFrom(adj, account.CurrencyCode)
+		if err := a.CreateTransfer(t); err != nil {
+			return err
+		}
+		if err := a.UpdateBalance(account.ID, balance); err != nil {
+			return err
+		}
+		if err := a.CreateTransferPart(tp); err != nil {
+			return err
+		}
+		events, err := adjustmentEventsFrom(t, account, balance)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err = a.CreateEvent(e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if s.repo.IsTransferIDUsedError(err) {
+		return nil
+	}
+	if s.repo.IsEntityNotFoundError(adj.AccountID, err) {
+		return ErrAccountNotExists
+	}
+
+	return err
+}
+
+func (s service) SetFrozen(ctx context.Context, accountID uuid.UUID, frozen bool) error {
+	ok, err := s.repo.SetAccountFrozen(ctx, accountID, frozen)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAccountNotExists
+	}
+
+	return nil
+}
+
+func (s service) GetTransfer(ctx context.Context, transferID uuid.UUID) (TransferDetails, error) {
+	t, ok, err := s.repo.GetTransfer(ctx, transferID)
+	if err != nil {
+		return TransferDetails{}, err
+	}
+	if !ok {
+		return TransferDetails{}, ErrTransferNotExists
+	}
+
+	return t, nil
+}
+
+func (s service) CheckBalances(ctx context.Context) ([]BalanceMismatch, error) {
+	return s.repo.GetBalanceMismatches(ctx)
+}
+
+func (s service) CheckConservation(ctx context.Context) ([]CurrencyTotals, error) {
+	totals, err := s.repo.GetCurrencyTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range totals {
+		totals[i].Conserved = t.Balances.Equal(t.Deposits.Sub(t.Withdrawals))
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,174 @@
+package transfers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareAdmin() (AdminService, sqlmock.Sqlmock, error, func()) {
+	db, mock, err := sqlmock.New()
+	return NewAdminService(NewRepository(db)), mock, err, func() {
+		db.Close()
+	}
+}
+
+var accountColumnNames = []string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}
+
+func expectAdjustedAccount(mock sqlmock.Sqlmock, accountID uuid.UUID, balance string) {
+	mock.ExpectQuery("SELECT (.+) FROM accounts WHERE id = \\$1").WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(accountID, nil, "USD", balance, true, time.Now(), time.Now()))
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"precision", "disabled"}).AddRow("2", false))
+}
+
+func TestAdminService_Adjust_Withdraw(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	adj := Adjustment{ID: uuid.New(), AccountID: uuid.New(), Amount: decimal.RequireFromString("-4.004"), Reason: " chargeback "}
+	expectAdjustedAccount(mock, adj.AccountID, "10")
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM accounts").WithArgs(adj.AccountID, adj.AccountID).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(adj.AccountID, nil, "USD", "10", true, time.Now(), time.Now()))
+	reason := "chargeback"
+	mock.ExpectExec("INSERT INTO transfers").
+		WithArgs(adj.ID, Withdraw, decimal.RequireFromString("4"), "USD", &reason).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(decimal.RequireFromString("6"), adj.AccountID).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO transfer_parts").WithArgs(adj.ID, adj.AccountID, nil, Outgoing).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(TransferCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(BalanceChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectCommit()
+
+	a.NoError(svc.Adjust(context.Background(), adj), "frozen account may be adjusted")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminService_Adjust_InsufficientFunds(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	adj := Adjustment{ID: uuid.New(), AccountID: uuid.New(), Amount: decimal.RequireFromString("-11"), Reason: "fee"}
+	expectAdjustedAccount(mock, adj.AccountID, "10")
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM accounts").
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(adj.AccountID, nil, "USD", "10", false, time.Now(), time.Now()))
+	mock.ExpectRollback()
+
+	a.Equal(ErrInsufficientFunds, svc.Adjust(context.Background(), adj))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminService_Adjust_Validate(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	adj := Adjustment{ID: uuid.New(), AccountID: uuid.New(), Amount: decimal.RequireFromString("0.001"), Reason: "fee"}
+
+	a.Equal(ErrEmptyReason, svc.Adjust(context.Background(), Adjustment{ID: adj.ID, AccountID: adj.AccountID, Reason: "  "}))
+	a.Equal(ErrEmptyAccountID, svc.Adjust(context.Background(), Adjustment{ID: adj.ID, Reason: "fee"}))
+	expectAdjustedAccount(mock, adj.AccountID, "10")
+	a.Equal(ErrZeroAdjustment, svc.Adjust(context.Background(), adj), "amount is rounded to precision")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminService_SetFrozen_NotExists(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	id := uuid.New()
+	mock.ExpectExec("UPDATE accounts SET frozen").WithArgs(true, id).WillReturnResult(newFakeDriverResult(0))
+
+	a.Equal(ErrAccountNotExists, svc.SetFrozen(context.Background(), id, true))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminService_GetTransfer(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	id, sender, receiver := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery("SELECT (.+) FROM transfers WHERE id = \\$1").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "amount", "currency_code", "reason", "created_at"}).
+			AddRow(id, Internal, "10", "USD", nil, time.Time{}))
+	mock.ExpectQuery("SELECT (.+) FROM transfer_parts WHERE transfer_id = \\$1").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"transfer_id", "account_id", "corresponding_account_id", "direction"}).
+			AddRow(id, sender, receiver, Outgoing).
+			AddRow(id, receiver, sender, Incoming))
+
+	details, err := svc.GetTransfer(context.Background(), id)
+	a.NoError(err)
+	a.Equal(TransferDetails{
+		Transfer: Transfer{ID: id, Type: Internal, Amount: decimal.RequireFromString("10"), CurrencyCode: "USD"},
+		Parts: []TransferPart{
+			{TransferID: id, AccountID: sender, CorrespondingAccountID: &receiver, Direction: Outgoing},
+			{TransferID: id, AccountID: receiver, CorrespondingAccountID: &sender, Direction: Incoming},
+		},
+	}, details)
+
+	mock.ExpectQuery("SELECT (.+) FROM transfers WHERE id = \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "amount", "currency_code", "reason", "created_at"}))
+	_, err = svc.GetTransfer(context.Background(), uuid.New())
+	a.Equal(ErrTransferNotExists, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminService_CheckConservation(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepareAdmin()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT currency_code, SUM\\(balance\\) FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"currency_code", "sum"}).AddRow("USD", "90").AddRow("EUR", "7"))
+	mock.ExpectQuery("SELECT currency_code,(.+)FROM transfers GROUP BY currency_code").
+		WillReturnRows(sqlmock.NewRows([]string{"currency_code", "deposits", "withdrawals"}).
+			AddRow("USD", "100", "10").AddRow("EUR", "7", "1").AddRow("GBP", "1", "1"))
+	mock.ExpectRollback()
+
+	totals, err := svc.CheckConservation(context.Background())
+	a.NoError(err)
+	a.Equal([]CurrencyTotals{
+		{CurrencyCode: "EUR", Balances: decimal.New(7, 0), Deposits: decimal.New(7, 0), Withdrawals: decimal.New(1, 0)},
+		{CurrencyCode: "GBP", Deposits: decimal.New(1, 0), Withdrawals: decimal.New(1, 0), Conserved: true},
+		{CurrencyCode: "USD", Balances: decimal.New(90, 0), Deposits: decimal.New(100, 0), Withdrawals: decimal.New(10, 0),
+			Conserved: true},
+	}, totals)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CreateTransfer_FrozenReceiver(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").
+		WillReturnRows(sqlmock.NewRows([]string{"precision", "disabled"}).AddRow("2", false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(sqlmock.NewRows(accountColumnNames).
+		AddRow(order.SenderAccountID, nil, "USD", "20", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", true, time.Now(), time.Now()))
+	mock.ExpectRollback()
+
+	a.Equal(ErrReceiverFrozen, svc.CreateTransfer(context.Background(), order))
+	a.NoError(mock.ExpectationsWereMet())
+}
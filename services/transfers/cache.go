@@ -0,0 +1,111 @@
+package transfers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CurrenciesChannel is notified by trigger on each transaction changing currencies.
+const CurrenciesChannel = "currencies"
+
+// CachedRepository keeps all currencies in memory, so transfers don't query them each time.
+// Currencies are reloaded after ttl or invalidation, the last happens on own changes
+// and on each value from invalidations (see Run), e.g. pgnotify.Listen of CurrenciesChannel.
+type CachedRepository struct {
+	Repository
+	ttl           time.Duration
+	invalidations <-chan struct{}
+	now           func() time.Time
+
+	mu         sync.RWMutex
+	currencies map[string]Currency // nil if not loaded or invalidated
+	list       []Currency
+	expiresAt  time.Time
+}
+
+func NewCachedRepository(repo Repository, ttl time.Duration, invalidations <-chan struct{}) *CachedRepository {
+	return &CachedRepository{Repository: repo, ttl: ttl, invalidations: invalidations, now: time.Now}
+}
+
+// Run invalidates cache on each value from invalidations till ctx is done or channel is closed.
+func (r *CachedRepository) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-r.invalidations:
+			if !ok {
+				return nil
+			}
+			r.Invalidate()
+		}
+	}
+}
+
+func (r *CachedRepository) Invalidate() {
+	r.mu.Lock()
+	r.currencies, r.list = nil, nil
+	r.mu.Unlock()
+}
+
+// cached gives currencies loading them if needed, only one load happens at once.
+func (r *CachedRepository) cached(ctx context.Context) (map[string]Currency, []Currency, error) {
+	r.mu.RLock()
+	currencies, list, fresh := r.currencies, r.list, r.currencies != nil && r.now().Before(r.expiresAt)
+	r.mu.RUnlock()
+	if fresh {
+		return currencies, list, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.currencies != nil && r.now().Before(r.expiresAt) {
+		return r.currencies, r.list, nil
+	}
+	list, err := r.Repository.GetCurrencies(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	currencies = make(map[string]Currency, len(list))
+	for _, c := range list {
+		currencies[c.Code] = c
+	}
+	r.currencies, r.list, r.expiresAt = currencies, list, r.now().Add(r.ttl)
+
+	return currencies, list, nil
+}
+
+func (r *CachedRepository) GetCurrency(ctx context.Context, code string) (Currency, bool, error) {
+	currencies, _, err := r.cached(ctx)
+	if err != nil {
+		return Currency{}, false, err
+	}
+	c, ok := currencies[code]
+
+	return c, ok, nil
+}
+
+func (r *CachedRepository) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	_, list, err := r.cached(ctx)
+
+	return append([]Currency(nil), list...), err
+}
+
+func (r *CachedRepository) CreateCurrency(ctx context.Context, c Currency) (bool, error) {
+	defer r.Invalidate()
+
+	return r.Repository.CreateCurrency(ctx, c)
+}
+
+func (r *CachedRepository) UpdateCurrencyPrecision(ctx context.Context, code string, precision uint) (bool, error) {
+	defer r.Invalidate()
+
+	return r.Repository.UpdateCurrencyPrecision(ctx, code, precision)
+}
+
+func (r *CachedRepository) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (bool, error) {
+	defer r.Invalidate()
+
+	return r.Repository.SetCurrencyDisabled(ctx, code, disabled)
+}
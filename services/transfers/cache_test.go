@@ -0,0 +1,170 @@
+package transfers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+// currenciesRepository counts currencies queries, transfers are accepted without storing anything.
+type currenciesRepository struct {
+	Repository
+	currencies []Currency
+	queries    int
+}
+
+func (r *currenciesRepository) GetCurrency(ctx context.Context, code string) (Currency, bool, error) {
+	r.queries++
+	for _, c := range r.currencies {
+		if c.Code == code {
+			return c, true, nil
+		}
+	}
+
+	return Currency{}, false, nil
+}
+
+func (r *currenciesRepository) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	r.queries++
+
+	return append([]Currency(nil), r.currencies...), nil
+}
+
+func (r *currenciesRepository) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (bool, error) {
+	for i := range r.currencies {
+		if r.currencies[i].Code == code {
+			r.currencies[i].Disabled = disabled
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *currenciesRepository) IsTransferIDUsedError(err error) bool { return false }
+
+func (r *currenciesRepository) IsEntityNotFoundError(id uuid.UUID, err error) bool { return false }
+
+func (r *currenciesRepository) CreateInnerTransferTransactionWithLock(
+	ctx context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) error {
+	return c(
+		Account{ID: sender, CurrencyCode: "USD", Balance: decimal.New(1, 9)},
+		Account{ID: receiver, CurrencyCode: "USD"},
+		discardActions{})
+}
+
+type discardActions struct{}
+
+func (discardActions) CreateTransfer(Transfer) error                  { return nil }
+func (discardActions) UpdateBalance(uuid.UUID, decimal.Decimal) error { return nil }
+func (discardActions) CreateTransferPart(TransferPart) error          { return nil }
+func (discardActions) CreateEvent(outbox.Event) error                 { return nil }
+
+func newCurrenciesRepository() *currenciesRepository {
+	return &currenciesRepository{currencies: []Currency{{Code: "EUR", Precision: 2}, {Code: "USD", Precision: 2}}}
+}
+
+func TestCachedRepository_TTL(t *testing.T) {
+	a := assert.New(t)
+	repo := newCurrenciesRepository()
+	cached := NewCachedRepository(repo, time.Minute, nil)
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	cached.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	c, ok, err := cached.GetCurrency(ctx, "USD")
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(Currency{Code: "USD", Precision: 2}, c)
+	_, ok, _ = cached.GetCurrency(ctx, "GBP")
+	a.False(ok)
+	list, _ := cached.GetCurrencies(ctx)
+	a.Len(list, 2)
+	a.Equal(1, repo.queries, "all currencies are loaded at once")
+
+	now = now.Add(time.Minute)
+	_, _, _ = cached.GetCurrency(ctx, "USD")
+	a.Equal(2, repo.queries, "reloaded after ttl")
+}
+
+func TestCachedRepository_Invalidation(t *testing.T) {
+	a := assert.New(t)
+	repo := newCurrenciesRepository()
+	invalidations := make(chan struct{})
+	cached := NewCachedRepository(repo, time.Hour, invalidations)
+	ctx := context.Background()
+	done := make(chan error)
+	go func() { done <- cached.Run(ctx) }()
+
+	_, _, _ = cached.GetCurrency(ctx, "USD")
+	repo.currencies[1].Precision = 4 // changed by other replica
+	invalidations <- struct{}{}
+	invalidations <- struct{}{} // processed the first one for sure
+	c, _, _ := cached.GetCurrency(ctx, "USD")
+	a.Equal(uint(4), c.Precision)
+
+	_, err := cached.SetCurrencyDisabled(ctx, "USD", true)
+	a.NoError(err)
+	c, _, _ = cached.GetCurrency(ctx, "USD")
+	a.True(c.Disabled, "own change invalidates cache")
+	a.Equal(3, repo.queries)
+
+	close(invalidations)
+	a.NoError(<-done, "stops when channel is closed")
+}
+
+func TestCachedRepository_DisabledCurrency(t *testing.T) {
+	a := assert.New(t)
+	repo := newCurrenciesRepository()
+	svc := NewService(NewCachedRepository(repo, time.Hour, nil))
+	ctx := context.Background()
+
+	a.NoError(svc.CreateTransfer(ctx, newValidOrder()))
+	_, err := svc.SetCurrencyDisabled(ctx, "USD", true)
+	a.NoError(err)
+	a.Equal(ErrCurrencyDisabled, svc.CreateTransfer(ctx, newValidOrder()))
+}
+
+// BenchmarkCreateTransfer shows currencies queries per transfer with and without cache.
+func BenchmarkCreateTransfer(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		repo func(r Repository) Repository
+	}{
+		{"uncached", func(r Repository) Repository { return r }},
+		{"cached", func(r Repository) Repository { return NewCachedRepository(r, time.Minute, nil) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			repo := newCurrenciesRepository()
+			svc := NewService(bc.repo(repo))
+			order := newValidOrder()
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := svc.CreateTransfer(ctx, order); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(repo.queries)/float64(b.N), "currency-queries/op")
+		})
+	}
+}
+
+func BenchmarkCachedRepository_GetCurrencyParallel(b *testing.B) {
+	cached := NewCachedRepository(newCurrenciesRepository(), time.Minute, nil)
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, ok, err := cached.GetCurrency(ctx, "USD"); !ok || err != nil {
+				b.Fatal("currency isn't found", err)
+			}
+		}
+	})
+}
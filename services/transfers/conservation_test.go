@@ -0,0 +1,107 @@
+package transfers
+
+import (
+	"context"
+	"testing"
+	"testing/quick"
+
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// conservationOp is random operation on one of conservationAccounts, generated by testing/quick.
+// Amounts are small relative to balances, so some transfers and withdrawals fail for insufficient funds.
+type conservationOp struct {
+	Kind     uint8
+	From, To uint8
+	// in cents, negative adjustment is withdrawal
+	Cents int16
+}
+
+// conservationAccounts are USD ones besides the last EUR one, so transfers of mixed currencies are attempted too.
+var conservationAccounts = []string{"USD", "USD", "USD", "EUR"}
+
+type conservationRun struct {
+	svc      service
+	accounts []uuid.UUID
+	// last transfer order, it's retried to check idempotency
+	last InnerTransferOrder
+}
+
+func (r *conservationRun) apply(ctx context.Context, op conservationOp) error {
+	from := r.accounts[int(op.From)%len(r.accounts)]
+	to := r.accounts[int(op.To)%len(r.accounts)]
+	amount := decimal.New(int64(op.Cents), -2)
+	switch op.Kind % 5 {
+	case 0:
+		return r.svc.Adjust(ctx, Adjustment{ID: uuid.New(), AccountID: from, Amount: amount, Reason: "property"})
+	case 1:
+		return r.svc.CreateTransfer(ctx, r.last)
+	case 2:
+		return r.svc.SetFrozen(ctx, from, op.Cents%2 == 0)
+	default:
+		r.last = InnerTransferOrder{
+			ID: uuid.New(), SenderAccountID: from, ReceiverAccountID: to, Amount: amount.Abs(), CurrencyCode: "USD",
+		}
+
+		return r.svc.CreateTransfer(ctx, r.last)
+	}
+}
+
+// checkConservation runs random operation sequences against service and checks after each operation
+// that money of each currency is conserved and every balance is sum of its transfers.
+func checkConservation(t *testing.T, newRepo func() Repository, count int) {
+	ctx := context.Background()
+	applied := 0
+	property := func(ops []conservationOp) bool {
+		run := conservationRun{svc: service{repo: newRepo(), volume: discard.NewCounter()}}
+		for _, currency := range conservationAccounts {
+			a, err := run.svc.CreateAccount(ctx, nil, currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			run.accounts = append(run.accounts, a.ID)
+		}
+		for i, op := range ops {
+			if err := run.apply(ctx, op); err == nil {
+				applied++
+			}
+			totals, err := run.svc.CheckConservation(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, total := range totals {
+				if !total.Conserved {
+					t.Logf("%+v isn't conserved after operation %d of %+v", total, i, ops)
+					return false
+				}
+			}
+			mismatches, err := run.svc.CheckBalances(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mismatches) > 0 {
+				t.Logf("%+v after operation %d of %+v", mismatches, i, ops)
+				return false
+			}
+		}
+
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: count}); err != nil {
+		t.Error(err)
+	}
+	if applied == 0 {
+		t.Error("no operation succeeded, so nothing is checked")
+	}
+}
+
+func TestService_Conservation(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		checkConservation(t, func() Repository { return NewMemoryRepository() }, 100)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		checkConservation(t, func() Repository { return newSQLiteTestRepository(t) }, 10)
+	})
+}
@@ -0,0 +1,65 @@
+package transfers
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+var currencyCodeRe = regexp.MustCompile(`^[A-Z]{3,4}$`)
+
+func (s service) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	return s.repo.GetCurrencies(ctx)
+}
+
+func (s service) SaveCurrency(ctx context.Context, c Currency) (Currency, error) {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	if !currencyCodeRe.MatchString(c.Code) {
+		return Currency{}, ErrInvalidCurrencyCode
+	}
+	if c.Precision > MaxPrecision {
+		return Currency{}, ErrInvalidPrecision
+	}
+	existing, ok, err := s.repo.GetCurrency(ctx, c.Code)
+	if err != nil {
+		return Currency{}, err
+	}
+	if !ok {
+		c.Disabled = false
+		created, err := s.repo.CreateCurrency(ctx, c)
+		if err != nil || created {
+			return c, err
+		}
+		// created concurrently, so its precision is changed as of existing one
+		if existing, _, err = s.repo.GetCurrency(ctx, c.Code); err != nil {
+			return Currency{}, err
+		}
+	}
+	if existing.Precision == c.Precision {
+		return existing, nil
+	}
+	updated, err := s.repo.UpdateCurrencyPrecision(ctx, c.Code, c.Precision)
+	if err != nil {
+		return Currency{}, err
+	}
+	if !updated {
+		return Currency{}, ErrPrecisionTruncates
+	}
+	existing.Precision = c.Precision
+
+	return existing, nil
+}
+
+func (s service) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (Currency, error) {
+	code = strings.ToUpper(code)
+	ok, err := s.repo.SetCurrencyDisabled(ctx, code, disabled)
+	if err != nil {
+		return Currency{}, err
+	}
+	if !ok {
+		return Currency{}, ErrUnsupportedCurrency
+	}
+	c, _, err := s.repo.GetCurrency(ctx, code)
+
+	return c, err
+}
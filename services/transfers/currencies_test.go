@@ -0,0 +1,118 @@
+package transfers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var currencyColumnNames = []string{"precision", "disabled"}
+
+func TestService_SaveCurrency_Create(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("GBP").
+		WillReturnRows(sqlmock.NewRows(currencyColumnNames))
+	mock.ExpectExec("INSERT INTO currencies").WithArgs("GBP", 2, false).WillReturnResult(newFakeDriverResult(1))
+
+	c, err := svc.SaveCurrency(context.Background(), Currency{Code: " gbp", Precision: 2, Disabled: true})
+	a.NoError(err)
+	a.Equal(Currency{Code: "GBP", Precision: 2}, c, "new currency is enabled")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_SaveCurrency_Validate(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	_, err = svc.SaveCurrency(context.Background(), Currency{Code: "US", Precision: 2})
+	a.Equal(ErrInvalidCurrencyCode, err)
+	_, err = svc.SaveCurrency(context.Background(), Currency{Code: "US1", Precision: 2})
+	a.Equal(ErrInvalidCurrencyCode, err)
+	_, err = svc.SaveCurrency(context.Background(), Currency{Code: "USD", Precision: MaxPrecision + 1})
+	a.Equal(ErrInvalidPrecision, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_SaveCurrency_PrecisionTruncates(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows(currencyColumnNames).AddRow(8, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT true FROM currencies (.+) FOR UPDATE").WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	mock.ExpectQuery("FROM mandates").WithArgs("BTC", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("UPDATE currencies SET precision").WithArgs("BTC", 2).WillReturnResult(newFakeDriverResult(0))
+	mock.ExpectCommit()
+
+	_, err = svc.SaveCurrency(context.Background(), Currency{Code: "BTC", Precision: 2})
+	a.Equal(ErrPrecisionTruncates, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_SaveCurrency_PrecisionTruncatesMandates(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows(currencyColumnNames).AddRow(8, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT true FROM currencies (.+) FOR UPDATE").WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	mock.ExpectQuery("FROM mandates").WithArgs("BTC", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	_, err = svc.SaveCurrency(context.Background(), Currency{Code: "BTC", Precision: 2})
+	a.Equal(ErrPrecisionTruncatesMandates, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_SaveCurrency_SamePrecision(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows(currencyColumnNames).AddRow(8, true))
+
+	c, err := svc.SaveCurrency(context.Background(), Currency{Code: "BTC", Precision: 8})
+	a.NoError(err)
+	a.Equal(Currency{Code: "BTC", Precision: 8, Disabled: true}, c, "disabled one isn't enabled")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_SetCurrencyDisabled_NotExists(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectExec("UPDATE currencies SET disabled").WithArgs("XYZ", true).WillReturnResult(newFakeDriverResult(0))
+
+	_, err = svc.SetCurrencyDisabled(context.Background(), "xyz", true)
+	a.Equal(ErrUnsupportedCurrency, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_CreateTransfer_CurrencyDisabled(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows(currencyColumnNames).AddRow(2, true))
+
+	a.Equal(ErrCurrencyDisabled, svc.CreateTransfer(context.Background(), newValidOrder()))
+	a.NoError(mock.ExpectationsWereMet())
+}
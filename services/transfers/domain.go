@@ -11,19 +11,52 @@ import (
 
 // Business logic level errors that provide enough information about what went wrong.
 var (
-	ErrAccountsMustBeDifferent = errors.New("accounts_must_be_different")
-	ErrAmountMustBePositive    = errors.New("transfer_amount_must_be_positive")
-	ErrUnsupportedCurrency     = errors.New("currency_not_supported")
-	ErrInsufficientFunds       = errors.New("insufficient_funds")
-	ErrSenderNotExists         = errors.New("sender_account_not_exist")
-	ErrReceiverNotExists       = errors.New("receiver_account_not_exist")
-	ErrSenderWrongCurrency     = errors.New("sender_account_wrong_currency")
-	ErrReceiverWrongCurrency   = errors.New("receiver_account_wrong_currency")
-	ErrEmptyTransferID         = errors.New("transfer_id_is_empty")
-	ErrEmptySenderAccountID    = errors.New("sender_account_id_is_empty")
-	ErrEmptyReceiverAccountID  = errors.New("receiver_account_id_is_empty")
+	ErrAccountsMustBeDifferent    = errors.New("accounts_must_be_different")
+	ErrAmountMustBePositive       = errors.New("transfer_amount_must_be_positive")
+	ErrUnsupportedCurrency        = errors.New("currency_not_supported")
+	ErrInsufficientFunds          = errors.New("insufficient_funds")
+	ErrSenderNotExists            = errors.New("sender_account_not_exist")
+	ErrReceiverNotExists          = errors.New("receiver_account_not_exist")
+	ErrSenderWrongCurrency        = errors.New("sender_account_wrong_currency")
+	ErrReceiverWrongCurrency      = errors.New("receiver_account_wrong_currency")
+	ErrEmptyTransferID            = errors.New("transfer_id_is_empty")
+	ErrEmptySenderAccountID       = errors.New("sender_account_id_is_empty")
+	ErrEmptyReceiverAccountID     = errors.New("receiver_account_id_is_empty")
+	ErrAccountNotExists           = errors.New("account_not_exist")
+	ErrSenderFrozen               = errors.New("sender_account_frozen")
+	ErrReceiverFrozen             = errors.New("receiver_account_frozen")
+	ErrEmptyAccountID             = errors.New("account_id_is_empty")
+	ErrEmptyReason                = errors.New("adjustment_reason_is_empty")
+	ErrZeroAdjustment             = errors.New("adjustment_amount_is_zero")
+	ErrTransferNotExists          = errors.New("transfer_not_exist")
+	ErrCurrencyDisabled           = errors.New("currency_disabled")
+	ErrInvalidCurrencyCode        = errors.New("currency_code_invalid")
+	ErrInvalidPrecision           = errors.New("currency_precision_invalid")
+	ErrPrecisionTruncates         = errors.New("currency_precision_truncates_balances")
+	ErrPrecisionChanged           = errors.New("currency_precision_changed")
+	ErrPrecisionTruncatesMandates = errors.New("currency_precision_truncates_mandates")
 )
 
+var businessErrors = []error{
+	ErrAccountsMustBeDifferent, ErrAmountMustBePositive, ErrUnsupportedCurrency,
+	ErrInsufficientFunds, ErrSenderNotExists, ErrReceiverNotExists,
+	ErrSenderWrongCurrency, ErrReceiverWrongCurrency, ErrEmptyTransferID,
+	ErrEmptySenderAccountID, ErrEmptyReceiverAccountID, ErrSenderFrozen, ErrReceiverFrozen,
+	ErrCurrencyDisabled, ErrPrecisionChanged,
+}
+
+// IsBusinessError reports whether err is one of business logic level errors,
+// so it is caused by the order itself rather than by infrastructure.
+func IsBusinessError(err error) bool {
+	for _, e := range businessErrors {
+		if err == e {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Transfer type enums.
 const (
 	Deposit  = "DEPOSIT"
@@ -37,10 +70,21 @@ const (
 	Outgoing = "OUTGOING"
 )
 
+// Event types written to outbox inside transfer transaction.
+const (
+	TransferCreated = "TransferCreated"
+	BalanceChanged  = "BalanceChanged"
+)
+
+// MaxPrecision of currency, amounts are rounded to precision of their currency.
+const MaxPrecision = 18
+
 // Currency model for multiple currencies each one with different precision.
 type Currency struct {
-	Code      string
-	Precision uint
+	Code      string `json:"code"`
+	Precision uint   `json:"precision"`
+	// new transfers in disabled currency aren't accepted
+	Disabled bool `json:"disabled"`
 }
 
 // Transfer order for system to process.
@@ -64,32 +108,118 @@ type TransferInfo struct {
 }
 
 type Transfer struct {
-	ID           uuid.UUID
-	Type         string // Deposit, Withdraw, Internal
-	Amount       decimal.Decimal
-	CurrencyCode string
-	CreatedAt    time.Time
+	ID           uuid.UUID       `json:"id"`
+	Type         string          `json:"type"` // Deposit, Withdraw, Internal
+	Amount       decimal.Decimal `json:"amount"`
+	CurrencyCode string          `json:"currency_code"`
+	// why money was adjusted manually, nil for transfers ordered by clients
+	Reason    *string   `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type TransferPart struct {
-	TransferID             uuid.UUID
-	AccountID              uuid.UUID
-	CorrespondingAccountID *uuid.UUID
-	Direction              string
+	TransferID             uuid.UUID  `json:"transfer_id"`
+	AccountID              uuid.UUID  `json:"account_id"`
+	CorrespondingAccountID *uuid.UUID `json:"corresponding_account_id"`
+	Direction              string     `json:"direction"`
+}
+
+// Transfer with its parts, one for deposit/withdraw and two (outgoing and incoming) for inner one.
+type TransferDetails struct {
+	Transfer
+	Parts []TransferPart `json:"parts"`
+}
+
+// Manual adjustment of account balance, it's stored as deposit or withdraw transfer.
+type Adjustment struct {
+	ID        uuid.UUID `json:"id"`
+	AccountID uuid.UUID `json:"account_id"`
+	// positive amount is deposited to account, negative one is withdrawn
+	Amount decimal.Decimal `json:"amount"`
+	Reason string          `json:"reason"`
+}
+
+// Account whose balance differs from sum of its transfer parts.
+type BalanceMismatch struct {
+	AccountID    uuid.UUID       `json:"account_id"`
+	CurrencyCode string          `json:"currency_code"`
+	Balance      decimal.Decimal `json:"balance"`
+	Expected     decimal.Decimal `json:"expected"`
+}
+
+// Totals of currency, inner transfers only move money between accounts, so money is conserved
+// if sum of balances equals deposits minus withdrawals.
+type CurrencyTotals struct {
+	CurrencyCode string          `json:"currency_code"`
+	Balances     decimal.Decimal `json:"balances"`
+	Deposits     decimal.Decimal `json:"deposits"`
+	Withdrawals  decimal.Decimal `json:"withdrawals"`
+	Conserved    bool            `json:"conserved"`
+}
+
+// Payload of TransferCreated event.
+type TransferCreatedEvent struct {
+	ID                uuid.UUID       `json:"id"`
+	Type              string          `json:"type"`
+	SenderAccountID   uuid.UUID       `json:"sender_account_id"`
+	ReceiverAccountID uuid.UUID       `json:"receiver_account_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	CurrencyCode      string          `json:"currency_code"`
+}
+
+// Payload of BalanceChanged event, one per each account participated in transfer.
+type BalanceChangedEvent struct {
+	AccountID    uuid.UUID       `json:"account_id"`
+	TransferID   uuid.UUID       `json:"transfer_id"`
+	Diff         decimal.Decimal `json:"diff"`
+	Balance      decimal.Decimal `json:"balance"`
+	CurrencyCode string          `json:"currency_code"`
 }
 
 // Account representation with time fields that are updated accordingly.
 type Account struct {
-	ID           uuid.UUID       `json:"id"`
+	ID uuid.UUID `json:"id"`
+	// owner of the account, accounts without one are accessible only internally
+	CustomerID   *uuid.UUID      `json:"customer_id"`
 	CurrencyCode string          `json:"currency_code"`
 	Balance      decimal.Decimal `json:"balance"`
-	CreatedAt    time.Time       `json:"created_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
+	// frozen account can't send or receive transfers
+	Frozen    bool      `json:"frozen"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// Business actions.
+// Business actions, if there is auth.Principal in context only accounts of its customer are accessible
+// (except receiver of transfer), otherwise call is treated as internal one and isn't restricted.
 type Service interface {
 	CreateTransfer(ctx context.Context, order InnerTransferOrder) error
 	GetTransfersForAccount(ctx context.Context, accountID uuid.UUID) ([]TransferInfo, error)
 	GetAccounts(ctx context.Context) ([]Account, error)
+	// returns ErrAccountNotExists if account isn't accessible
+	GetAccount(ctx context.Context, accountID uuid.UUID) (Account, error)
+	CurrencyService
+}
+
+// Currencies are shared by all customers, managing them requires auth.ScopeCurrenciesManage.
+type CurrencyService interface {
+	GetCurrencies(ctx context.Context) ([]Currency, error)
+	// creates currency or changes precision of existing one,
+	// returns ErrPrecisionTruncates if some balance has more decimal places than new precision,
+	// ErrPrecisionTruncatesMandates if amount of some not cancelled mandate has them
+	SaveCurrency(ctx context.Context, c Currency) (Currency, error)
+	SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (Currency, error)
+}
+
+// Operational actions of admins, they aren't restricted by auth.Principal and aren't exposed by API.
+type AdminService interface {
+	CreateAccount(ctx context.Context, customerID *uuid.UUID, currencyCode string) (Account, error)
+	// positive-idempotent by adjustment id like CreateTransfer
+	Adjust(ctx context.Context, adj Adjustment) error
+	SetFrozen(ctx context.Context, accountID uuid.UUID, frozen bool) error
+	GetTransfer(ctx context.Context, transferID uuid.UUID) (TransferDetails, error)
+	CurrencyService
+	// gives accounts whose balance isn't equal to sum of their transfer parts
+	CheckBalances(ctx context.Context) ([]BalanceMismatch, error)
+	// gives totals of each currency taken from consistent snapshot, see CurrencyTotals.Conserved
+	CheckConservation(ctx context.Context) ([]CurrencyTotals, error)
 }
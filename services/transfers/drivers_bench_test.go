@@ -0,0 +1,87 @@
+package transfers
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/migrations"
+)
+
+// withParam adds param to DSN in URL or key=value form.
+func withParam(dsn, key, value string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " " + key + "=" + value
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + key + "=" + value
+	}
+
+	return dsn + "?" + key + "=" + value
+}
+
+// BenchmarkCreateTransfer_Drivers compares throughput of concurrent transfers via lib/pq and pgx on TEST_DB, e.g.
+//
+//	TEST_DB='host=localhost user=postgres password=test sslmode=disable' go test -run - -bench Drivers ./services/transfers
+func BenchmarkCreateTransfer_Drivers(b *testing.B) {
+	dsn := os.Getenv(TestDBEnv)
+	if dsn == "" {
+		b.Skipf("%s isn't set", TestDBEnv)
+	}
+	const pairs = 16
+	for _, d := range []struct {
+		name, driver, dsn string
+	}{
+		{"pq", migrations.Postgres, dsn},
+		{"pgx", migrations.Pgx, dsn},
+		{"pgx_without_statement_cache", migrations.Pgx, withParam(dsn, "statement_cache_capacity", "0")},
+	} {
+		d := d
+		b.Run(d.name, func(b *testing.B) {
+			db, err := migrations.Open(d.driver, d.dsn)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(pairs)
+			db.SetMaxIdleConns(pairs)
+			if _, err = migrations.Set.Exec(db, migrations.Dialect, migrations.Source, migrate.Up); err != nil {
+				b.Fatal(err)
+			}
+			repo := NewRepository(db)
+			svc := NewService(repo)
+			accounts := make([]Account, 0, 2*pairs)
+			for i := 0; i < 2*pairs; i++ {
+				accounts = append(accounts, createFundedAccount(b, repo, "USD", "1000000"))
+			}
+			amount := decimal.RequireFromString("0.01")
+			var next int64
+
+			b.ResetTimer()
+			start := time.Now()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					// transfers are spread over pairs round robin, so they rarely wait for the same locks
+					pair := int(atomic.AddInt64(&next, 1)) % pairs
+					// money goes one way within pair, so opposite transfers never wait for each other
+					sender, receiver := accounts[2*pair], accounts[2*pair+1]
+					err := svc.CreateTransfer(context.Background(), InnerTransferOrder{
+						ID: uuid.New(), SenderAccountID: sender.ID, ReceiverAccountID: receiver.ID,
+						Amount: amount, CurrencyCode: "USD",
+					})
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "transfers/s")
+		})
+	}
+}
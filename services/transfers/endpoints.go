@@ -5,6 +5,8 @@ import (
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/auth"
 )
 
 type CreateTransferRequest struct {
@@ -15,6 +17,8 @@ type CreateTransferResponse struct {
 	Err error
 }
 
+func (r CreateTransferResponse) Failed() error { return r.Err }
+
 func MakeCreateTransferEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(CreateTransferRequest)
@@ -24,6 +28,15 @@ func MakeCreateTransferEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+// SenderKey keys CreateTransfer calls by sender account for rate limiting (see ratelimit.Rule),
+// bucket is per customer of principal as well, so clients can't drain buckets of others' accounts.
+func SenderKey(ctx context.Context, request interface{}) (string, bool) {
+	req, ok := request.(CreateTransferRequest)
+	p, _ := auth.FromContext(ctx)
+
+	return "sender:" + p.CustomerID.String() + ":" + req.SenderAccountID.String(), ok
+}
+
 type GetTransfersForAccountRequest struct {
 	AccountID uuid.UUID
 }
@@ -33,6 +46,8 @@ type GetTransfersForAccountResponse struct {
 	Err       error
 }
 
+func (r GetTransfersForAccountResponse) Failed() error { return r.Err }
+
 func MakeGetTransfersForAccountEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(GetTransfersForAccountRequest)
@@ -49,6 +64,8 @@ type GetAccountsResponse struct {
 	Err      error
 }
 
+func (r GetAccountsResponse) Failed() error { return r.Err }
+
 func MakeGetAccountsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		_ = request.(GetAccountsRequest)
@@ -58,11 +75,66 @@ func MakeGetAccountsEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+type GetCurrenciesRequest struct{}
+
+type GetCurrenciesResponse struct {
+	Currencies []Currency
+	Err        error
+}
+
+func (r GetCurrenciesResponse) Failed() error { return r.Err }
+
+func MakeGetCurrenciesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(GetCurrenciesRequest)
+		currencies, err := s.GetCurrencies(ctx)
+
+		return GetCurrenciesResponse{Currencies: currencies, Err: err}, nil
+	}
+}
+
+type SaveCurrencyRequest struct {
+	Currency
+}
+
+type CurrencyResponse struct {
+	Currency Currency
+	Err      error
+}
+
+func (r CurrencyResponse) Failed() error { return r.Err }
+
+func MakeSaveCurrencyEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SaveCurrencyRequest)
+		c, err := s.SaveCurrency(ctx, req.Currency)
+
+		return CurrencyResponse{Currency: c, Err: err}, nil
+	}
+}
+
+type SetCurrencyDisabledRequest struct {
+	Code     string
+	Disabled bool
+}
+
+func MakeSetCurrencyDisabledEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SetCurrencyDisabledRequest)
+		c, err := s.SetCurrencyDisabled(ctx, req.Code, req.Disabled)
+
+		return CurrencyResponse{Currency: c, Err: err}, nil
+	}
+}
+
 func NewEndpoints(s Service) Endpoints {
 	return Endpoints{
 		CreateTransfer:         MakeCreateTransferEndpoint(s),
 		GetAccounts:            MakeGetAccountsEndpoint(s),
 		GetTransfersForAccount: MakeGetTransfersForAccountEndpoint(s),
+		GetCurrencies:          MakeGetCurrenciesEndpoint(s),
+		SaveCurrency:           MakeSaveCurrencyEndpoint(s),
+		SetCurrencyDisabled:    MakeSetCurrencyDisabledEndpoint(s),
 	}
 }
 
@@ -70,4 +142,36 @@ type Endpoints struct {
 	CreateTransfer         endpoint.Endpoint
 	GetTransfersForAccount endpoint.Endpoint
 	GetAccounts            endpoint.Endpoint
+	GetCurrencies          endpoint.Endpoint
+	SaveCurrency           endpoint.Endpoint
+	SetCurrencyDisabled    endpoint.Endpoint
+}
+
+// EndpointScopes required by endpoints, see auth.ScopeMiddleware.
+var EndpointScopes = map[string]string{
+	"CreateTransfer":         auth.ScopeTransfersWrite,
+	"GetTransfersForAccount": auth.ScopeAccountsRead,
+	"GetAccounts":            auth.ScopeAccountsRead,
+	"GetCurrencies":          auth.ScopeAccountsRead,
+	"SaveCurrency":           auth.ScopeCurrenciesManage,
+	"SetCurrencyDisabled":    auth.ScopeCurrenciesManage,
+}
+
+// ReadEndpoints don't change state, so they aren't recorded by audit.Middleware.
+var ReadEndpoints = map[string]bool{
+	"GetTransfersForAccount": true,
+	"GetAccounts":            true,
+	"GetCurrencies":          true,
+}
+
+// Wrap decorates each endpoint with middleware built for its name.
+func (e Endpoints) Wrap(mw func(name string) endpoint.Middleware) Endpoints {
+	e.CreateTransfer = mw("CreateTransfer")(e.CreateTransfer)
+	e.GetTransfersForAccount = mw("GetTransfersForAccount")(e.GetTransfersForAccount)
+	e.GetAccounts = mw("GetAccounts")(e.GetAccounts)
+	e.GetCurrencies = mw("GetCurrencies")(e.GetCurrencies)
+	e.SaveCurrency = mw("SaveCurrency")(e.SaveCurrency)
+	e.SetCurrencyDisabled = mw("SetCurrencyDisabled")(e.SetCurrencyDisabled)
+
+	return e
 }
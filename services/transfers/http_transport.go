@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport"
@@ -12,14 +13,41 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// ErrorEncoder hides errors from HTTP level except ones that specify
+// status code explicitly (see httptransport.StatusCoder), e.g. authentication ones,
+// headers of errors implementing httptransport.Headerer are set too (e.g. Retry-After).
 func ErrorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if h, ok := err.(httptransport.Headerer); ok {
+		for k, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	}
 	_ = json.NewEncoder(w).Encode(NewCommonResponse(nil, err))
 }
 
+// ReadYourWritesHeader set to true makes list and history requests read from primary database,
+// so they see writes made just before instead of lagging replica.
+const ReadYourWritesHeader = "X-Read-Your-Writes"
+
+// ReadYourWrites marks context by WithPrimary if request asks for that by ReadYourWritesHeader.
+func ReadYourWrites(ctx context.Context, r *http.Request) context.Context {
+	if primary, _ := strconv.ParseBool(r.Header.Get(ReadYourWritesHeader)); primary {
+		return WithPrimary(ctx)
+	}
+
+	return ctx
+}
+
 func NewHTTPHandler(endpoints Endpoints, logger log.Logger) http.Handler {
 	r := mux.NewRouter().StrictSlash(true)
 	errorEncoder := httptransport.ServerErrorEncoder(ErrorEncoder)
+	readYourWrites := httptransport.ServerBefore(ReadYourWrites)
 	r.Handle("/transfers/",
 		httptransport.NewServer(endpoints.CreateTransfer,
 			DecodeCreateTransferRequest, EncodeCreateTransferResponse,
@@ -29,13 +57,33 @@ func NewHTTPHandler(endpoints Endpoints, logger log.Logger) http.Handler {
 	r.Handle("/accounts/{account_id}/transfers/",
 		httptransport.NewServer(endpoints.GetTransfersForAccount,
 			DecodeGetTransfersForAccountRequest, EncodeGetTransfersForAccountResponse,
-			errorEncoder, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
+			errorEncoder, readYourWrites, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
 		Methods("GET")
 	r.Handle("/accounts/",
 		httptransport.NewServer(endpoints.GetAccounts,
 			DecodeGetAccountsRequest, EncodeGetAccountsResponse,
+			errorEncoder, readYourWrites, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
+		Methods("GET")
+	r.Handle("/currencies/",
+		httptransport.NewServer(endpoints.GetCurrencies,
+			DecodeGetCurrenciesRequest, EncodeGetCurrenciesResponse,
 			errorEncoder, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
 		Methods("GET")
+	r.Handle("/currencies/{code}",
+		httptransport.NewServer(endpoints.SaveCurrency,
+			DecodeSaveCurrencyRequest, EncodeCurrencyResponse,
+			errorEncoder, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
+		Methods("PUT")
+	r.Handle("/currencies/{code}/disable",
+		httptransport.NewServer(endpoints.SetCurrencyDisabled,
+			decodeSetCurrencyDisabledRequest(true), EncodeCurrencyResponse,
+			errorEncoder, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
+		Methods("POST")
+	r.Handle("/currencies/{code}/enable",
+		httptransport.NewServer(endpoints.SetCurrencyDisabled,
+			decodeSetCurrencyDisabledRequest(false), EncodeCurrencyResponse,
+			errorEncoder, httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)))).
+		Methods("POST")
 
 	return r
 }
@@ -94,3 +142,36 @@ func EncodeGetAccountsResponse(_ context.Context, w http.ResponseWriter, res int
 
 	return json.NewEncoder(w).Encode(NewCommonResponse(response.Accounts, response.Err))
 }
+
+func DecodeGetCurrenciesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return GetCurrenciesRequest{}, nil
+}
+
+func EncodeGetCurrenciesResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetCurrenciesResponse)
+
+	return json.NewEncoder(w).Encode(NewCommonResponse(response.Currencies, response.Err))
+}
+
+// DecodeSaveCurrencyRequest takes code from path, so body has precision only.
+func DecodeSaveCurrencyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req SaveCurrencyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	req.Code = mux.Vars(r)["code"]
+
+	return req, err
+}
+
+func decodeSetCurrencyDisabledRequest(disabled bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		return SetCurrencyDisabledRequest{Code: mux.Vars(r)["code"], Disabled: disabled}, nil
+	}
+}
+
+func EncodeCurrencyResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(CurrencyResponse)
+
+	return json.NewEncoder(w).Encode(NewCommonResponse(response.Currency, response.Err))
+}
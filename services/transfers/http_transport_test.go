@@ -14,6 +14,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/ratelimit"
 )
 
 type svcEmptyMock struct{}
@@ -27,6 +30,18 @@ func (m svcEmptyMock) GetTransfersForAccount(ctx context.Context, accountID uuid
 func (m svcEmptyMock) GetAccounts(ctx context.Context) ([]Account, error) {
 	return nil, nil
 }
+func (m svcEmptyMock) GetAccount(ctx context.Context, accountID uuid.UUID) (Account, error) {
+	return Account{}, ErrAccountNotExists
+}
+func (m svcEmptyMock) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	return nil, nil
+}
+func (m svcEmptyMock) SaveCurrency(ctx context.Context, c Currency) (Currency, error) {
+	return c, nil
+}
+func (m svcEmptyMock) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (Currency, error) {
+	return Currency{}, ErrUnsupportedCurrency
+}
 
 var testLogger = log.NewLogfmtLogger(os.Stdout)
 
@@ -108,6 +123,22 @@ func (m svcMock) GetAccounts(ctx context.Context) ([]Account, error) {
 	}}, nil
 }
 
+func (m svcMock) GetAccount(ctx context.Context, accountID uuid.UUID) (Account, error) {
+	return Account{ID: accountID, Balance: decimal.New(1, 1), CurrencyCode: "USD"}, nil
+}
+
+func (m svcMock) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	return []Currency{{Code: "BTC", Precision: 8, Disabled: true}, {Code: "USD", Precision: 2}}, nil
+}
+
+func (m svcMock) SaveCurrency(ctx context.Context, c Currency) (Currency, error) {
+	return c, nil
+}
+
+func (m svcMock) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (Currency, error) {
+	return Currency{Code: code, Precision: 2, Disabled: disabled}, nil
+}
+
 func TestResponseFormatGetTransfers(t *testing.T) {
 	a := assert.New(t)
 	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
@@ -148,11 +179,131 @@ func TestResponseFormatGetAccounts(t *testing.T) {
   "payload": [
     {
       "id": "ab363360-632b-4643-b93f-0486b764e98d",
+      "customer_id": null,
       "currency_code": "USD",
       "balance": "10",
+      "frozen": false,
       "created_at": "0001-01-01T00:00:00Z",
       "updated_at": "0001-01-01T00:00:00Z"
     }
   ]
 }`, response.Body.String())
 }
+
+func TestScopesRequired(t *testing.T) {
+	a := assert.New(t)
+	endpoints := NewEndpoints(svcEmptyMock{}).Wrap(auth.ScopeMiddleware(EndpointScopes))
+	handler := NewHTTPHandler(endpoints, testLogger)
+
+	req, _ := http.NewRequest("GET", "/accounts/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusUnauthorized, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"unauthenticated"}`, response.Body.String())
+
+	ctx := auth.NewContext(context.Background(), auth.Principal{Scopes: []string{auth.ScopeAccountsRead}})
+	req, _ = http.NewRequestWithContext(ctx, "GET", "/accounts/", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+
+	req, _ = http.NewRequestWithContext(ctx, "POST", "/transfers/",
+		bytes.NewBuffer([]byte(`{"id":"AB363360-632B-4643-B93F-0486B764E98D"}`)))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusForbidden, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"forbidden"}`, response.Body.String())
+}
+
+func TestResponseFormatGetCurrencies(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("GET", "/currencies/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{
+  "result": "OK",
+  "payload": [
+    {"code": "BTC", "precision": 8, "disabled": true},
+    {"code": "USD", "precision": 2, "disabled": false}
+  ]
+}`, response.Body.String())
+}
+
+func TestSaveCurrency(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("PUT", "/currencies/GBP", bytes.NewBufferString(`{"code":"EUR","precision":2}`))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"OK","payload":{"code":"GBP","precision":2,"disabled":false}}`, response.Body.String(),
+		"code is taken from path")
+}
+
+func TestDisableCurrency(t *testing.T) {
+	a := assert.New(t)
+	handler := NewHTTPHandler(NewEndpoints(svcMock{}), testLogger)
+	req, _ := http.NewRequest("POST", "/currencies/USD/disable", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"OK","payload":{"code":"USD","precision":2,"disabled":true}}`, response.Body.String())
+
+	handler = NewHTTPHandler(NewEndpoints(svcEmptyMock{}), testLogger)
+	req, _ = http.NewRequest("POST", "/currencies/XYZ/enable", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.JSONEq(`{"result":"ERROR","error":"currency_not_supported"}`, response.Body.String())
+}
+
+// svcReadsMock records whether GetAccounts is asked to read from primary.
+type svcReadsMock struct {
+	svcEmptyMock
+	primary *bool
+}
+
+func (m svcReadsMock) GetAccounts(ctx context.Context) ([]Account, error) {
+	*m.primary = readsPrimary(ctx)
+	return nil, nil
+}
+
+func TestGetAccounts_ReadYourWrites(t *testing.T) {
+	a := assert.New(t)
+	var primary bool
+	handler := NewHTTPHandler(NewEndpoints(svcReadsMock{primary: &primary}), testLogger)
+	for header, expected := range map[string]bool{"": false, "false": false, "garbage": false, "true": true, "1": true} {
+		req, _ := http.NewRequest("GET", "/accounts/", nil)
+		req.Header.Set(ReadYourWritesHeader, header)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		a.Equal(expected, primary, "header %q", header)
+	}
+}
+
+func TestCreateTransferRateLimited(t *testing.T) {
+	a := assert.New(t)
+	limit := ratelimit.Limit{Rate: 0.1, Burst: 1}
+	endpoints := NewEndpoints(svcEmptyMock{}).Wrap(ratelimit.Middleware(ratelimit.NewMemoryLimiter(),
+		ratelimit.Rule{Endpoint: "CreateTransfer", Limit: limit, Key: SenderKey}))
+	handler := NewHTTPHandler(endpoints, testLogger)
+	senderID := uuid.New()
+	post := func(customerID uuid.UUID) *httptest.ResponseRecorder {
+		ctx := auth.NewContext(context.Background(), auth.Principal{CustomerID: customerID})
+		req, _ := http.NewRequestWithContext(ctx, "POST", "/transfers/",
+			bytes.NewBuffer([]byte(`{"id":"AB363360-632B-4643-B93F-0486B764E98D","sender_account_id":"`+senderID.String()+`"}`)))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		return response
+	}
+
+	customerID := uuid.New()
+	response := post(customerID)
+	a.Equal(http.StatusOK, response.Code)
+	response = post(customerID)
+	a.Equal(http.StatusTooManyRequests, response.Code)
+	a.Equal("10", response.Header().Get("Retry-After"))
+	a.JSONEq(`{"result":"ERROR", "error":"rate_limited"}`, response.Body.String())
+	response = post(uuid.New())
+	a.Equal(http.StatusOK, response.Code, "clients of other customers don't drain bucket")
+}
@@ -0,0 +1,509 @@
+package transfers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+// Errors of MemoryRepository mirroring DB constraints.
+var (
+	errMemoryTransferIDUsed  = errors.New("memory: transfer id is already used")
+	errMemoryAccountIDUsed   = errors.New("memory: account id is already used")
+	errMemoryNegativeBalance = errors.New("memory: balance must not be negative")
+	errMemoryNoCurrency      = errors.New("memory: currency doesn't exist")
+	errMemoryPartUsed        = errors.New("memory: transfer part of account is already created")
+)
+
+// MemoryRepository keeps everything in process, it's useful for tests and demos.
+// Accounts are locked by mutexes for the whole callback like rows are locked by DB transaction,
+// changes made by callback are applied only if it returns no error.
+type MemoryRepository struct {
+	now func() time.Time
+
+	mu         sync.RWMutex
+	currencies map[string]Currency
+	accounts   map[uuid.UUID]Account
+	locks      map[uuid.UUID]*sync.Mutex
+	transfers  map[uuid.UUID]Transfer
+	parts      []TransferPart
+	// keys of parts, they are unique like primary key of transfer_parts
+	partKeys map[partKey]bool
+	events   []outbox.Event
+}
+
+type partKey struct {
+	transferID uuid.UUID
+	accountID  uuid.UUID
+}
+
+// NewMemoryRepository gives repository with the same currencies as initial migration.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		now: time.Now,
+		currencies: map[string]Currency{
+			"USD": {Code: "USD", Precision: 2},
+			"EUR": {Code: "EUR", Precision: 2},
+			"BTC": {Code: "BTC", Precision: 8},
+		},
+		accounts:  make(map[uuid.UUID]Account),
+		locks:     make(map[uuid.UUID]*sync.Mutex),
+		transfers: make(map[uuid.UUID]Transfer),
+		partKeys:  make(map[partKey]bool),
+	}
+}
+
+// Events returns copy of all events of committed transactions.
+func (r *MemoryRepository) Events() []outbox.Event {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]outbox.Event(nil), r.events...)
+}
+
+func (r *MemoryRepository) GetCurrency(_ context.Context, code string) (Currency, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.currencies[code]
+
+	return c, ok, nil
+}
+
+func (r *MemoryRepository) GetCurrencies(_ context.Context) ([]Currency, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var currencies []Currency
+	for _, c := range r.currencies {
+		currencies = append(currencies, c)
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+
+	return currencies, nil
+}
+
+func (r *MemoryRepository) CreateCurrency(_ context.Context, c Currency) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.currencies[c.Code]; ok {
+		return false, nil
+	}
+	r.currencies[c.Code] = c
+
+	return true, nil
+}
+
+func (r *MemoryRepository) UpdateCurrencyPrecision(_ context.Context, code string, precision uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.currencies[code]
+	if !ok {
+		return false, nil
+	}
+	for _, a := range r.accounts {
+		if a.CurrencyCode == code && !fitsPrecision(a.Balance, Currency{Precision: precision}) {
+			return false, nil
+		}
+	}
+	c.Precision = precision
+	r.currencies[code] = c
+
+	return true, nil
+}
+
+// fitsPrecision reports whether amount has no more decimal places than precision of c.
+func fitsPrecision(amount decimal.Decimal, c Currency) bool {
+	return amount.Equal(amount.Round(int32(c.Precision)))
+}
+
+func (r *MemoryRepository) SetCurrencyDisabled(_ context.Context, code string, disabled bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.currencies[code]
+	if !ok {
+		return false, nil
+	}
+	c.Disabled = disabled
+	r.currencies[code] = c
+
+	return true, nil
+}
+
+func (r *MemoryRepository) IsTransferIDUsedError(err error) bool {
+	return errors.Is(err, errMemoryTransferIDUsed)
+}
+
+func (r *MemoryRepository) IsEntityNotFoundError(id uuid.UUID, err error) bool {
+	if err == nil || id == uuid.Nil {
+		return false
+	}
+	exactErr, ok := err.(entityNotFound)
+
+	return ok && exactErr.UUID == id
+}
+
+// lock locks existing accounts in order of ids, so concurrent transactions don't deadlock,
+// and gives locked ones in order given (or lower if some doesn't exist) like lockAccounts.
+func (r *MemoryRepository) lock(ids ...uuid.UUID) ([]Account, func()) {
+	r.mu.Lock()
+	locked := make(map[uuid.UUID]*sync.Mutex, len(ids))
+	for _, id := range ids {
+		if _, ok := r.accounts[id]; !ok {
+			continue
+		}
+		if _, ok := r.locks[id]; !ok {
+			r.locks[id] = &sync.Mutex{}
+		}
+		locked[id] = r.locks[id]
+	}
+	r.mu.Unlock()
+
+	order := make([]uuid.UUID, 0, len(locked))
+	for id := range locked {
+		order = append(order, id)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].String() < order[j].String() })
+	for _, id := range order {
+		locked[id].Lock()
+	}
+	unlock := func() {
+		for _, id := range order {
+			locked[id].Unlock()
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	accounts := make([]Account, 0, len(locked))
+	for _, id := range ids {
+		if _, ok := locked[id]; ok && (len(accounts) == 0 || accounts[len(accounts)-1].ID != id) {
+			accounts = append(accounts, r.accounts[id])
+		}
+	}
+
+	return accounts, unlock
+}
+
+func (r *MemoryRepository) CreateInnerTransferTransactionWithLock(
+	_ context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) error {
+	accounts, unlock := r.lock(sender, receiver)
+	defer unlock()
+	if len(accounts) < 2 { // nolint gomnd
+		return generateFirstEntityNotFoundError(accounts, sender, receiver)
+	}
+	tx := &memoryTxn{repo: r, balances: make(map[uuid.UUID]decimal.Decimal)}
+	if err := c(accounts[0], accounts[1], tx); err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+func (r *MemoryRepository) CreateAccountTransactionWithLock(_ context.Context, accountID uuid.UUID, c AccountCallback) error {
+	accounts, unlock := r.lock(accountID)
+	defer unlock()
+	if len(accounts) == 0 {
+		return entityNotFound{accountID}
+	}
+	tx := &memoryTxn{repo: r, balances: make(map[uuid.UUID]decimal.Decimal)}
+	if err := c(accounts[0], tx); err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+// memoryTxn collects changes and applies them on commit, so they are dropped on rollback.
+type memoryTxn struct {
+	repo      *MemoryRepository
+	transfers []Transfer
+	balances  map[uuid.UUID]decimal.Decimal
+	parts     []TransferPart
+	events    []outbox.Event
+}
+
+func (tx *memoryTxn) transferExists(id uuid.UUID) bool {
+	if _, ok := tx.repo.transfers[id]; ok {
+		return true
+	}
+	for _, t := range tx.transfers {
+		if t.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (tx *memoryTxn) CreateTransfer(t Transfer) error {
+	tx.repo.mu.RLock()
+	defer tx.repo.mu.RUnlock()
+	if tx.transferExists(t.ID) {
+		return errMemoryTransferIDUsed
+	}
+	c, ok := tx.repo.currencies[t.CurrencyCode]
+	if !ok {
+		return errMemoryNoCurrency
+	}
+	if !fitsPrecision(t.Amount, c) {
+		return ErrPrecisionChanged
+	}
+	tx.transfers = append(tx.transfers, t)
+
+	return nil
+}
+
+func (tx *memoryTxn) UpdateBalance(accountID uuid.UUID, balance decimal.Decimal) error {
+	tx.repo.mu.RLock()
+	defer tx.repo.mu.RUnlock()
+	if _, ok := tx.repo.accounts[accountID]; !ok {
+		return ErrNoRowsAffected
+	}
+	if balance.IsNegative() {
+		return errMemoryNegativeBalance
+	}
+	tx.balances[accountID] = balance
+
+	return nil
+}
+
+func (tx *memoryTxn) partExists(tp TransferPart) bool {
+	if tx.repo.partKeys[partKey{tp.TransferID, tp.AccountID}] {
+		return true
+	}
+	for _, p := range tx.parts {
+		if p.TransferID == tp.TransferID && p.AccountID == tp.AccountID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (tx *memoryTxn) CreateTransferPart(tp TransferPart) error {
+	tx.repo.mu.RLock()
+	defer tx.repo.mu.RUnlock()
+	if _, ok := tx.repo.accounts[tp.AccountID]; !ok {
+		return entityNotFound{tp.AccountID}
+	}
+	if !tx.transferExists(tp.TransferID) {
+		return entityNotFound{tp.TransferID}
+	}
+	if tx.partExists(tp) {
+		return errMemoryPartUsed
+	}
+	tx.parts = append(tx.parts, tp)
+
+	return nil
+}
+
+func (tx *memoryTxn) CreateEvent(e outbox.Event) error {
+	tx.events = append(tx.events, e)
+
+	return nil
+}
+
+func (tx *memoryTxn) commit() error {
+	r := tx.repo
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range tx.transfers {
+		// transfer of other accounts may be committed concurrently
+		if _, ok := r.transfers[t.ID]; ok {
+			return errMemoryTransferIDUsed
+		}
+		// as well as precision may be changed
+		if !fitsPrecision(t.Amount, r.currencies[t.CurrencyCode]) {
+			return ErrPrecisionChanged
+		}
+	}
+	for _, tp := range tx.parts {
+		if r.partKeys[partKey{tp.TransferID, tp.AccountID}] {
+			return errMemoryPartUsed
+		}
+	}
+	now := r.now()
+	for _, t := range tx.transfers {
+		t.CreatedAt = now
+		r.transfers[t.ID] = t
+	}
+	for id, balance := range tx.balances {
+		a := r.accounts[id]
+		a.Balance, a.UpdatedAt = balance, now
+		r.accounts[id] = a
+	}
+	r.parts = append(r.parts, tx.parts...)
+	for _, tp := range tx.parts {
+		r.partKeys[partKey{tp.TransferID, tp.AccountID}] = true
+	}
+	for _, e := range tx.events {
+		e.ID, e.CreatedAt = int64(len(r.events)+1), now
+		r.events = append(r.events, e)
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) CreateAccount(_ context.Context, a Account) (Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.accounts[a.ID]; ok {
+		return Account{}, errMemoryAccountIDUsed
+	}
+	if _, ok := r.currencies[a.CurrencyCode]; !ok {
+		return Account{}, errMemoryNoCurrency
+	}
+	now := r.now()
+	a = Account{ID: a.ID, CustomerID: a.CustomerID, CurrencyCode: a.CurrencyCode, CreatedAt: now, UpdatedAt: now}
+	r.accounts[a.ID] = a
+
+	return a, nil
+}
+
+func (r *MemoryRepository) SetAccountFrozen(_ context.Context, accountID uuid.UUID, frozen bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.accounts[accountID]
+	if !ok {
+		return false, nil
+	}
+	a.Frozen, a.UpdatedAt = frozen, r.now()
+	r.accounts[accountID] = a
+
+	return true, nil
+}
+
+func (r *MemoryRepository) GetTransfer(_ context.Context, transferID uuid.UUID) (TransferDetails, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.transfers[transferID]
+	if !ok {
+		return TransferDetails{}, false, nil
+	}
+	details := TransferDetails{Transfer: t}
+	for _, tp := range r.parts {
+		if tp.TransferID == transferID {
+			details.Parts = append(details.Parts, tp)
+		}
+	}
+	// outgoing part goes first like in DB
+	sort.SliceStable(details.Parts, func(i, j int) bool { return details.Parts[i].Direction > details.Parts[j].Direction })
+
+	return details, true, nil
+}
+
+func (r *MemoryRepository) GetBalanceMismatches(_ context.Context) ([]BalanceMismatch, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	expected := make(map[uuid.UUID]decimal.Decimal, len(r.accounts))
+	for _, tp := range r.parts {
+		amount := r.transfers[tp.TransferID].Amount
+		if tp.Direction == Outgoing {
+			amount = amount.Neg()
+		}
+		expected[tp.AccountID] = expected[tp.AccountID].Add(amount)
+	}
+	var mismatches []BalanceMismatch
+	for _, a := range r.accounts {
+		if !a.Balance.Equal(expected[a.ID]) {
+			mismatches = append(mismatches, BalanceMismatch{
+				AccountID: a.ID, CurrencyCode: a.CurrencyCode, Balance: a.Balance, Expected: expected[a.ID],
+			})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].AccountID.String() < mismatches[j].AccountID.String()
+	})
+
+	return mismatches, nil
+}
+
+func (r *MemoryRepository) GetCurrencyTotals(_ context.Context) ([]CurrencyTotals, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	totals := make(totalsByCurrency)
+	for _, a := range r.accounts {
+		t := totals.of(a.CurrencyCode)
+		t.Balances = t.Balances.Add(a.Balance)
+	}
+	for _, tr := range r.transfers {
+		switch tr.Type {
+		case Deposit:
+			t := totals.of(tr.CurrencyCode)
+			t.Deposits = t.Deposits.Add(tr.Amount)
+		case Withdraw:
+			t := totals.of(tr.CurrencyCode)
+			t.Withdrawals = t.Withdrawals.Add(tr.Amount)
+		}
+	}
+
+	return totals.sorted(), nil
+}
+
+// accountsBy gives accounts matching filter ordered by updated_at.
+func (r *MemoryRepository) accountsBy(limit uint, filter func(a Account) bool) []Account {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	accounts := make([]Account, 0, limit)
+	for _, a := range r.accounts {
+		if filter(a) {
+			accounts = append(accounts, a)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UpdatedAt.Before(accounts[j].UpdatedAt) })
+	if uint(len(accounts)) > limit {
+		accounts = accounts[:limit]
+	}
+
+	return accounts
+}
+
+func (r *MemoryRepository) GetAccounts(_ context.Context, limit uint) ([]Account, error) {
+	return r.accountsBy(limit, func(Account) bool { return true }), nil
+}
+
+func (r *MemoryRepository) GetCustomerAccounts(_ context.Context, customerID uuid.UUID, limit uint) ([]Account, error) {
+	return r.accountsBy(limit, func(a Account) bool {
+		return a.CustomerID != nil && *a.CustomerID == customerID
+	}), nil
+}
+
+func (r *MemoryRepository) GetAccount(_ context.Context, accountID uuid.UUID) (Account, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	a, ok := r.accounts[accountID]
+
+	return a, ok, nil
+}
+
+func (r *MemoryRepository) GetTransferInfos(_ context.Context, accountID uuid.UUID, limit uint) ([]TransferInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]TransferInfo, 0, limit)
+	// parts are kept in order of commit, so the latest go first
+	for i := len(r.parts) - 1; i >= 0 && uint(len(infos)) < limit; i-- {
+		tp := r.parts[i]
+		if tp.AccountID != accountID {
+			continue
+		}
+		t := r.transfers[tp.TransferID]
+		infos = append(infos, TransferInfo{
+			ID:                     t.ID,
+			AccountID:              tp.AccountID,
+			CorrespondingAccountID: tp.CorrespondingAccountID,
+			Type:                   t.Type,
+			Direction:              tp.Direction,
+			Amount:                 t.Amount,
+			CurrencyCode:           t.CurrencyCode,
+			CreatedAt:              t.CreatedAt,
+		})
+	}
+
+	return infos, nil
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"sort"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/services/dberrors"
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/tracing"
 )
 
 var ErrNoRowsAffected = errors.New("db_no_rows_affected")
@@ -37,44 +42,180 @@ type InnerTransferActions interface {
 	CreateTransfer(transfer Transfer) error
 	UpdateBalance(accountID uuid.UUID, diff decimal.Decimal) error
 	CreateTransferPart(tp TransferPart) error
+	// event is published by outbox relay only if transaction is committed
+	CreateEvent(e outbox.Event) error
 }
 
+// AccountCallback is called with locked account, actions are applied only if it returns no error.
+type AccountCallback func(account Account, a InnerTransferActions) error
+
 type Repository interface {
-	GetPrecision(ctx context.Context, currency string) (precision uint, exists bool, err error)
+	GetCurrency(ctx context.Context, code string) (Currency, bool, error)
+	GetCurrencies(ctx context.Context) ([]Currency, error)
+	// returns false if currency already exists
+	CreateCurrency(ctx context.Context, c Currency) (bool, error)
+	// returns false if currency doesn't exist or some balance in it has more decimal places than precision,
+	// ErrPrecisionTruncatesMandates if amount of some not cancelled mandate has more decimal places than precision
+	UpdateCurrencyPrecision(ctx context.Context, code string, precision uint) (bool, error)
+	// returns false if currency doesn't exist
+	SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (bool, error)
 	// For separation business logic errors from database errors
 	IsTransferIDUsedError(err error) bool
 	IsEntityNotFoundError(uuid uuid.UUID, err error) bool
 	// locks sender and receiver and manipulates data inside db transaction,
 	// return entity not found error if sender or receiver don't exist
 	CreateInnerTransferTransactionWithLock(ctx context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) error
+	// same as above for single account, e.g. for deposit/withdraw
+	CreateAccountTransactionWithLock(ctx context.Context, accountID uuid.UUID, c AccountCallback) error
+	CreateAccount(ctx context.Context, a Account) (Account, error)
+	// returns false if account doesn't exist
+	SetAccountFrozen(ctx context.Context, accountID uuid.UUID, frozen bool) (bool, error)
+	GetTransfer(ctx context.Context, transferID uuid.UUID) (TransferDetails, bool, error)
+	GetBalanceMismatches(ctx context.Context) ([]BalanceMismatch, error)
+	// sums balances and deposit and withdraw transfers of each currency in single snapshot, ordered by currency
+	GetCurrencyTotals(ctx context.Context) ([]CurrencyTotals, error)
 	GetAccounts(ctx context.Context, limit uint) ([]Account, error)
+	GetCustomerAccounts(ctx context.Context, customerID uuid.UUID, limit uint) ([]Account, error)
+	GetAccount(ctx context.Context, accountID uuid.UUID) (Account, bool, error)
 	GetTransferInfos(ctx context.Context, accountID uuid.UUID, limit uint) ([]TransferInfo, error)
 }
 
 func NewRepository(db *sql.DB) Repository {
-	return repository{db}
+	return repository{db: db, replica: db}
+}
+
+// NewRepositoryWithReplica gives Repository reading account lists and transfer history from replica,
+// unless context is marked by WithPrimary. Everything else, including locking transactions, uses db.
+func NewRepositoryWithReplica(db, replica *sql.DB) Repository {
+	return repository{db: db, replica: replica}
 }
 
 type repository struct {
 	db *sql.DB
+	// replica serves list and history queries, it may lag behind db
+	replica *sql.DB
+}
+
+// reader is db to run list and history queries on.
+func (r repository) reader(ctx context.Context) *sql.DB {
+	if readsPrimary(ctx) {
+		return r.db
+	}
+
+	return r.replica
+}
+
+type primaryKey struct{}
+
+// WithPrimary makes repository read from primary within ctx, so reads see preceding writes
+// instead of possibly stale replica.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
 }
 
-func (r repository) GetPrecision(ctx context.Context, currencyCode string) (uint, bool, error) {
-	var precision uint
+func readsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
 
-	row := r.db.QueryRowContext(ctx, `SELECT precision FROM currencies WHERE code=$1;`, currencyCode)
-	switch err := row.Scan(&precision); err {
+	return primary
+}
+
+func (r repository) GetCurrency(ctx context.Context, code string) (Currency, bool, error) {
+	c := Currency{Code: code}
+
+	row := r.db.QueryRowContext(ctx, `SELECT precision, disabled FROM currencies WHERE code=$1;`, code)
+	switch err := row.Scan(&c.Precision, &c.Disabled); err {
 	case sql.ErrNoRows:
-		return 0, false, nil
+		return Currency{}, false, nil
 	case nil:
-		return precision, true, nil
+		return c, true, nil
 	default:
-		return 0, false, err
+		return Currency{}, false, err
 	}
 }
 
+func (r repository) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT code, precision, disabled FROM currencies ORDER BY code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var currencies []Currency
+	for rows.Next() {
+		var c Currency
+		if err := rows.Scan(&c.Code, &c.Precision, &c.Disabled); err != nil {
+			return nil, err
+		}
+		currencies = append(currencies, c)
+	}
+
+	return currencies, rows.Err()
+}
+
+// affected reports whether statement changed some row.
+func affected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	switch err = validateAffected(res); err {
+	case ErrNoRowsAffected:
+		return false, nil
+	case nil:
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+func (r repository) CreateCurrency(ctx context.Context, c Currency) (bool, error) {
+	return affected(r.db.ExecContext(ctx, `
+INSERT INTO currencies(code, precision, disabled)
+VALUES ($1, $2, $3)
+ON CONFLICT (code) DO NOTHING`, c.Code, c.Precision, c.Disabled))
+}
+
+// UpdateCurrencyPrecision locks currency row exclusively, so it waits for transfers in the currency
+// being in progress and blocks new ones till the precision is changed.
+func (r repository) UpdateCurrencyPrecision(ctx context.Context, code string, precision uint) (updated bool, err error) {
+	err = r.inTransaction(ctx, "transfers.UpdateCurrencyPrecision", func(ctx context.Context, tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT true FROM currencies WHERE code = $1 FOR UPDATE`, code).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var truncates bool
+		err = tx.QueryRowContext(ctx, `
+SELECT EXISTS (
+	SELECT 1 FROM mandates
+	WHERE currency_code = $1 AND status <> 'CANCELLED' AND amount <> round(amount, $2)
+)`, code, precision).Scan(&truncates)
+		if err != nil {
+			return err
+		}
+		if truncates {
+			return ErrPrecisionTruncatesMandates
+		}
+		updated, err = affected(tx.ExecContext(ctx, `
+UPDATE currencies
+SET precision = $2
+WHERE code = $1 AND NOT EXISTS (
+	SELECT 1 FROM accounts WHERE currency_code = $1 AND balance <> round(balance, $2)
+)`, code, precision))
+
+		return err
+	})
+
+	return updated, err
+}
+
+func (r repository) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (bool, error) {
+	return affected(r.db.ExecContext(ctx, `UPDATE currencies SET disabled = $2 WHERE code = $1`, code, disabled))
+}
+
 func (r repository) IsTransferIDUsedError(err error) bool {
-	return err != nil && err.Error() == `pq: duplicate key value violates unique constraint "transfers_pkey"`
+	return dberrors.IsUniqueViolation(err, "transfers_pkey")
 }
 
 func (r repository) IsEntityNotFoundError(id uuid.UUID, err error) bool {
@@ -91,22 +232,37 @@ type innerTransferTxn struct {
 	ctx  context.Context
 }
 
-func (tx innerTransferTxn) CreateTransfer(t Transfer) error {
-	res, err := tx.dbTx.ExecContext(tx.ctx, `
-INSERT INTO transfers(id, type, amount, currency_code)
- VALUES ($1, $2, $3, $4)`, t.ID, t.Type, t.Amount, t.CurrencyCode)
+// CreateTransfer shares lock of currency row till end of tx, so precision can't be changed
+// concurrently (see UpdateCurrencyPrecision), and checks amount against its current precision
+// as the one used for rounding by service may be already outdated.
+func (tx innerTransferTxn) CreateTransfer(t Transfer) (err error) {
+	const query = `
+INSERT INTO transfers(id, type, amount, currency_code, reason)
+SELECT $1::uuid, $2::transfer_type, $3::numeric, code, $5::text
+FROM currencies
+WHERE code = $4 AND $3::numeric = round($3::numeric, precision)
+FOR KEY SHARE`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.PostgreSQL, "INSERT transfers", query)
+	defer func() { tracing.End(span, err) }()
+	res, err := tx.dbTx.ExecContext(ctx, query, t.ID, t.Type, t.Amount, t.CurrencyCode, t.Reason)
 	if err != nil {
 		return err
 	}
+	if err = validateAffected(res); err == ErrNoRowsAffected {
+		return ErrPrecisionChanged
+	}
 
-	return validateAffected(res)
+	return err
 }
 
-func (tx innerTransferTxn) UpdateBalance(accountID uuid.UUID, balance decimal.Decimal) error {
-	res, err := tx.dbTx.ExecContext(tx.ctx, `
+func (tx innerTransferTxn) UpdateBalance(accountID uuid.UUID, balance decimal.Decimal) (err error) {
+	const query = `
 UPDATE accounts
 SET balance = $1, updated_at = now()
-WHERE id = $2`, balance, accountID)
+WHERE id = $2`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.PostgreSQL, "UPDATE accounts", query)
+	defer func() { tracing.End(span, err) }()
+	res, err := tx.dbTx.ExecContext(ctx, query, balance, accountID)
 	if err != nil {
 		return err
 	}
@@ -114,14 +270,21 @@ WHERE id = $2`, balance, accountID)
 	return validateAffected(res)
 }
 
-func (tx innerTransferTxn) CreateTransferPart(tp TransferPart) error {
-	_, err := tx.dbTx.ExecContext(tx.ctx, `
+func (tx innerTransferTxn) CreateTransferPart(tp TransferPart) (err error) {
+	const query = `
 INSERT INTO transfer_parts(transfer_id, account_id, corresponding_account_id, direction)
- VALUES ($1, $2, $3, $4)`, tp.TransferID, tp.AccountID, tp.CorrespondingAccountID, tp.Direction)
+ VALUES ($1, $2, $3, $4)`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.PostgreSQL, "INSERT transfer_parts", query)
+	defer func() { tracing.End(span, err) }()
+	_, err = tx.dbTx.ExecContext(ctx, query, tp.TransferID, tp.AccountID, tp.CorrespondingAccountID, tp.Direction)
 
 	return err
 }
 
+func (tx innerTransferTxn) CreateEvent(e outbox.Event) error {
+	return outbox.Write(tx.ctx, tx.dbTx, e)
+}
+
 func generateFirstEntityNotFoundError(accounts []Account, ids ...uuid.UUID) error {
 	for _, id := range ids {
 		var found bool
@@ -141,11 +304,46 @@ func generateFirstEntityNotFoundError(accounts []Account, ids ...uuid.UUID) erro
 }
 
 func (r repository) CreateInnerTransferTransactionWithLock(
-	ctx context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) (err error) {
-	var rows *sql.Rows
+	ctx context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) error {
+	return r.inTransaction(ctx, "transfers.CreateInnerTransferTransactionWithLock",
+		func(ctx context.Context, tx *sql.Tx) error {
+			accounts, err := lockAccounts(ctx, tx, sender, receiver)
+			if err != nil {
+				return err
+			}
+			if len(accounts) < 2 { // nolint gomnd
+				return generateFirstEntityNotFoundError(accounts, sender, receiver)
+			}
+
+			return c(accounts[0], accounts[1], innerTransferTxn{dbTx: tx, ctx: ctx})
+		})
+}
+
+func (r repository) CreateAccountTransactionWithLock(ctx context.Context, accountID uuid.UUID, c AccountCallback) error {
+	return r.inTransaction(ctx, "transfers.CreateAccountTransactionWithLock",
+		func(ctx context.Context, tx *sql.Tx) error {
+			accounts, err := lockAccounts(ctx, tx, accountID, accountID)
+			if err != nil {
+				return err
+			}
+			if len(accounts) == 0 {
+				return entityNotFound{accountID}
+			}
+
+			return c(accounts[0], innerTransferTxn{dbTx: tx, ctx: ctx})
+		})
+}
+
+// inTransaction commits tx if f succeeds and rolls it back otherwise.
+func (r repository) inTransaction(ctx context.Context, name string, f func(ctx context.Context, tx *sql.Tx) error) (err error) {
 	var tx *sql.Tx
 
+	ctx, span := tracing.Start(ctx, name)
+	defer func() { tracing.End(span, err) }()
+
+	_, beginSpan := tracing.StartDB(ctx, tracing.PostgreSQL, "BEGIN", "BEGIN")
 	tx, err = r.db.BeginTx(ctx, nil)
+	tracing.End(beginSpan, err)
 	if err != nil {
 		return
 	}
@@ -155,60 +353,72 @@ func (r repository) CreateInnerTransferTransactionWithLock(
 			panic(p)
 		}
 		if err != nil {
-			_ = tx.Rollback()
+			_, rollbackSpan := tracing.StartDB(ctx, tracing.PostgreSQL, "ROLLBACK", "ROLLBACK")
+			tracing.End(rollbackSpan, tx.Rollback())
 		} else {
+			_, commitSpan := tracing.StartDB(ctx, tracing.PostgreSQL, "COMMIT", "COMMIT")
 			err = tx.Commit()
+			tracing.End(commitSpan, err)
 		}
 	}()
 
-	rows, err = tx.QueryContext(ctx, `
-		SELECT id, currency_code, balance, created_at, updated_at FROM accounts 
-		WHERE id in ($1, $2) ORDER BY 
-		CASE
-			WHEN id=$1 THEN 1
-			ELSE 2
-	  	END
+	return f(ctx, tx)
+}
+
+// rows are locked in order of ids, so transfers between the same accounts in opposite directions don't deadlock
+const lockAccountsQuery = `
+		SELECT ` + accountColumns + ` FROM accounts 
+		WHERE id in ($1, $2) ORDER BY id
 		FOR NO KEY UPDATE 
-	`, sender, receiver)
+	`
+
+// lockAccounts gives sender and receiver in order (or lower if some doesn't exist) locked till end of tx,
+// the same id may be given twice to lock single account.
+func lockAccounts(ctx context.Context, tx *sql.Tx, sender, receiver uuid.UUID) (accounts []Account, err error) {
+	ctx, span := tracing.StartDB(ctx, tracing.PostgreSQL, "SELECT accounts FOR NO KEY UPDATE", lockAccountsQuery)
+	defer func() { tracing.End(span, err) }()
+	rows, err := tx.QueryContext(ctx, lockAccountsQuery, sender, receiver)
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer rows.Close()
-	accounts := make([]Account, 0, 2) // expected 2 accounts or lower
+	accounts = make([]Account, 0, 2) // expected 2 accounts or lower
 	for rows.Next() {
-		var a Account
-		err = rows.Scan(&a.ID, &a.CurrencyCode, &a.Balance, &a.CreatedAt, &a.UpdatedAt)
+		a, err := scanAccount(rows)
 		if err != nil {
-			return
+			return nil, err
 		}
 		accounts = append(accounts, a)
 	}
-
-	if err = rows.Err(); err != nil {
-		return err
+	if len(accounts) == 2 && accounts[0].ID != sender { // nolint gomnd
+		accounts[0], accounts[1] = accounts[1], accounts[0]
 	}
-	if len(accounts) < 2 { // nolint gomnd
-		err = generateFirstEntityNotFoundError(accounts, sender, receiver)
 
-		return
-	}
-	err = c(accounts[0], accounts[1], innerTransferTxn{dbTx: tx, ctx: ctx})
+	return accounts, rows.Err()
+}
 
-	return err
+const accountColumns = `id, customer_id, currency_code, balance, frozen, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (r repository) GetAccounts(ctx context.Context, limit uint) ([]Account, error) {
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, currency_code, balance, created_at, updated_at FROM accounts ORDER BY updated_at LIMIT $1
-	`, limit)
+func scanAccount(s scanner) (Account, error) {
+	var a Account
+	err := s.Scan(&a.ID, &a.CustomerID, &a.CurrencyCode, &a.Balance, &a.Frozen, &a.CreatedAt, &a.UpdatedAt)
+
+	return a, err
+}
+
+func (r repository) queryAccounts(ctx context.Context, limit uint, query string, args ...interface{}) ([]Account, error) {
+	rows, err := r.reader(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	accounts := make([]Account, 0, limit)
-	var a Account
 	for rows.Next() {
-		err := rows.Scan(&a.ID, &a.CurrencyCode, &a.Balance, &a.CreatedAt, &a.UpdatedAt)
+		a, err := scanAccount(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -218,9 +428,110 @@ func (r repository) GetAccounts(ctx context.Context, limit uint) ([]Account, err
 	return accounts, rows.Err()
 }
 
+// totalsByCurrency accumulates CurrencyTotals.
+type totalsByCurrency map[string]*CurrencyTotals
+
+func (m totalsByCurrency) of(code string) *CurrencyTotals {
+	t, ok := m[code]
+	if !ok {
+		t = &CurrencyTotals{CurrencyCode: code}
+		m[code] = t
+	}
+
+	return t
+}
+
+func (m totalsByCurrency) sorted() []CurrencyTotals {
+	totals := make([]CurrencyTotals, 0, len(m))
+	for _, t := range m {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].CurrencyCode < totals[j].CurrencyCode })
+
+	return totals
+}
+
+// GetCurrencyTotals reads balances and transfers in REPEATABLE READ transaction,
+// so transfers committed between the two queries aren't seen by either of them.
+func (r repository) GetCurrencyTotals(ctx context.Context) ([]CurrencyTotals, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }() // nothing to commit
+	totals := make(totalsByCurrency)
+	rows, err := tx.QueryContext(ctx, `SELECT currency_code, SUM(balance) FROM accounts GROUP BY currency_code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var balances decimal.Decimal
+		if err := rows.Scan(&code, &balances); err != nil {
+			return nil, err
+		}
+		totals.of(code).Balances = balances
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+	rows, err = tx.QueryContext(ctx, `
+SELECT currency_code,
+	COALESCE(SUM(amount) FILTER (WHERE type = 'DEPOSIT'), 0),
+	COALESCE(SUM(amount) FILTER (WHERE type = 'WITHDRAW'), 0)
+FROM transfers GROUP BY currency_code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var deposits, withdrawals decimal.Decimal
+		if err := rows.Scan(&code, &deposits, &withdrawals); err != nil {
+			return nil, err
+		}
+		t := totals.of(code)
+		t.Deposits, t.Withdrawals = deposits, withdrawals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals.sorted(), nil
+}
+
+func (r repository) GetAccounts(ctx context.Context, limit uint) ([]Account, error) {
+	return r.queryAccounts(ctx, limit, `
+		SELECT `+accountColumns+` FROM accounts ORDER BY updated_at LIMIT $1
+	`, limit)
+}
+
+func (r repository) GetCustomerAccounts(ctx context.Context, customerID uuid.UUID, limit uint) ([]Account, error) {
+	return r.queryAccounts(ctx, limit, `
+		SELECT `+accountColumns+` FROM accounts WHERE customer_id = $1 ORDER BY updated_at LIMIT $2
+	`, customerID, limit)
+}
+
+func (r repository) GetAccount(ctx context.Context, accountID uuid.UUID) (Account, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+accountColumns+` FROM accounts WHERE id = $1`, accountID)
+	a, err := scanAccount(row)
+	switch err {
+	case sql.ErrNoRows:
+		return a, false, nil
+	case nil:
+		return a, true, nil
+	default:
+		return a, false, err
+	}
+}
+
+const transferInfoColumns = `t.id, tp.account_id, tp.corresponding_account_id, t.type, tp.direction, t.currency_code, t.amount, t.created_at`
+
 func (r repository) GetTransferInfos(ctx context.Context, accountID uuid.UUID, limit uint) ([]TransferInfo, error) {
-	rows, err := r.db.QueryContext(ctx, `
-SELECT t.id, tp.account_id, tp.corresponding_account_id, t.type, tp.direction, t.currency_code, t.amount, t.created_at
+	rows, err := r.reader(ctx).QueryContext(ctx, `
+SELECT `+transferInfoColumns+`
 FROM transfer_parts as tp
 INNER JOIN transfers as t ON tp.transfer_id = t.id
 WHERE tp.account_id = $1 ORDER BY t.created_at DESC
@@ -228,6 +539,11 @@ LIMIT $2`, accountID, limit)
 	if err != nil {
 		return nil, err
 	}
+
+	return scanTransferInfos(rows, limit)
+}
+
+func scanTransferInfos(rows *sql.Rows, limit uint) ([]TransferInfo, error) {
 	defer rows.Close()
 	transferInfos := make([]TransferInfo, 0, limit)
 	var ti TransferInfo
@@ -243,3 +559,75 @@ LIMIT $2`, accountID, limit)
 
 	return transferInfos, rows.Err()
 }
+
+func (r repository) CreateAccount(ctx context.Context, a Account) (Account, error) {
+	row := r.db.QueryRowContext(ctx, `
+INSERT INTO accounts(id, customer_id, currency_code)
+VALUES ($1, $2, $3)
+RETURNING `+accountColumns, a.ID, a.CustomerID, a.CurrencyCode)
+
+	return scanAccount(row)
+}
+
+func (r repository) SetAccountFrozen(ctx context.Context, accountID uuid.UUID, frozen bool) (bool, error) {
+	return affected(r.db.ExecContext(ctx, `
+UPDATE accounts
+SET frozen = $1, updated_at = now()
+WHERE id = $2`, frozen, accountID))
+}
+
+func (r repository) GetTransfer(ctx context.Context, transferID uuid.UUID) (TransferDetails, bool, error) {
+	var t TransferDetails
+	row := r.db.QueryRowContext(ctx, `
+SELECT id, type, amount, currency_code, reason, created_at FROM transfers WHERE id = $1`, transferID)
+	switch err := row.Scan(&t.ID, &t.Type, &t.Amount, &t.CurrencyCode, &t.Reason, &t.CreatedAt); err {
+	case sql.ErrNoRows:
+		return t, false, nil
+	case nil:
+	default:
+		return t, false, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+SELECT transfer_id, account_id, corresponding_account_id, direction
+FROM transfer_parts WHERE transfer_id = $1 ORDER BY direction DESC`, transferID)
+	if err != nil {
+		return t, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tp TransferPart
+		if err := rows.Scan(&tp.TransferID, &tp.AccountID, &tp.CorrespondingAccountID, &tp.Direction); err != nil {
+			return t, false, err
+		}
+		t.Parts = append(t.Parts, tp)
+	}
+
+	return t, true, rows.Err()
+}
+
+func (r repository) GetBalanceMismatches(ctx context.Context) ([]BalanceMismatch, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT a.id, a.currency_code, a.balance, COALESCE(SUM(
+	CASE WHEN tp.direction = 'INCOMING' THEN t.amount ELSE -t.amount END
+), 0) AS expected
+FROM accounts AS a
+LEFT JOIN transfer_parts AS tp ON tp.account_id = a.id
+LEFT JOIN transfers AS t ON t.id = tp.transfer_id
+GROUP BY a.id
+HAVING a.balance <> COALESCE(SUM(CASE WHEN tp.direction = 'INCOMING' THEN t.amount ELSE -t.amount END), 0)
+ORDER BY a.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mismatches []BalanceMismatch
+	for rows.Next() {
+		var m BalanceMismatch
+		if err := rows.Scan(&m.AccountID, &m.CurrencyCode, &m.Balance, &m.Expected); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+
+	return mismatches, rows.Err()
+}
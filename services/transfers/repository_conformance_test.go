@@ -0,0 +1,517 @@
+package transfers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/migrations"
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+// TestDBEnv has DSN of Postgres database the conformance suite is run against besides memory and sqlite ones,
+// migrations are applied to it and test data is left there.
+const TestDBEnv = "TEST_DB"
+
+func TestRepositoryConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testRepository(t, NewMemoryRepository())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		testRepository(t, newSQLiteTestRepository(t))
+	})
+	for _, driver := range []string{migrations.Postgres, migrations.Pgx} {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			dsn := os.Getenv(TestDBEnv)
+			if dsn == "" {
+				t.Skipf("%s isn't set", TestDBEnv)
+			}
+			db, err := migrations.Open(driver, dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, err = migrations.Set.Exec(db, migrations.Dialect, migrations.Source, migrate.Up); err != nil {
+				t.Fatal(err)
+			}
+			testRepository(t, NewRepository(db))
+		})
+	}
+}
+
+// newSQLiteTestRepository gives repository of migrated sqlite file, which is removed after test.
+func newSQLiteTestRepository(t testing.TB) Repository {
+	db, err := migrations.Open(migrations.SQLite, filepath.Join(t.TempDir(), "wallet.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err = migrations.Set.Exec(db, migrations.SQLiteDialect, migrations.SQLiteSource, migrate.Up); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLiteRepository(db)
+}
+
+// testRepository checks behaviour every Repository implementation must have,
+// repo may contain data of other tests.
+func testRepository(t *testing.T, repo Repository) {
+	t.Run("Currencies", func(t *testing.T) { testRepositoryCurrencies(t, repo) })
+	t.Run("Accounts", func(t *testing.T) { testRepositoryAccounts(t, repo) })
+	t.Run("Commit", func(t *testing.T) { testRepositoryCommit(t, repo) })
+	t.Run("Rollback", func(t *testing.T) { testRepositoryRollback(t, repo) })
+	t.Run("TransferIDUsed", func(t *testing.T) { testRepositoryTransferIDUsed(t, repo) })
+	t.Run("TransferPartUsed", func(t *testing.T) { testRepositoryTransferPartUsed(t, repo) })
+	t.Run("EntityNotFound", func(t *testing.T) { testRepositoryEntityNotFound(t, repo) })
+	t.Run("NegativeBalance", func(t *testing.T) { testRepositoryNegativeBalance(t, repo) })
+	t.Run("Precision", func(t *testing.T) { testRepositoryPrecision(t, repo) })
+	t.Run("PrecisionChanged", func(t *testing.T) { testRepositoryPrecisionChanged(t, repo) })
+	t.Run("ConcurrentTransfers", func(t *testing.T) { testRepositoryConcurrentTransfers(t, repo) })
+	t.Run("CurrencyTotals", func(t *testing.T) { testRepositoryCurrencyTotals(t, repo) })
+}
+
+func randomCurrencyCode() string {
+	code := []byte("Z")
+	for i := 0; i < 3; i++ {
+		code = append(code, byte('A'+rand.Intn(26))) // nolint gosec
+	}
+
+	return string(code)
+}
+
+// createFundedAccount creates account with balance deposited by transfer, so balance is consistent.
+func createFundedAccount(t testing.TB, repo Repository, currency, balance string) Account {
+	ctx := context.Background()
+	a, err := repo.CreateAccount(ctx, Account{ID: uuid.New(), CurrencyCode: currency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount := decimal.RequireFromString(balance)
+	if amount.IsZero() {
+		return a
+	}
+	id := uuid.New()
+	err = repo.CreateAccountTransactionWithLock(ctx, a.ID, func(account Account, tx InnerTransferActions) error {
+		if err := tx.CreateTransfer(Transfer{ID: id, Type: Deposit, Amount: amount, CurrencyCode: currency}); err != nil {
+			return err
+		}
+		if err := tx.UpdateBalance(account.ID, account.Balance.Add(amount)); err != nil {
+			return err
+		}
+
+		return tx.CreateTransferPart(TransferPart{TransferID: id, AccountID: account.ID, Direction: Incoming})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Balance = amount
+
+	return a
+}
+
+// transfer is callback of plain inner transfer.
+func transfer(id uuid.UUID, amount decimal.Decimal) InnerTransferCallback {
+	return func(sender, receiver Account, tx InnerTransferActions) error {
+		err := tx.CreateTransfer(Transfer{ID: id, Type: Internal, Amount: amount, CurrencyCode: sender.CurrencyCode})
+		if err != nil {
+			return err
+		}
+		if err = tx.UpdateBalance(sender.ID, sender.Balance.Sub(amount)); err != nil {
+			return err
+		}
+		if err = tx.UpdateBalance(receiver.ID, receiver.Balance.Add(amount)); err != nil {
+			return err
+		}
+		err = tx.CreateTransferPart(TransferPart{
+			TransferID: id, AccountID: sender.ID, CorrespondingAccountID: &receiver.ID, Direction: Outgoing})
+		if err != nil {
+			return err
+		}
+
+		return tx.CreateTransferPart(TransferPart{
+			TransferID: id, AccountID: receiver.ID, CorrespondingAccountID: &sender.ID, Direction: Incoming})
+	}
+}
+
+func balanceOf(t *testing.T, repo Repository, id uuid.UUID) string {
+	a, ok, err := repo.GetAccount(context.Background(), id)
+	if err != nil || !ok {
+		t.Fatal("account isn't found", err)
+	}
+
+	return a.Balance.String()
+}
+
+func testRepositoryCurrencies(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	c, ok, err := repo.GetCurrency(ctx, "USD")
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(Currency{Code: "USD", Precision: 2}, c)
+	_, ok, err = repo.GetCurrency(ctx, "ZZZZZ")
+	a.NoError(err)
+	a.False(ok)
+
+	code := randomCurrencyCode()
+	created, err := repo.CreateCurrency(ctx, Currency{Code: code, Precision: 4})
+	a.NoError(err)
+	a.True(created)
+	created, err = repo.CreateCurrency(ctx, Currency{Code: code, Precision: 2})
+	a.NoError(err)
+	a.False(created, "existing one isn't changed")
+	list, err := repo.GetCurrencies(ctx)
+	a.NoError(err)
+	a.Contains(list, Currency{Code: code, Precision: 4})
+
+	createFundedAccount(t, repo, code, "1.5")
+	updated, err := repo.UpdateCurrencyPrecision(ctx, code, 0)
+	a.NoError(err)
+	a.False(updated, "balance would be truncated")
+	updated, err = repo.UpdateCurrencyPrecision(ctx, code, 1)
+	a.NoError(err)
+	a.True(updated)
+
+	updated, err = repo.SetCurrencyDisabled(ctx, code, true)
+	a.NoError(err)
+	a.True(updated)
+	c, _, _ = repo.GetCurrency(ctx, code)
+	a.Equal(Currency{Code: code, Precision: 1, Disabled: true}, c)
+	updated, err = repo.SetCurrencyDisabled(ctx, "ZZZZZ", true)
+	a.NoError(err)
+	a.False(updated)
+}
+
+func testRepositoryAccounts(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	id := uuid.New()
+	account, err := repo.CreateAccount(ctx, Account{ID: id, CurrencyCode: "EUR"})
+	a.NoError(err)
+	a.Equal(id, account.ID)
+	a.True(account.Balance.IsZero())
+	a.False(account.CreatedAt.IsZero())
+	_, err = repo.CreateAccount(ctx, Account{ID: id, CurrencyCode: "EUR"})
+	a.Error(err, "id is unique")
+	_, err = repo.CreateAccount(ctx, Account{ID: uuid.New(), CurrencyCode: "ZZZZZ"})
+	a.Error(err, "currency must exist")
+
+	ok, err := repo.SetAccountFrozen(ctx, id, true)
+	a.NoError(err)
+	a.True(ok)
+	got, ok, err := repo.GetAccount(ctx, id)
+	a.NoError(err)
+	a.True(ok)
+	a.True(got.Frozen)
+	ok, err = repo.SetAccountFrozen(ctx, uuid.New(), true)
+	a.NoError(err)
+	a.False(ok)
+	_, ok, err = repo.GetAccount(ctx, uuid.New())
+	a.NoError(err)
+	a.False(ok)
+
+	accounts, err := repo.GetAccounts(ctx, 1)
+	a.NoError(err)
+	a.Len(accounts, 1, "limit is applied")
+}
+
+func testRepositoryCommit(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	sender := createFundedAccount(t, repo, "USD", "10")
+	receiver := createFundedAccount(t, repo, "USD", "1")
+
+	id := uuid.New()
+	event, _ := outbox.NewEvent(TransferCreated, struct{}{}, sender.ID, receiver.ID)
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		func(s, r Account, tx InnerTransferActions) error {
+			a.Equal(sender.ID, s.ID, "sender goes first")
+			a.Equal(receiver.ID, r.ID)
+			a.Equal("10", s.Balance.String())
+			if err := transfer(id, decimal.RequireFromString("2.5"))(s, r, tx); err != nil {
+				return err
+			}
+
+			return tx.CreateEvent(event)
+		})
+	a.NoError(err)
+	a.Equal("7.5", balanceOf(t, repo, sender.ID))
+	a.Equal("3.5", balanceOf(t, repo, receiver.ID))
+
+	details, ok, err := repo.GetTransfer(ctx, id)
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(Internal, details.Type)
+	a.Equal("2.5", details.Amount.String())
+	a.Nil(details.Reason)
+	a.Len(details.Parts, 2)
+	a.Equal(Outgoing, details.Parts[0].Direction, "outgoing part goes first")
+	a.Equal(sender.ID, details.Parts[0].AccountID)
+	_, ok, err = repo.GetTransfer(ctx, uuid.New())
+	a.NoError(err)
+	a.False(ok)
+
+	infos, err := repo.GetTransferInfos(ctx, sender.ID, 100)
+	a.NoError(err)
+	a.Len(infos, 2)
+	a.Equal(id, infos[0].ID, "the latest goes first")
+	a.Equal(Outgoing, infos[0].Direction)
+	a.Equal(receiver.ID, *infos[0].CorrespondingAccountID)
+	infos, err = repo.GetTransferInfos(ctx, sender.ID, 1)
+	a.NoError(err)
+	a.Len(infos, 1, "limit is applied")
+
+	mismatches, err := repo.GetBalanceMismatches(ctx)
+	a.NoError(err)
+	for _, m := range mismatches {
+		a.NotEqual(sender.ID, m.AccountID, "balance is consistent")
+		a.NotEqual(receiver.ID, m.AccountID, "balance is consistent")
+	}
+}
+
+func testRepositoryRollback(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	sender := createFundedAccount(t, repo, "USD", "10")
+	receiver := createFundedAccount(t, repo, "USD", "0")
+
+	id := uuid.New()
+	failure := errors.New("failure")
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		func(s, r Account, tx InnerTransferActions) error {
+			if err := transfer(id, decimal.RequireFromString("5"))(s, r, tx); err != nil {
+				return err
+			}
+
+			return failure
+		})
+	a.Equal(failure, err)
+	a.Equal("10", balanceOf(t, repo, sender.ID), "changes are rolled back")
+	a.Equal("0", balanceOf(t, repo, receiver.ID), "changes are rolled back")
+	_, ok, err := repo.GetTransfer(ctx, id)
+	a.NoError(err)
+	a.False(ok, "transfer is rolled back")
+
+	a.NoError(repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(id, decimal.RequireFromString("5"))), "id may be used after rollback")
+}
+
+func testRepositoryTransferIDUsed(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	sender := createFundedAccount(t, repo, "USD", "10")
+	receiver := createFundedAccount(t, repo, "USD", "0")
+	other := createFundedAccount(t, repo, "USD", "0")
+
+	id := uuid.New()
+	a.NoError(repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(id, decimal.RequireFromString("1"))))
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, other.ID,
+		transfer(id, decimal.RequireFromString("1")))
+	a.True(repo.IsTransferIDUsedError(err), "unexpected error %v", err)
+	a.False(repo.IsTransferIDUsedError(errors.New("other")))
+	a.False(repo.IsTransferIDUsedError(nil))
+	a.Equal("9", balanceOf(t, repo, sender.ID))
+	a.Equal("0", balanceOf(t, repo, other.ID))
+}
+
+func testRepositoryTransferPartUsed(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	sender := createFundedAccount(t, repo, "USD", "10")
+	receiver := createFundedAccount(t, repo, "USD", "0")
+
+	id := uuid.New()
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		func(s, r Account, tx InnerTransferActions) error {
+			if err := transfer(id, decimal.RequireFromString("1"))(s, r, tx); err != nil {
+				return err
+			}
+
+			return tx.CreateTransferPart(TransferPart{TransferID: id, AccountID: r.ID, Direction: Incoming})
+		})
+	a.Error(err, "part of the same transfer and account is created once")
+	a.Equal("10", balanceOf(t, repo, sender.ID), "changes are rolled back")
+	_, ok, err := repo.GetTransfer(ctx, id)
+	a.NoError(err)
+	a.False(ok, "transfer is rolled back")
+
+	a.NoError(repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(id, decimal.RequireFromString("1"))))
+	err = repo.CreateAccountTransactionWithLock(ctx, receiver.ID, func(r Account, tx InnerTransferActions) error {
+		return tx.CreateTransferPart(TransferPart{TransferID: id, AccountID: r.ID, Direction: Incoming})
+	})
+	a.Error(err, "part of committed transfer is created once")
+	details, ok, err := repo.GetTransfer(ctx, id)
+	a.NoError(err)
+	a.True(ok)
+	a.Len(details.Parts, 2)
+}
+
+func testRepositoryEntityNotFound(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	existing := createFundedAccount(t, repo, "USD", "10")
+	missing := uuid.New()
+	called := false
+	callback := func(s, r Account, tx InnerTransferActions) error {
+		called = true
+
+		return nil
+	}
+
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, missing, existing.ID, callback)
+	a.True(repo.IsEntityNotFoundError(missing, err), "unexpected error %v", err)
+	a.False(repo.IsEntityNotFoundError(existing.ID, err))
+	err = repo.CreateInnerTransferTransactionWithLock(ctx, existing.ID, missing, callback)
+	a.True(repo.IsEntityNotFoundError(missing, err), "unexpected error %v", err)
+	err = repo.CreateAccountTransactionWithLock(ctx, missing, func(Account, InnerTransferActions) error {
+		called = true
+
+		return nil
+	})
+	a.True(repo.IsEntityNotFoundError(missing, err), "unexpected error %v", err)
+	a.False(called, "callback isn't called")
+	a.False(repo.IsEntityNotFoundError(uuid.Nil, err))
+}
+
+func testRepositoryNegativeBalance(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	sender := createFundedAccount(t, repo, "USD", "1")
+	receiver := createFundedAccount(t, repo, "USD", "0")
+
+	err := repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(uuid.New(), decimal.RequireFromString("2")))
+	a.Error(err, "balance can't be negative")
+	a.Equal("1", balanceOf(t, repo, sender.ID))
+	a.Equal("0", balanceOf(t, repo, receiver.ID))
+}
+
+func testRepositoryPrecision(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	code := randomCurrencyCode()
+	_, err := repo.CreateCurrency(ctx, Currency{Code: code, Precision: MaxPrecision})
+	a.NoError(err)
+	sender := createFundedAccount(t, repo, code, "123456789012345678.123456789012345678")
+	receiver := createFundedAccount(t, repo, code, "0.000000000000000001")
+
+	a.NoError(repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(uuid.New(), decimal.RequireFromString("0.000000000000000001"))))
+	a.Equal("123456789012345678.123456789012345677", balanceOf(t, repo, sender.ID), "decimals aren't rounded")
+	a.Equal("0.000000000000000002", balanceOf(t, repo, receiver.ID), "decimals aren't rounded")
+	mismatches, err := repo.GetBalanceMismatches(ctx)
+	a.NoError(err)
+	for _, m := range mismatches {
+		a.NotEqual(sender.ID, m.AccountID, "balance is consistent")
+	}
+}
+
+func testRepositoryPrecisionChanged(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	code := randomCurrencyCode()
+	_, err := repo.CreateCurrency(ctx, Currency{Code: code, Precision: 4})
+	a.NoError(err)
+	sender := createFundedAccount(t, repo, code, "1")
+	receiver := createFundedAccount(t, repo, code, "0")
+	// amount is rounded by service to precision which is lowered before transfer is created
+	updated, err := repo.UpdateCurrencyPrecision(ctx, code, 2)
+	a.NoError(err)
+	a.True(updated)
+
+	err = repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(uuid.New(), decimal.RequireFromString("0.005")))
+	a.Equal(ErrPrecisionChanged, err)
+	a.Equal("1", balanceOf(t, repo, sender.ID))
+	a.Equal("0", balanceOf(t, repo, receiver.ID))
+}
+
+// testRepositoryConcurrentTransfers moves money back and forth, so transactions lock
+// the same accounts in opposite order and mustn't lose updates or deadlock.
+func testRepositoryConcurrentTransfers(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	first := createFundedAccount(t, repo, "USD", "100")
+	second := createFundedAccount(t, repo, "USD", "100")
+	amount := decimal.RequireFromString("1")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		sender, receiver := first.ID, second.ID
+		if i%2 == 1 {
+			sender, receiver = receiver, sender
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.CreateInnerTransferTransactionWithLock(ctx, sender, receiver, transfer(uuid.New(), amount))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		a.NoError(err)
+	}
+	a.Equal("100", balanceOf(t, repo, first.ID))
+	a.Equal("100", balanceOf(t, repo, second.ID))
+	infos, err := repo.GetTransferInfos(ctx, first.ID, 100)
+	a.NoError(err)
+	a.Len(infos, 21)
+}
+
+func testRepositoryCurrencyTotals(t *testing.T, repo Repository) {
+	a := assert.New(t)
+	ctx := context.Background()
+	code := randomCurrencyCode()
+	_, err := repo.CreateCurrency(ctx, Currency{Code: code, Precision: 2})
+	a.NoError(err)
+	sender := createFundedAccount(t, repo, code, "10.5")
+	receiver := createFundedAccount(t, repo, code, "5")
+	createFundedAccount(t, repo, code, "0")
+	a.NoError(repo.CreateInnerTransferTransactionWithLock(ctx, sender.ID, receiver.ID,
+		transfer(uuid.New(), decimal.RequireFromString("3"))))
+	withdrawal := uuid.New()
+	amount := decimal.RequireFromString("2.25")
+	a.NoError(repo.CreateAccountTransactionWithLock(ctx, receiver.ID, func(account Account, tx InnerTransferActions) error {
+		if err := tx.CreateTransfer(Transfer{ID: withdrawal, Type: Withdraw, Amount: amount, CurrencyCode: code}); err != nil {
+			return err
+		}
+		if err := tx.UpdateBalance(account.ID, account.Balance.Sub(amount)); err != nil {
+			return err
+		}
+
+		return tx.CreateTransferPart(TransferPart{TransferID: withdrawal, AccountID: account.ID, Direction: Outgoing})
+	}))
+
+	totals, err := repo.GetCurrencyTotals(ctx)
+	a.NoError(err)
+	var found *CurrencyTotals
+	for i, total := range totals {
+		if i > 0 {
+			a.Less(totals[i-1].CurrencyCode, total.CurrencyCode, "ordered by currency")
+		}
+		if total.CurrencyCode == code {
+			found = &totals[i]
+		}
+	}
+	if a.NotNil(found) {
+		a.Equal("13.25", found.Balances.String())
+		a.Equal("15.5", found.Deposits.String())
+		a.Equal("2.25", found.Withdrawals.String())
+	}
+}
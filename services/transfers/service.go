@@ -4,15 +4,38 @@ import (
 	"context"
 	"strings"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/tracing"
 )
 
 type service struct {
-	repo Repository
+	repo   Repository
+	volume metrics.Counter
 }
 
 func NewService(repo Repository) Service {
-	return service{repo}
+	return NewInstrumentedService(repo, discard.NewCounter())
+}
+
+// NewInstrumentedService counts amount of applied transfers to volume labelled by currency,
+// retries of already applied transfers aren't counted.
+func NewInstrumentedService(repo Repository, volume metrics.Counter) Service {
+	return service{repo: repo, volume: volume}
+}
+
+// accessible reports whether account may be accessed in the call context: principal accesses
+// accounts of its customer only, calls without principal are internal ones (e.g. from workers).
+func accessible(ctx context.Context, a Account) bool {
+	p, ok := auth.FromContext(ctx)
+
+	return !ok || (p.HasCustomer() && a.CustomerID != nil && *a.CustomerID == p.CustomerID)
 }
 
 func senderPartFrom(o InnerTransferOrder) TransferPart {
@@ -33,8 +56,50 @@ func receiverPartFrom(o InnerTransferOrder) TransferPart {
 	}
 }
 
-func newActionsInsideTransactionForOrder(o InnerTransferOrder) InnerTransferCallback {
+func eventsFrom(o InnerTransferOrder, senderBalance, receiverBalance decimal.Decimal) ([]outbox.Event, error) {
+	transferCreated, err := outbox.NewEvent(TransferCreated, TransferCreatedEvent{
+		ID:                o.ID,
+		Type:              Internal,
+		SenderAccountID:   o.SenderAccountID,
+		ReceiverAccountID: o.ReceiverAccountID,
+		Amount:            o.Amount,
+		CurrencyCode:      o.CurrencyCode,
+	}, o.SenderAccountID, o.ReceiverAccountID)
+	if err != nil {
+		return nil, err
+	}
+	senderBalanceChanged, err := outbox.NewEvent(BalanceChanged, BalanceChangedEvent{
+		AccountID:    o.SenderAccountID,
+		TransferID:   o.ID,
+		Diff:         o.Amount.Neg(),
+		Balance:      senderBalance,
+		CurrencyCode: o.CurrencyCode,
+	}, o.SenderAccountID)
+	if err != nil {
+		return nil, err
+	}
+	receiverBalanceChanged, err := outbox.NewEvent(BalanceChanged, BalanceChangedEvent{
+		AccountID:    o.ReceiverAccountID,
+		TransferID:   o.ID,
+		Diff:         o.Amount,
+		Balance:      receiverBalance,
+		CurrencyCode: o.CurrencyCode,
+	}, o.ReceiverAccountID)
+
+	return []outbox.Event{transferCreated, senderBalanceChanged, receiverBalanceChanged}, err
+}
+
+func newActionsInsideTransactionForOrder(ctx context.Context, o InnerTransferOrder) InnerTransferCallback {
 	return func(sender, receiver Account, a InnerTransferActions) error {
+		if !accessible(ctx, sender) { // receiver may be any account
+			return ErrSenderNotExists
+		}
+		if sender.Frozen {
+			return ErrSenderFrozen
+		}
+		if receiver.Frozen {
+			return ErrReceiverFrozen
+		}
 		if sender.CurrencyCode != o.CurrencyCode {
 			return ErrSenderWrongCurrency
 		}
@@ -48,11 +113,13 @@ func newActionsInsideTransactionForOrder(o InnerTransferOrder) InnerTransferCall
 		if err != nil {
 			return err
 		}
-		err = a.UpdateBalance(sender.ID, sender.Balance.Add(o.Amount.Neg()))
+		senderBalance := sender.Balance.Add(o.Amount.Neg())
+		err = a.UpdateBalance(sender.ID, senderBalance)
 		if err != nil {
 			return err
 		}
-		err = a.UpdateBalance(receiver.ID, receiver.Balance.Add(o.Amount))
+		receiverBalance := receiver.Balance.Add(o.Amount)
+		err = a.UpdateBalance(receiver.ID, receiverBalance)
 		if err != nil {
 			return err
 		}
@@ -60,12 +127,30 @@ func newActionsInsideTransactionForOrder(o InnerTransferOrder) InnerTransferCall
 		if err != nil {
 			return err
 		}
+		err = a.CreateTransferPart(receiverPartFrom(o))
+		if err != nil {
+			return err
+		}
+		events, err := eventsFrom(o, senderBalance, receiverBalance)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err = a.CreateEvent(e); err != nil {
+				return err
+			}
+		}
 
-		return a.CreateTransferPart(receiverPartFrom(o))
+		return nil
 	}
 }
 
-func (s service) CreateTransfer(ctx context.Context, o InnerTransferOrder) error {
+func (s service) CreateTransfer(ctx context.Context, o InnerTransferOrder) (err error) {
+	ctx, span := tracing.Start(ctx, "transfers.service.CreateTransfer",
+		attribute.String("transfer.id", o.ID.String()),
+		attribute.String("transfer.currency", o.CurrencyCode))
+	defer func() { tracing.End(span, err) }()
+
 	if o.ID == uuid.Nil {
 		return ErrEmptyTransferID
 	}
@@ -79,21 +164,24 @@ func (s service) CreateTransfer(ctx context.Context, o InnerTransferOrder) error
 		return ErrAccountsMustBeDifferent
 	}
 	o.CurrencyCode = strings.ToUpper(o.CurrencyCode)
-	precision, ok, err := s.repo.GetPrecision(ctx, o.CurrencyCode)
+	currency, ok, err := s.repo.GetCurrency(ctx, o.CurrencyCode)
 	if err != nil {
 		return err
 	}
 	if !ok {
 		return ErrUnsupportedCurrency
 	}
-	o.Amount = o.Amount.Round(int32(precision))
+	if currency.Disabled {
+		return ErrCurrencyDisabled
+	}
+	o.Amount = o.Amount.Round(int32(currency.Precision))
 	if !o.Amount.IsPositive() {
 		return ErrAmountMustBePositive
 	}
 
 	err = s.repo.CreateInnerTransferTransactionWithLock(
 		ctx, o.SenderAccountID, o.ReceiverAccountID,
-		newActionsInsideTransactionForOrder(o),
+		newActionsInsideTransactionForOrder(ctx, o),
 	)
 	if s.repo.IsTransferIDUsedError(err) {
 		return nil
@@ -104,14 +192,45 @@ func (s service) CreateTransfer(ctx context.Context, o InnerTransferOrder) error
 	if s.repo.IsEntityNotFoundError(o.ReceiverAccountID, err) {
 		return ErrReceiverNotExists
 	}
+	if err != nil {
+		return err
+	}
+	amount, _ := o.Amount.Float64()
+	s.volume.With("currency", o.CurrencyCode).Add(amount)
 
-	return err
+	return nil
 }
 
 func (s service) GetTransfersForAccount(ctx context.Context, accountID uuid.UUID) ([]TransferInfo, error) {
+	if _, ok := auth.FromContext(ctx); ok {
+		if _, err := s.GetAccount(ctx, accountID); err != nil {
+			return nil, err
+		}
+	}
+
 	return s.repo.GetTransferInfos(ctx, accountID, 100)
 }
 
 func (s service) GetAccounts(ctx context.Context) ([]Account, error) {
+	if p, ok := auth.FromContext(ctx); ok {
+		if !p.HasCustomer() {
+			return []Account{}, nil
+		}
+
+		return s.repo.GetCustomerAccounts(ctx, p.CustomerID, 100)
+	}
+
 	return s.repo.GetAccounts(ctx, 100)
 }
+
+func (s service) GetAccount(ctx context.Context, accountID uuid.UUID) (Account, error) {
+	a, ok, err := s.repo.GetAccount(ctx, accountID)
+	if err != nil {
+		return Account{}, err
+	}
+	if !ok || !accessible(ctx, a) {
+		return Account{}, ErrAccountNotExists
+	}
+
+	return a, nil
+}
@@ -3,25 +3,53 @@ package transfers
 import (
 	"context"
 	"database/sql/driver"
-	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-kit/kit/metrics"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/dberrors"
 )
 
 func prepare() (Service, sqlmock.Sqlmock, error, func()) {
+	svc, _, mock, err, close := prepareInstrumented()
+	return svc, mock, err, close
+}
+
+func prepareInstrumented() (Service, *labelledCounter, sqlmock.Sqlmock, error, func()) {
 	db, mock, err := sqlmock.New()
 	repo := NewRepository(db)
-	service := NewService(repo)
-	return service, mock, err, func() {
+	volume := &labelledCounter{values: map[string]float64{}}
+	service := NewInstrumentedService(repo, volume)
+	return service, volume, mock, err, func() {
 		db.Close()
 	}
 }
 
+// labelledCounter sums values by joined label values.
+type labelledCounter struct {
+	values map[string]float64
+	lvs    []string
+}
+
+func (c *labelledCounter) With(labelValues ...string) metrics.Counter {
+	return &labelledCounter{values: c.values, lvs: append(append([]string(nil), c.lvs...), labelValues...)}
+}
+
+func (c *labelledCounter) Add(delta float64) {
+	c.values[strings.Join(c.lvs, ",")] += delta
+}
+
 func newValidOrder() InnerTransferOrder {
 	order := InnerTransferOrder{}
 	order.ID = uuid.New()
@@ -46,9 +74,9 @@ func TestService_GetAccounts(t *testing.T) {
 	a.NoError(err, "mock initialized")
 	defer close()
 
-	rows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow("3AA42E32-1117-4533-A1B2-86714E9F842E", "USD", "100", time.Now(), time.Now()).
-		AddRow("2A9E457A-641F-4484-BA77-B4F6ED4E6633", "EUR", "100", time.Now(), time.Now())
+	rows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow("3AA42E32-1117-4533-A1B2-86714E9F842E", nil, "USD", "100", false, time.Now(), time.Now()).
+		AddRow("2A9E457A-641F-4484-BA77-B4F6ED4E6633", nil, "EUR", "100", false, time.Now(), time.Now())
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 	acc, err := svc.GetAccounts(context.Background())
 	a.NoError(err)
@@ -76,6 +104,34 @@ func TestService_GetTransfers(t *testing.T) {
 	a.Equal(1, len(transfers), "one transfer selected")
 }
 
+func TestService_GetTransfers_Replica(t *testing.T) {
+	a := assert.New(t)
+	primary, primaryMock, err := sqlmock.New()
+	a.NoError(err)
+	defer primary.Close()
+	replica, replicaMock, err := sqlmock.New()
+	a.NoError(err)
+	defer replica.Close()
+	svc := NewService(NewRepositoryWithReplica(primary, replica))
+	id := mustUUID("78c3c61f-70fa-477d-88fe-9767638b61a0")
+	transferRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"t.id", "tp.account_id", "tp.corresponding_account_id", "t.type", "tp.direction",
+			"t.currency_code", "t.amount", "t.created_at"})
+	}
+
+	replicaMock.ExpectQuery("SELECT .+ FROM transfer_parts").WillReturnRows(transferRows())
+	_, err = svc.GetTransfersForAccount(context.Background(), id)
+	a.NoError(err)
+	a.NoError(primaryMock.ExpectationsWereMet())
+	a.NoError(replicaMock.ExpectationsWereMet())
+
+	primaryMock.ExpectQuery("SELECT .+ FROM transfer_parts").WillReturnRows(transferRows())
+	_, err = svc.GetTransfersForAccount(WithPrimary(context.Background()), id)
+	a.NoError(err)
+	a.NoError(primaryMock.ExpectationsWereMet())
+	a.NoError(replicaMock.ExpectationsWereMet(), "replica isn't touched")
+}
+
 func TestService_CreateTransfer_validate1(t *testing.T) {
 	a := assert.New(t)
 	svc, mock, err, close := prepare()
@@ -95,9 +151,9 @@ func TestService_CreateTransfer_validate1(t *testing.T) {
 	err = svc.CreateTransfer(context.Background(), order)
 	a.Equal(ErrAccountsMustBeDifferent, err)
 	order.SenderAccountID = uuid.New()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 
 	err = svc.CreateTransfer(context.Background(), order)
 	a.Equal(ErrAmountMustBePositive, err)
@@ -110,13 +166,13 @@ func TestService_CreateTransfer_InsufficientFunds(t *testing.T) {
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "USD", "10", time.Now(), time.Now()).
-		AddRow(order.ReceiverAccountID, "USD", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "10", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectRollback()
 
@@ -125,19 +181,112 @@ func TestService_CreateTransfer_InsufficientFunds(t *testing.T) {
 	a.NoError(mock.ExpectationsWereMet())
 }
 
+func TestService_CreateTransfer_AccountsLockedInIDOrder(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
+	mock.ExpectBegin()
+	// db gives receiver first when its id is lower, sender is still the one with no funds
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.ReceiverAccountID, nil, "USD", "100", false, time.Now(), time.Now()).
+		AddRow(order.SenderAccountID, nil, "USD", "0", false, time.Now(), time.Now())
+	mock.ExpectQuery("^SELECT (.+) FROM accounts WHERE id in \\(\\$1, \\$2\\) ORDER BY id").
+		WithArgs(order.SenderAccountID, order.ReceiverAccountID).WillReturnRows(accountRows)
+	mock.ExpectRollback()
+
+	err = svc.CreateTransfer(context.Background(), order)
+	a.Equal(ErrInsufficientFunds, err)
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func customerContext(customerID uuid.UUID) context.Context {
+	return auth.NewContext(context.Background(), auth.Principal{ClientID: "client", CustomerID: customerID})
+}
+
+func TestService_CreateTransfer_ForeignSender(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	owner, other := uuid.New(), uuid.New()
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
+	mock.ExpectBegin()
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, other, "USD", "100", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, owner, "USD", "0", false, time.Now(), time.Now())
+	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
+	mock.ExpectRollback()
+
+	err = svc.CreateTransfer(customerContext(owner), order)
+	a.Equal(ErrSenderNotExists, err, "sender of other customer looks like nonexistent one")
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_GetAccounts_OfCustomer(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	owner := uuid.New()
+
+	rows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow("3AA42E32-1117-4533-A1B2-86714E9F842E", owner, "USD", "100", false, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM accounts WHERE customer_id = \\$1").
+		WithArgs(owner, 100).WillReturnRows(rows)
+	acc, err := svc.GetAccounts(customerContext(owner))
+	a.NoError(err)
+	a.Equal(1, len(acc))
+	a.NoError(mock.ExpectationsWereMet())
+}
+
+func TestService_GetAccounts_NoCustomer(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+
+	acc, err := svc.GetAccounts(customerContext(uuid.Nil))
+	a.NoError(err)
+	a.Empty(acc, "principal without customer has no accounts")
+	a.NoError(mock.ExpectationsWereMet(), "accounts are not queried")
+}
+
+func TestService_GetTransfers_ForeignAccount(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	id := uuid.New()
+
+	rows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(id, uuid.New(), "USD", "100", false, time.Now(), time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM accounts WHERE id = \\$1").WithArgs(id).WillReturnRows(rows)
+	_, err = svc.GetTransfersForAccount(customerContext(uuid.New()), id)
+	a.Equal(ErrAccountNotExists, err)
+	a.NoError(mock.ExpectationsWereMet(), "transfers are not queried")
+}
+
 func TestService_CreateTransfer_WrongSenderCurrency(t *testing.T) {
 	a := assert.New(t)
 	svc, mock, err, close := prepare()
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "BTC", "10", time.Now(), time.Now()).
-		AddRow(order.ReceiverAccountID, "USD", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "BTC", "10", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectRollback()
 
@@ -152,13 +301,13 @@ func TestService_CreateTransfer_WrongReceiverCurrency(t *testing.T) {
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "USD", "10", time.Now(), time.Now()).
-		AddRow(order.ReceiverAccountID, "BTC", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "10", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "BTC", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectRollback()
 
@@ -173,12 +322,12 @@ func TestService_CreateTransfer_SenderNotExits(t *testing.T) {
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.ReceiverAccountID, "USD", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectRollback()
 
@@ -193,12 +342,12 @@ func TestService_CreateTransfer_ReceiverNotExits(t *testing.T) {
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "USD", "10", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "10", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectRollback()
 
@@ -209,25 +358,49 @@ func TestService_CreateTransfer_ReceiverNotExits(t *testing.T) {
 
 func TestService_CreateTransfer_IdempotencyKeyUsed(t *testing.T) {
 	a := assert.New(t)
-	svc, mock, err, close := prepare()
+	svc, volume, mock, err, close := prepareInstrumented()
 	a.NoError(err, "mock initialized")
 	defer close()
 	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "USD", "20", time.Now(), time.Now()).
-		AddRow(order.ReceiverAccountID, "USD", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "20", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectExec("INSERT INTO transfers").WillReturnError(
-		errors.New(`pq: duplicate key value violates unique constraint "transfers_pkey"`))
+		&pq.Error{Code: dberrors.UniqueViolation, Constraint: "transfers_pkey"})
 	mock.ExpectRollback()
 
 	err = svc.CreateTransfer(context.Background(), order)
 	a.NoError(err, "No error if key was already used")
 	a.NoError(mock.ExpectationsWereMet())
+	a.Empty(volume.values, "retry isn't counted to volume")
+}
+
+func TestService_CreateTransfer_PrecisionChanged(t *testing.T) {
+	a := assert.New(t)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
+	mock.ExpectBegin()
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "20", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
+	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
+	mock.ExpectExec("INSERT INTO transfers(.+) FROM currencies (.+) FOR KEY SHARE").
+		WillReturnResult(newFakeDriverResult(0))
+	mock.ExpectRollback()
+
+	err = svc.CreateTransfer(context.Background(), order)
+	a.Equal(ErrPrecisionChanged, err, "amount doesn't fit precision changed after it was read")
+	a.NoError(mock.ExpectationsWereMet())
 }
 
 type fakeDriverResult struct {
@@ -246,28 +419,107 @@ func newFakeDriverResult(count int64) driver.Result {
 	return fakeDriverResult{count}
 }
 
-func TestService_CreateTransfer_AllNice(t *testing.T) {
-	a := assert.New(t)
-	svc, mock, err, close := prepare()
-	a.NoError(err, "mock initialized")
-	defer close()
-	order := newValidOrder()
-	currencyRows := sqlmock.NewRows([]string{"precision"}).
-		AddRow("2")
-	mock.ExpectQuery("^SELECT precision FROM currencies").WillReturnRows(currencyRows)
+// expectAppliedTransfer sets expectations of all statements of successful transfer.
+func expectAppliedTransfer(mock sqlmock.Sqlmock, order InnerTransferOrder) {
+	currencyRows := sqlmock.NewRows([]string{"precision", "disabled"}).
+		AddRow("2", false)
+	mock.ExpectQuery("^SELECT precision, disabled FROM currencies").WillReturnRows(currencyRows)
 	mock.ExpectBegin()
-	accountRows := sqlmock.NewRows([]string{"id", "currency_code", "balance", "created_at", "updated_at"}).
-		AddRow(order.SenderAccountID, "USD", "20", time.Now(), time.Now()).
-		AddRow(order.ReceiverAccountID, "USD", "0", time.Now(), time.Now())
+	accountRows := sqlmock.NewRows([]string{"id", "customer_id", "currency_code", "balance", "frozen", "created_at", "updated_at"}).
+		AddRow(order.SenderAccountID, nil, "USD", "20", false, time.Now(), time.Now()).
+		AddRow(order.ReceiverAccountID, nil, "USD", "0", false, time.Now(), time.Now())
 	mock.ExpectQuery("^SELECT (.+) FROM accounts").WillReturnRows(accountRows)
 	mock.ExpectExec("INSERT INTO transfers").WillReturnResult(newFakeDriverResult(1))
 	mock.ExpectExec("UPDATE accounts").WillReturnResult(newFakeDriverResult(1))
 	mock.ExpectExec("UPDATE accounts").WillReturnResult(newFakeDriverResult(1))
 	mock.ExpectExec("INSERT INTO transfer_parts").WillReturnResult(newFakeDriverResult(1))
 	mock.ExpectExec("INSERT INTO transfer_parts").WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(TransferCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(BalanceChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(newFakeDriverResult(1))
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(BalanceChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(newFakeDriverResult(1))
 	mock.ExpectCommit()
+}
+
+func TestService_CreateTransfer_AllNice(t *testing.T) {
+	a := assert.New(t)
+	svc, volume, mock, err, close := prepareInstrumented()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	expectAppliedTransfer(mock, order)
 
 	err = svc.CreateTransfer(context.Background(), order)
 	a.NoError(err, "No error if key was already used")
 	a.NoError(mock.ExpectationsWereMet())
+	a.Equal(map[string]float64{"currency,USD": 14.23}, volume.values, "rounded amount is counted")
+}
+
+func TestService_CreateTransfer_Spans(t *testing.T) {
+	a := assert.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+	svc, mock, err, close := prepare()
+	a.NoError(err, "mock initialized")
+	defer close()
+	order := newValidOrder()
+	expectAppliedTransfer(mock, order)
+
+	a.NoError(svc.CreateTransfer(context.Background(), order))
+
+	spans := recorder.Ended()
+	names := make([]string, 0, len(spans))
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		names = append(names, s.Name())
+		byName[s.Name()] = s
+	}
+	a.Equal([]string{
+		"BEGIN",
+		"SELECT accounts FOR NO KEY UPDATE",
+		"INSERT transfers",
+		"UPDATE accounts",
+		"UPDATE accounts",
+		"INSERT transfer_parts",
+		"INSERT transfer_parts",
+		"INSERT outbox",
+		"INSERT outbox",
+		"INSERT outbox",
+		"COMMIT",
+		"transfers.CreateInnerTransferTransactionWithLock",
+		"transfers.service.CreateTransfer",
+	}, names)
+	txn := byName["transfers.CreateInnerTransferTransactionWithLock"]
+	a.Equal(byName["transfers.service.CreateTransfer"].SpanContext().SpanID(), txn.Parent().SpanID())
+	for _, s := range spans[:11] {
+		a.Equal(txn.SpanContext().SpanID(), s.Parent().SpanID(), s.Name()+" is child of transaction")
+	}
+}
+
+func TestService_CreateTransfer_MemoryRepository(t *testing.T) {
+	a := assert.New(t)
+	repo := NewMemoryRepository()
+	svc := NewService(repo)
+	sender := createFundedAccount(t, repo, "USD", "10")
+	receiver := createFundedAccount(t, repo, "USD", "0")
+
+	order := InnerTransferOrder{
+		ID:                uuid.New(),
+		SenderAccountID:   sender.ID,
+		ReceiverAccountID: receiver.ID,
+		Amount:            decimal.RequireFromString("2.345"),
+		CurrencyCode:      "usd",
+	}
+	a.NoError(svc.CreateTransfer(context.Background(), order))
+	a.NoError(svc.CreateTransfer(context.Background(), order), "retry is idempotent")
+	a.Equal("7.65", balanceOf(t, repo, sender.ID))
+	a.Equal("2.35", balanceOf(t, repo, receiver.ID))
+	order.ID = uuid.New()
+	order.Amount = decimal.RequireFromString("100")
+	a.Equal(ErrInsufficientFunds, svc.CreateTransfer(context.Background(), order))
+	a.Len(repo.Events(), 3, "transfer created and balance changed events are published once")
 }
@@ -0,0 +1,379 @@
+package transfers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+	"github.com/risentveber/wallet-api/services/tracing"
+)
+
+// sqliteNow is current time in format of timestamps of sqlite migrations, it's ordered as text.
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// NewSQLiteRepository gives Repository over db opened by migrations.Open with sqlite driver.
+// Decimals are kept as text, so they are summed and rounded in Go, and transactions
+// take the write lock of the whole database on BEGIN IMMEDIATE instead of locking rows.
+func NewSQLiteRepository(db *sql.DB) Repository {
+	return sqliteRepository{db: db, replica: db}
+}
+
+// sqliteRepository has the same layout as repository, so queries portable to sqlite are shared by conversion.
+type sqliteRepository struct {
+	db      *sql.DB
+	replica *sql.DB
+}
+
+func (r sqliteRepository) GetCurrency(ctx context.Context, code string) (Currency, bool, error) {
+	return repository(r).GetCurrency(ctx, code)
+}
+
+func (r sqliteRepository) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	return repository(r).GetCurrencies(ctx)
+}
+
+func (r sqliteRepository) CreateCurrency(ctx context.Context, c Currency) (bool, error) {
+	return repository(r).CreateCurrency(ctx, c)
+}
+
+func (r sqliteRepository) UpdateCurrencyPrecision(ctx context.Context, code string, precision uint) (updated bool, err error) {
+	err = r.inTransaction(ctx, "transfers.UpdateCurrencyPrecision", func(ctx context.Context, conn *sql.Conn) error {
+		rows, err := conn.QueryContext(ctx, `SELECT balance FROM accounts WHERE currency_code = $1`, code)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		truncates := false
+		for rows.Next() && !truncates {
+			var balance decimal.Decimal
+			if err := rows.Scan(&balance); err != nil {
+				return err
+			}
+			truncates = !balance.Equal(balance.Round(int32(precision)))
+		}
+		if err := rows.Err(); err != nil || truncates {
+			return err
+		}
+		_ = rows.Close()
+		updated, err = affected(conn.ExecContext(ctx, `UPDATE currencies SET precision = $2 WHERE code = $1`, code, precision))
+
+		return err
+	})
+
+	return updated, err
+}
+
+func (r sqliteRepository) SetCurrencyDisabled(ctx context.Context, code string, disabled bool) (bool, error) {
+	return repository(r).SetCurrencyDisabled(ctx, code, disabled)
+}
+
+func (r sqliteRepository) IsTransferIDUsedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: transfers.id")
+}
+
+func (r sqliteRepository) IsEntityNotFoundError(id uuid.UUID, err error) bool {
+	return repository(r).IsEntityNotFoundError(id, err)
+}
+
+type sqliteTxn struct {
+	conn *sql.Conn
+	ctx  context.Context
+}
+
+// CreateTransfer checks amount against current precision of currency, it can't be changed concurrently
+// as transactions are serialized by BEGIN IMMEDIATE.
+func (tx sqliteTxn) CreateTransfer(t Transfer) (err error) {
+	var precision int32
+	err = tx.conn.QueryRowContext(tx.ctx, `SELECT precision FROM currencies WHERE code = $1`, t.CurrencyCode).
+		Scan(&precision)
+	if err != nil {
+		return err
+	}
+	if !t.Amount.Equal(t.Amount.Round(precision)) {
+		return ErrPrecisionChanged
+	}
+	const query = `
+INSERT INTO transfers(id, type, amount, currency_code, reason, created_at)
+ VALUES ($1, $2, $3, $4, $5, ` + sqliteNow + `)`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.SQLite, "INSERT transfers", query)
+	defer func() { tracing.End(span, err) }()
+	res, err := tx.conn.ExecContext(ctx, query, t.ID, t.Type, t.Amount, t.CurrencyCode, t.Reason)
+	if err != nil {
+		return err
+	}
+
+	return validateAffected(res)
+}
+
+func (tx sqliteTxn) UpdateBalance(accountID uuid.UUID, balance decimal.Decimal) (err error) {
+	const query = `
+UPDATE accounts
+SET balance = $1, updated_at = ` + sqliteNow + `
+WHERE id = $2`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.SQLite, "UPDATE accounts", query)
+	defer func() { tracing.End(span, err) }()
+	res, err := tx.conn.ExecContext(ctx, query, balance, accountID)
+	if err != nil {
+		return err
+	}
+
+	return validateAffected(res)
+}
+
+func (tx sqliteTxn) CreateTransferPart(tp TransferPart) (err error) {
+	const query = `
+INSERT INTO transfer_parts(transfer_id, account_id, corresponding_account_id, direction)
+ VALUES ($1, $2, $3, $4)`
+	ctx, span := tracing.StartDB(tx.ctx, tracing.SQLite, "INSERT transfer_parts", query)
+	defer func() { tracing.End(span, err) }()
+	_, err = tx.conn.ExecContext(ctx, query, tp.TransferID, tp.AccountID, tp.CorrespondingAccountID, tp.Direction)
+
+	return err
+}
+
+func (tx sqliteTxn) CreateEvent(e outbox.Event) error {
+	return outbox.WriteSQLite(tx.ctx, tx.conn, e)
+}
+
+func (r sqliteRepository) CreateInnerTransferTransactionWithLock(
+	ctx context.Context, sender, receiver uuid.UUID, c InnerTransferCallback) error {
+	return r.inTransaction(ctx, "transfers.CreateInnerTransferTransactionWithLock",
+		func(ctx context.Context, conn *sql.Conn) error {
+			accounts, err := r.queryAccounts(ctx, conn, 2, `
+SELECT `+accountColumns+` FROM accounts WHERE id IN ($1, $2)
+ORDER BY CASE WHEN id = $1 THEN 1 ELSE 2 END`, sender, receiver)
+			if err != nil {
+				return err
+			}
+			if len(accounts) < 2 { // nolint gomnd
+				return generateFirstEntityNotFoundError(accounts, sender, receiver)
+			}
+
+			return c(accounts[0], accounts[1], sqliteTxn{conn: conn, ctx: ctx})
+		})
+}
+
+func (r sqliteRepository) CreateAccountTransactionWithLock(ctx context.Context, accountID uuid.UUID, c AccountCallback) error {
+	return r.inTransaction(ctx, "transfers.CreateAccountTransactionWithLock",
+		func(ctx context.Context, conn *sql.Conn) error {
+			accounts, err := r.queryAccounts(ctx, conn, 1, `SELECT `+accountColumns+` FROM accounts WHERE id = $1`, accountID)
+			if err != nil {
+				return err
+			}
+			if len(accounts) == 0 {
+				return entityNotFound{accountID}
+			}
+
+			return c(accounts[0], sqliteTxn{conn: conn, ctx: ctx})
+		})
+}
+
+// inTransaction runs f inside BEGIN IMMEDIATE transaction on dedicated connection,
+// so concurrent writers wait for each other (up to busy timeout) instead of failing on commit.
+func (r sqliteRepository) inTransaction(
+	ctx context.Context, name string, f func(ctx context.Context, conn *sql.Conn) error) (err error) {
+	ctx, span := tracing.Start(ctx, name)
+	defer func() { tracing.End(span, err) }()
+
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, beginSpan := tracing.StartDB(ctx, tracing.SQLite, "BEGIN IMMEDIATE", "BEGIN IMMEDIATE")
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	tracing.End(beginSpan, err)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p := recover()
+		if err == nil && p == nil {
+			_, commitSpan := tracing.StartDB(ctx, tracing.SQLite, "COMMIT", "COMMIT")
+			_, err = conn.ExecContext(ctx, "COMMIT")
+			tracing.End(commitSpan, err)
+			if err == nil {
+				return
+			}
+		}
+		// ctx may be done already, rollback is required anyway
+		_, rollbackSpan := tracing.StartDB(ctx, tracing.SQLite, "ROLLBACK", "ROLLBACK")
+		_, rollbackErr := conn.ExecContext(context.Background(), "ROLLBACK")
+		tracing.End(rollbackSpan, rollbackErr)
+		if rollbackErr != nil {
+			// connection with open transaction mustn't return to pool
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	return f(ctx, conn)
+}
+
+type sqliteQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (r sqliteRepository) queryAccounts(
+	ctx context.Context, q sqliteQueryer, limit uint, query string, args ...interface{}) ([]Account, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accounts := make([]Account, 0, limit)
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (r sqliteRepository) CreateAccount(ctx context.Context, a Account) (Account, error) {
+	return repository(r).CreateAccount(ctx, a)
+}
+
+func (r sqliteRepository) SetAccountFrozen(ctx context.Context, accountID uuid.UUID, frozen bool) (bool, error) {
+	return affected(r.db.ExecContext(ctx, `
+UPDATE accounts
+SET frozen = $1, updated_at = `+sqliteNow+`
+WHERE id = $2`, frozen, accountID))
+}
+
+func (r sqliteRepository) GetTransfer(ctx context.Context, transferID uuid.UUID) (TransferDetails, bool, error) {
+	return repository(r).GetTransfer(ctx, transferID)
+}
+
+func (r sqliteRepository) GetBalanceMismatches(ctx context.Context) ([]BalanceMismatch, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT a.id, a.currency_code, a.balance, t.amount, tp.direction
+FROM accounts AS a
+LEFT JOIN transfer_parts AS tp ON tp.account_id = a.id
+LEFT JOIN transfers AS t ON t.id = tp.transfer_id
+ORDER BY a.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mismatches []BalanceMismatch
+	var current BalanceMismatch
+	flush := func() {
+		if current.AccountID != uuid.Nil && !current.Balance.Equal(current.Expected) {
+			mismatches = append(mismatches, current)
+		}
+	}
+	for rows.Next() {
+		var m BalanceMismatch
+		var amount decimal.NullDecimal
+		var direction sql.NullString
+		if err := rows.Scan(&m.AccountID, &m.CurrencyCode, &m.Balance, &amount, &direction); err != nil {
+			return nil, err
+		}
+		if m.AccountID != current.AccountID {
+			flush()
+			current = m
+		}
+		switch {
+		case !amount.Valid:
+		case direction.String == Incoming:
+			current.Expected = current.Expected.Add(amount.Decimal)
+		default:
+			current.Expected = current.Expected.Sub(amount.Decimal)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return mismatches, nil
+}
+
+// GetCurrencyTotals sums decimals in Go, both queries see the same snapshot as they are made in single transaction.
+func (r sqliteRepository) GetCurrencyTotals(ctx context.Context) ([]CurrencyTotals, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }() // nothing to commit
+	totals := make(totalsByCurrency)
+	rows, err := tx.QueryContext(ctx, `SELECT currency_code, balance FROM accounts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var balance decimal.Decimal
+		if err := rows.Scan(&code, &balance); err != nil {
+			return nil, err
+		}
+		t := totals.of(code)
+		t.Balances = t.Balances.Add(balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+	rows, err = tx.QueryContext(ctx, `SELECT currency_code, type, amount FROM transfers WHERE type <> 'INTERNAL'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code, transferType string
+		var amount decimal.Decimal
+		if err := rows.Scan(&code, &transferType, &amount); err != nil {
+			return nil, err
+		}
+		t := totals.of(code)
+		if transferType == Deposit {
+			t.Deposits = t.Deposits.Add(amount)
+		} else {
+			t.Withdrawals = t.Withdrawals.Add(amount)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals.sorted(), nil
+}
+
+func (r sqliteRepository) GetAccounts(ctx context.Context, limit uint) ([]Account, error) {
+	return repository(r).GetAccounts(ctx, limit)
+}
+
+func (r sqliteRepository) GetCustomerAccounts(ctx context.Context, customerID uuid.UUID, limit uint) ([]Account, error) {
+	return repository(r).GetCustomerAccounts(ctx, customerID, limit)
+}
+
+func (r sqliteRepository) GetAccount(ctx context.Context, accountID uuid.UUID) (Account, bool, error) {
+	return repository(r).GetAccount(ctx, accountID)
+}
+
+// GetTransferInfos orders transfers created within the same millisecond by insertion.
+func (r sqliteRepository) GetTransferInfos(ctx context.Context, accountID uuid.UUID, limit uint) ([]TransferInfo, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+transferInfoColumns+`
+FROM transfer_parts as tp
+INNER JOIN transfers as t ON tp.transfer_id = t.id
+WHERE tp.account_id = $1 ORDER BY t.created_at DESC, t.rowid DESC
+LIMIT $2`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanTransferInfos(rows, limit)
+}
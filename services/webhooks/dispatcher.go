@@ -0,0 +1,40 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Dispatcher periodically attempts due deliveries.
+type Dispatcher struct {
+	svc      Service
+	interval time.Duration
+	logger   log.Logger
+}
+
+func NewDispatcher(svc Service, interval time.Duration, logger log.Logger) Dispatcher {
+	return Dispatcher{svc: svc, interval: interval, logger: logger}
+}
+
+// Run blocks until ctx is done.
+func (d Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		attempted, err := d.svc.DeliverDue(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			_ = level.Error(d.logger).Log("msg", "webhooks delivery failed", "err", err.Error())
+		}
+		if attempted > 0 {
+			_ = level.Debug(d.logger).Log("msg", "webhook deliveries attempted", "count", attempted)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+// Business logic level errors that provide enough information about what went wrong.
+var (
+	ErrEmptySubscriptionID   = errors.New("subscription_id_is_empty")
+	ErrInvalidURL            = errors.New("webhook_url_invalid")
+	ErrURLNotAllowed         = errors.New("webhook_url_not_allowed")
+	ErrSubscriptionNotExists = errors.New("subscription_not_exist")
+)
+
+// Delivery status enums.
+const (
+	Pending   = "PENDING"
+	Succeeded = "SUCCEEDED"
+	Dead      = "DEAD" // retries exhausted, delivery is not attempted anymore
+)
+
+// Headers of webhook request.
+const (
+	SignatureHeader  = "X-Wallet-Signature" // "sha256=" + hex of HMAC-SHA256 over body
+	EventTypeHeader  = "X-Wallet-Event-Type"
+	DeliveryIDHeader = "X-Wallet-Delivery-Id"
+)
+
+// Subscription order for registering webhook.
+type SubscriptionOrder struct {
+	ID  uuid.UUID `json:"id"`
+	URL string    `json:"url"`
+	// types of events to be delivered, all events if empty
+	EventTypes []string `json:"event_types"`
+}
+
+// Subscription of client url to events, each one with own secret for signing deliveries.
+type Subscription struct {
+	ID uuid.UUID `json:"id"`
+	// only events of customer accounts are delivered, events of all accounts if nil (internal subscription)
+	CustomerID *uuid.UUID `json:"customer_id"`
+	URL        string     `json:"url"`
+	EventTypes []string   `json:"event_types"`
+	Secret     string     `json:"secret,omitempty"` // shown only on creation
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// MatchesOwners reports whether event of accounts with given owners must be delivered to subscription.
+func (s Subscription) MatchesOwners(accountIDs []uuid.UUID, owners map[uuid.UUID]uuid.UUID) bool {
+	if s.CustomerID == nil {
+		return true
+	}
+	for _, id := range accountIDs {
+		if owner, ok := owners[id]; ok && owner == *s.CustomerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches reports whether event of the type must be delivered to subscription.
+func (s Subscription) Matches(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delivery of single event to subscription with its attempts state.
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Body           json.RawMessage `json:"-"`
+	Status         string          `json:"status"` // Pending, Succeeded, Dead
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Business actions, if there is auth.Principal in context only subscriptions of its customer are accessible.
+type Service interface {
+	CreateSubscription(ctx context.Context, order SubscriptionOrder) (Subscription, error)
+	GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error)
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	GetDeliveries(ctx context.Context, subscriptionID uuid.UUID) ([]Delivery, error)
+	// schedules deliveries of events to matching subscriptions, so service is an outbox.EventPublisher
+	Publish(ctx context.Context, events []outbox.Event) error
+	// attempts deliveries that are due at the moment, returns count of attempted ones
+	DeliverDue(ctx context.Context, now time.Time) (int, error)
+}
@@ -0,0 +1,128 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/auth"
+)
+
+type CreateSubscriptionRequest struct {
+	SubscriptionOrder
+}
+
+type SubscriptionResponse struct {
+	Subscription *Subscription
+	Err          error
+}
+
+func (r SubscriptionResponse) Failed() error { return r.Err }
+
+func MakeCreateSubscriptionEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateSubscriptionRequest)
+		sub, err := s.CreateSubscription(ctx, req.SubscriptionOrder)
+		if err != nil {
+			return SubscriptionResponse{Err: err}, nil
+		}
+
+		return SubscriptionResponse{Subscription: &sub}, nil
+	}
+}
+
+type GetSubscriptionRequest struct {
+	SubscriptionID uuid.UUID
+}
+
+func MakeGetSubscriptionEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetSubscriptionRequest)
+		sub, err := s.GetSubscription(ctx, req.SubscriptionID)
+		if err != nil {
+			return SubscriptionResponse{Err: err}, nil
+		}
+
+		return SubscriptionResponse{Subscription: &sub}, nil
+	}
+}
+
+type DeleteSubscriptionRequest struct {
+	SubscriptionID uuid.UUID
+}
+
+type DeleteSubscriptionResponse struct {
+	Err error
+}
+
+func (r DeleteSubscriptionResponse) Failed() error { return r.Err }
+
+func MakeDeleteSubscriptionEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteSubscriptionRequest)
+		err := s.DeleteSubscription(ctx, req.SubscriptionID)
+
+		return DeleteSubscriptionResponse{Err: err}, nil
+	}
+}
+
+type GetDeliveriesRequest struct {
+	SubscriptionID uuid.UUID
+}
+
+type GetDeliveriesResponse struct {
+	Deliveries []Delivery
+	Err        error
+}
+
+func (r GetDeliveriesResponse) Failed() error { return r.Err }
+
+func MakeGetDeliveriesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetDeliveriesRequest)
+		deliveries, err := s.GetDeliveries(ctx, req.SubscriptionID)
+
+		return GetDeliveriesResponse{Deliveries: deliveries, Err: err}, nil
+	}
+}
+
+func NewEndpoints(s Service) Endpoints {
+	return Endpoints{
+		CreateSubscription: MakeCreateSubscriptionEndpoint(s),
+		GetSubscription:    MakeGetSubscriptionEndpoint(s),
+		DeleteSubscription: MakeDeleteSubscriptionEndpoint(s),
+		GetDeliveries:      MakeGetDeliveriesEndpoint(s),
+	}
+}
+
+type Endpoints struct {
+	CreateSubscription endpoint.Endpoint
+	GetSubscription    endpoint.Endpoint
+	DeleteSubscription endpoint.Endpoint
+	GetDeliveries      endpoint.Endpoint
+}
+
+// EndpointScopes required by endpoints, see auth.ScopeMiddleware.
+var EndpointScopes = map[string]string{
+	"CreateSubscription": auth.ScopeWebhooksManage,
+	"GetSubscription":    auth.ScopeWebhooksManage,
+	"DeleteSubscription": auth.ScopeWebhooksManage,
+	"GetDeliveries":      auth.ScopeWebhooksManage,
+}
+
+// ReadEndpoints don't change state, so they aren't recorded by audit.Middleware.
+var ReadEndpoints = map[string]bool{
+	"GetSubscription": true,
+	"GetDeliveries":   true,
+}
+
+// Wrap decorates each endpoint with middleware built for its name.
+func (e Endpoints) Wrap(mw func(name string) endpoint.Middleware) Endpoints {
+	e.CreateSubscription = mw("CreateSubscription")(e.CreateSubscription)
+	e.GetSubscription = mw("GetSubscription")(e.GetSubscription)
+	e.DeleteSubscription = mw("DeleteSubscription")(e.DeleteSubscription)
+	e.GetDeliveries = mw("GetDeliveries")(e.GetDeliveries)
+
+	return e
+}
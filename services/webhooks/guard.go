@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// internalNets aren't reachable by webhooks besides loopback, link-local (including cloud metadata
+// 169.254.169.254), multicast and unspecified addresses recognized by net.IP methods.
+var internalNets = mustParseNets("0.0.0.0/8,10.0.0.0/8,100.64.0.0/10,172.16.0.0/12,192.168.0.0/16,198.18.0.0/15,fc00::/7")
+
+// ParseNets parses comma separated CIDRs, empty list gives no networks.
+func ParseNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func mustParseNets(list string) []*net.IPNet {
+	nets, err := ParseNets(list)
+	if err != nil {
+		panic(err)
+	}
+
+	return nets
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddressGuard keeps webhooks from reaching internal addresses (SSRF), so tenants can't probe
+// internal services by delivery statuses and errors, addresses of allowed networks are reachable anyway.
+type AddressGuard struct {
+	allowed []*net.IPNet
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func NewAddressGuard(allowed []*net.IPNet) AddressGuard {
+	return AddressGuard{allowed: allowed, lookup: net.DefaultResolver.LookupIPAddr}
+}
+
+// Allows reports whether ip may be reached.
+func (g AddressGuard) Allows(ip net.IP) bool {
+	if contains(g.allowed, ip) {
+		return true
+	}
+
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !contains(internalNets, ip)
+}
+
+// allowsHost reports whether all addresses host resolves to may be reached, it fails fast on subscription,
+// while deliveries are guarded on dial as host may resolve to other addresses later.
+func (g AddressGuard) allowsHost(ctx context.Context, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return g.Allows(ip)
+	}
+	addrs, err := g.lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !g.Allows(addr.IP) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Client gives http client checking each address it connects to after resolution, including ones
+// of redirects, proxies from environment aren't used as they would connect instead of it.
+func (g AddressGuard) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !g.Allows(ip) {
+				return fmt.Errorf("address %s is not allowed for webhooks", host)
+			}
+
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGuard resolves hosts to public address, except internal.example and unresolvable.example,
+// loopback is allowed for httptest servers.
+func testGuard() AddressGuard {
+	g := NewAddressGuard(mustParseNets("127.0.0.0/8"))
+	g.lookup = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "internal.example":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.1.2.3")}}, nil
+		case "unresolvable.example":
+			return nil, errors.New("no such host")
+		default:
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		}
+	}
+
+	return g
+}
+
+func TestAddressGuard_Allows(t *testing.T) {
+	a := assert.New(t)
+	g := NewAddressGuard(mustParseNets("10.20.0.0/16"))
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1::1", "10.20.1.1"} {
+		a.True(g.Allows(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{
+		"127.0.0.1", "::1", "10.0.0.1", "172.16.5.4", "192.168.0.1", "169.254.169.254", "fe80::1",
+		"fd00::1", "0.0.0.0", "::", "100.64.0.1", "224.0.0.1", "::ffff:127.0.0.1", "::ffff:10.0.0.1",
+	} {
+		a.False(g.Allows(net.ParseIP(ip)), ip)
+	}
+
+	_, err := ParseNets("10.0.0.0/8, ")
+	a.NoError(err)
+	_, err = ParseNets("10.0.0.1")
+	a.Error(err)
+}
+
+func TestAddressGuard_Client(t *testing.T) {
+	a := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// host may resolve to internal address after subscription is created, e.g. by DNS rebinding
+	_, err := NewAddressGuard(nil).Client(time.Second).Get(server.URL)
+	a.Error(err)
+	a.Contains(err.Error(), "address 127.0.0.1 is not allowed for webhooks")
+
+	res, err := testGuard().Client(time.Second).Get(server.URL)
+	a.NoError(err, "allowed network is reachable")
+	if err == nil {
+		a.NoError(res.Body.Close())
+	}
+}
@@ -0,0 +1,91 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/risentveber/wallet-api/services/transfers"
+)
+
+func NewHTTPHandler(endpoints Endpoints, logger log.Logger) http.Handler {
+	r := mux.NewRouter().StrictSlash(true)
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(transfers.ErrorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+	r.Handle("/webhooks/",
+		httptransport.NewServer(endpoints.CreateSubscription,
+			DecodeCreateSubscriptionRequest, EncodeSubscriptionResponse, options...)).
+		Methods("POST")
+	r.Handle("/webhooks/{subscription_id}/",
+		httptransport.NewServer(endpoints.GetSubscription,
+			DecodeGetSubscriptionRequest, EncodeSubscriptionResponse, options...)).
+		Methods("GET")
+	r.Handle("/webhooks/{subscription_id}/",
+		httptransport.NewServer(endpoints.DeleteSubscription,
+			DecodeDeleteSubscriptionRequest, EncodeDeleteSubscriptionResponse, options...)).
+		Methods("DELETE")
+	r.Handle("/webhooks/{subscription_id}/deliveries/",
+		httptransport.NewServer(endpoints.GetDeliveries,
+			DecodeGetDeliveriesRequest, EncodeGetDeliveriesResponse, options...)).
+		Methods("GET")
+
+	return r
+}
+
+func subscriptionIDFrom(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(mux.Vars(r)["subscription_id"])
+}
+
+func DecodeCreateSubscriptionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req CreateSubscriptionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	return req, err
+}
+
+func DecodeGetSubscriptionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := subscriptionIDFrom(r)
+
+	return GetSubscriptionRequest{SubscriptionID: id}, err
+}
+
+func DecodeDeleteSubscriptionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := subscriptionIDFrom(r)
+
+	return DeleteSubscriptionRequest{SubscriptionID: id}, err
+}
+
+func DecodeGetDeliveriesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := subscriptionIDFrom(r)
+
+	return GetDeliveriesRequest{SubscriptionID: id}, err
+}
+
+func EncodeSubscriptionResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(SubscriptionResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Subscription, response.Err))
+}
+
+func EncodeDeleteSubscriptionResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(DeleteSubscriptionResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(nil, response.Err))
+}
+
+func EncodeGetDeliveriesResponse(_ context.Context, w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response, _ := res.(GetDeliveriesResponse)
+
+	return json.NewEncoder(w).Encode(transfers.NewCommonResponse(response.Deliveries, response.Err))
+}
@@ -0,0 +1,50 @@
+package webhooks
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = log.NewLogfmtLogger(os.Stdout)
+
+func TestCreateSubscriptionAndDeliveries(t *testing.T) {
+	a := assert.New(t)
+	svc := NewService(newMemoryRepository(), http.DefaultClient, testGuard(), testPolicy)
+	handler := NewHTTPHandler(NewEndpoints(svc), testLogger)
+
+	req, _ := http.NewRequest("POST", "/webhooks/", bytes.NewBuffer([]byte(
+		`{"id":"AB363360-632B-4643-B93F-0486B764E98D","url":"https://example.com/hook"}`)))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.Contains(response.Body.String(), `"secret":"`)
+	a.Contains(response.Body.String(), `"event_types":[]`)
+
+	req, _ = http.NewRequest("GET", "/webhooks/AB363360-632B-4643-B93F-0486B764E98D/deliveries", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusMovedPermanently, response.Code)
+
+	req, _ = http.NewRequest("GET", "/webhooks/AB363360-632B-4643-B93F-0486B764E98D/deliveries/", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"OK", "payload":null}`, response.Body.String())
+}
+
+func TestGetSubscriptionNotExists(t *testing.T) {
+	a := assert.New(t)
+	svc := NewService(newMemoryRepository(), http.DefaultClient, testGuard(), testPolicy)
+	handler := NewHTTPHandler(NewEndpoints(svc), testLogger)
+	req, _ := http.NewRequest("GET", "/webhooks/AB363360-632B-4643-B93F-0486B764E98D/", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{"result":"ERROR", "error":"subscription_not_exist"}`, response.Body.String())
+}
@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/risentveber/wallet-api/services/dberrors"
+)
+
+type Repository interface {
+	CreateSubscription(ctx context.Context, s Subscription) error
+	// For separation business logic errors from database errors
+	IsSubscriptionIDUsedError(err error) bool
+	GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, bool, error)
+	DeactivateSubscription(ctx context.Context, id uuid.UUID) error
+	GetActiveSubscriptions(ctx context.Context) ([]Subscription, error)
+	// gives customer of each account having one
+	GetAccountOwners(ctx context.Context, accountIDs []uuid.UUID) (map[uuid.UUID]uuid.UUID, error)
+	// creates deliveries skipping already existing ones
+	CreateDeliveries(ctx context.Context, deliveries []Delivery) error
+	// gives pending deliveries due at now and postpones them until leaseUntil,
+	// so they are not given to another dispatcher while being attempted
+	ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit uint) ([]Delivery, error)
+	UpdateDelivery(ctx context.Context, d Delivery) error
+	GetDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit uint) ([]Delivery, error)
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db}
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func (r repository) CreateSubscription(ctx context.Context, s Subscription) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO webhook_subscriptions(id, customer_id, url, event_types, secret, active)
+ VALUES ($1, $2, $3, $4, $5, $6)`, s.ID, s.CustomerID, s.URL, pq.Array(s.EventTypes), s.Secret, s.Active)
+
+	return err
+}
+
+func (r repository) IsSubscriptionIDUsedError(err error) bool {
+	return dberrors.IsUniqueViolation(err, "webhook_subscriptions_pkey")
+}
+
+const subscriptionColumns = `id, customer_id, url, event_types, secret, active, created_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(s scanner) (Subscription, error) {
+	var sub Subscription
+	err := s.Scan(&sub.ID, &sub.CustomerID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Secret, &sub.Active, &sub.CreatedAt)
+
+	return sub, err
+}
+
+func (r repository) GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, bool, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE id=$1`, id)
+	sub, err := scanSubscription(row)
+	switch err {
+	case sql.ErrNoRows:
+		return sub, false, nil
+	case nil:
+		return sub, true, nil
+	default:
+		return sub, false, err
+	}
+}
+
+func (r repository) DeactivateSubscription(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE webhook_subscriptions SET active = false WHERE id = $1`, id)
+
+	return err
+}
+
+func (r repository) GetActiveSubscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE active`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subscriptions []Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (r repository) GetAccountOwners(ctx context.Context, accountIDs []uuid.UUID) (map[uuid.UUID]uuid.UUID, error) {
+	ids := make([]string, len(accountIDs))
+	for i, id := range accountIDs {
+		ids[i] = id.String()
+	}
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, customer_id FROM accounts WHERE id = ANY($1::uuid[]) AND customer_id IS NOT NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	owners := make(map[uuid.UUID]uuid.UUID, len(accountIDs))
+	for rows.Next() {
+		var id, owner uuid.UUID
+		if err := rows.Scan(&id, &owner); err != nil {
+			return nil, err
+		}
+		owners[id] = owner
+	}
+
+	return owners, rows.Err()
+}
+
+func (r repository) CreateDeliveries(ctx context.Context, deliveries []Delivery) (err error) {
+	var tx *sql.Tx
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	for _, d := range deliveries {
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO webhook_deliveries(id, subscription_id, event_id, event_type, body, status, next_attempt_at)
+ VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO NOTHING`,
+			d.ID, d.SubscriptionID, d.EventID, d.EventType, []byte(d.Body), d.Status, d.NextAttemptAt)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, body, status, attempts,
+ last_status_code, last_error, next_attempt_at, created_at, updated_at`
+
+func scanDeliveries(rows *sql.Rows, limit uint) ([]Delivery, error) {
+	defer rows.Close()
+	deliveries := make([]Delivery, 0, limit)
+	for rows.Next() {
+		var d Delivery
+		var body []byte
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &body, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Body = body
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r repository) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit uint) ([]Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+UPDATE webhook_deliveries SET next_attempt_at = $1
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = 'PENDING' AND next_attempt_at <= $2
+	ORDER BY next_attempt_at LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING `+deliveryColumns, leaseUntil, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows, limit)
+}
+
+func (r repository) UpdateDelivery(ctx context.Context, d Delivery) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5, updated_at = now()
+WHERE id = $6`, d.Status, d.Attempts, d.LastStatusCode, d.LastError, d.NextAttemptAt, d.ID)
+
+	return err
+}
+
+func (r repository) GetDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit uint) ([]Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+deliveryColumns+` FROM webhook_deliveries
+WHERE subscription_id = $1 ORDER BY created_at DESC
+LIMIT $2`, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows, limit)
+}
@@ -0,0 +1,302 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+const (
+	dueBatchSize = 100
+	secretLength = 32
+	// delivery claimed by dispatcher is not claimed by another one during this period
+	claimLease = time.Minute
+	// response body is read partly only for keeping connection reusable
+	maxResponseBody = 4096
+)
+
+// RetryPolicy describes exponential backoff of failed deliveries.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// Delay before next attempt after given count of failed attempts.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+type service struct {
+	repo   Repository
+	client *http.Client
+	guard  AddressGuard
+	policy RetryPolicy
+}
+
+// NewService delivers webhooks by client, which should be guard.Client, guard rejects subscriptions
+// to internal addresses.
+func NewService(repo Repository, client *http.Client, guard AddressGuard, policy RetryPolicy) Service {
+	return service{repo: repo, client: client, guard: guard, policy: policy}
+}
+
+// Sign gives value of SignatureHeader for body signed by subscription secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryID is deterministic, so the same event is never scheduled twice for subscription.
+func DeliveryID(subscriptionID uuid.UUID, eventID int64) uuid.UUID {
+	return uuid.NewSHA1(subscriptionID, []byte(strconv.FormatInt(eventID, 10)))
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, secretLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func parseURL(rawURL string) (*url.URL, bool) {
+	u, err := url.Parse(rawURL)
+
+	return u, err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// accessible reports whether subscription may be accessed in the call context,
+// calls without principal are internal ones.
+func accessible(ctx context.Context, sub Subscription) bool {
+	p, ok := auth.FromContext(ctx)
+
+	return !ok || (p.HasCustomer() && sub.CustomerID != nil && *sub.CustomerID == p.CustomerID)
+}
+
+func (s service) CreateSubscription(ctx context.Context, o SubscriptionOrder) (Subscription, error) {
+	if o.ID == uuid.Nil {
+		return Subscription{}, ErrEmptySubscriptionID
+	}
+	u, ok := parseURL(o.URL)
+	if !ok {
+		return Subscription{}, ErrInvalidURL
+	}
+	if !s.guard.allowsHost(ctx, u.Hostname()) {
+		return Subscription{}, ErrURLNotAllowed
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return Subscription{}, err
+	}
+	if o.EventTypes == nil {
+		o.EventTypes = []string{}
+	}
+	sub := Subscription{ID: o.ID, URL: o.URL, EventTypes: o.EventTypes, Secret: secret, Active: true}
+	if p, ok := auth.FromContext(ctx); ok {
+		// subscription of no customer would get events of all accounts
+		if !p.HasCustomer() {
+			return Subscription{}, auth.ErrForbidden
+		}
+		sub.CustomerID = &p.CustomerID
+	}
+	err = s.repo.CreateSubscription(ctx, sub)
+	if s.repo.IsSubscriptionIDUsedError(err) {
+		// repeated creation returns the same subscription with the same secret
+		sub, _, err = s.repo.GetSubscription(ctx, o.ID)
+		if err == nil && !accessible(ctx, sub) {
+			return Subscription{}, ErrSubscriptionNotExists
+		}
+
+		return sub, err
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub.CreatedAt = time.Now().UTC()
+
+	return sub, nil
+}
+
+func (s service) GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	sub, ok, err := s.repo.GetSubscription(ctx, id)
+	if err != nil {
+		return sub, err
+	}
+	if !ok || !accessible(ctx, sub) {
+		return Subscription{}, ErrSubscriptionNotExists
+	}
+	sub.Secret = ""
+
+	return sub, nil
+}
+
+func (s service) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.GetSubscription(ctx, id); err != nil {
+		return err
+	}
+
+	return s.repo.DeactivateSubscription(ctx, id)
+}
+
+func (s service) GetDeliveries(ctx context.Context, subscriptionID uuid.UUID) ([]Delivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetDeliveries(ctx, subscriptionID, 100)
+}
+
+// ownersFor gives owners of accounts of events if any subscription is restricted by customer.
+func (s service) ownersFor(ctx context.Context, subscriptions []Subscription, events []outbox.Event) (map[uuid.UUID]uuid.UUID, error) {
+	restricted := false
+	for _, sub := range subscriptions {
+		restricted = restricted || sub.CustomerID != nil
+	}
+	if !restricted {
+		return nil, nil
+	}
+	var accountIDs []uuid.UUID
+	for _, e := range events {
+		accountIDs = append(accountIDs, e.AccountIDs...)
+	}
+
+	return s.repo.GetAccountOwners(ctx, accountIDs)
+}
+
+func (s service) Publish(ctx context.Context, events []outbox.Event) error {
+	subscriptions, err := s.repo.GetActiveSubscriptions(ctx)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+	owners, err := s.ownersFor(ctx, subscriptions, events)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var deliveries []Delivery
+	for _, e := range events {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subscriptions {
+			if !sub.Matches(e.Type) || !sub.MatchesOwners(e.AccountIDs, owners) {
+				continue
+			}
+			deliveries = append(deliveries, Delivery{
+				ID:             DeliveryID(sub.ID, e.ID),
+				SubscriptionID: sub.ID,
+				EventID:        e.ID,
+				EventType:      e.Type,
+				Body:           body,
+				Status:         Pending,
+				NextAttemptAt:  now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return s.repo.CreateDeliveries(ctx, deliveries)
+}
+
+// attempt sends signed delivery, returns status code if response received.
+func (s service) attempt(ctx context.Context, sub Subscription, d Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, d.Body))
+	req.Header.Set(EventTypeHeader, d.EventType)
+	req.Header.Set(DeliveryIDHeader, d.ID.String())
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxResponseBody))
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return res.StatusCode, fmt.Errorf("responded with status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+func (s service) deliver(ctx context.Context, sub Subscription, d Delivery, now time.Time) error {
+	d.Attempts++
+	if sub.Active {
+		d.LastStatusCode, d.LastError = 0, ""
+		statusCode, err := s.attempt(ctx, sub, d)
+		d.LastStatusCode = statusCode
+		if err == nil {
+			d.Status = Succeeded
+
+			return s.repo.UpdateDelivery(ctx, d)
+		}
+		d.LastError = err.Error()
+	} else {
+		d.Attempts = s.policy.MaxAttempts
+		d.LastError = "subscription deleted"
+	}
+	if d.Attempts >= s.policy.MaxAttempts {
+		d.Status = Dead
+	} else {
+		d.NextAttemptAt = now.Add(s.policy.Delay(d.Attempts))
+	}
+
+	return s.repo.UpdateDelivery(ctx, d)
+}
+
+func (s service) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	due, err := s.repo.ClaimDueDeliveries(ctx, now.UTC(), now.UTC().Add(claimLease), dueBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	subscriptions := make(map[uuid.UUID]Subscription)
+	var attempted int
+	for _, d := range due {
+		sub, ok := subscriptions[d.SubscriptionID]
+		if !ok {
+			sub, _, err = s.repo.GetSubscription(ctx, d.SubscriptionID)
+			if err != nil {
+				return attempted, err
+			}
+			subscriptions[d.SubscriptionID] = sub
+		}
+		if err = s.deliver(ctx, sub, d, now.UTC()); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+
+	return attempted, nil
+}
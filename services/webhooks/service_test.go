@@ -0,0 +1,313 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/risentveber/wallet-api/services/auth"
+	"github.com/risentveber/wallet-api/services/outbox"
+)
+
+var errIDUsed = errors.New("id used")
+
+// memoryRepository keeps state in maps to test delivery logic without db.
+type memoryRepository struct {
+	mu            sync.Mutex
+	subscriptions map[uuid.UUID]Subscription
+	deliveries    map[uuid.UUID]Delivery
+	owners        map[uuid.UUID]uuid.UUID
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		subscriptions: make(map[uuid.UUID]Subscription),
+		deliveries:    make(map[uuid.UUID]Delivery),
+		owners:        make(map[uuid.UUID]uuid.UUID),
+	}
+}
+
+func (r *memoryRepository) CreateSubscription(_ context.Context, s Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subscriptions[s.ID]; ok {
+		return errIDUsed
+	}
+	r.subscriptions[s.ID] = s
+	return nil
+}
+
+func (r *memoryRepository) IsSubscriptionIDUsedError(err error) bool {
+	return err == errIDUsed
+}
+
+func (r *memoryRepository) GetSubscription(_ context.Context, id uuid.UUID) (Subscription, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subscriptions[id]
+	return s, ok, nil
+}
+
+func (r *memoryRepository) DeactivateSubscription(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.subscriptions[id]
+	s.Active = false
+	r.subscriptions[id] = s
+	return nil
+}
+
+func (r *memoryRepository) GetActiveSubscriptions(_ context.Context) ([]Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []Subscription
+	for _, s := range r.subscriptions {
+		if s.Active {
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+func (r *memoryRepository) GetAccountOwners(_ context.Context, accountIDs []uuid.UUID) (map[uuid.UUID]uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make(map[uuid.UUID]uuid.UUID)
+	for _, id := range accountIDs {
+		if owner, ok := r.owners[id]; ok {
+			res[id] = owner
+		}
+	}
+	return res, nil
+}
+
+func (r *memoryRepository) CreateDeliveries(_ context.Context, deliveries []Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range deliveries {
+		if _, ok := r.deliveries[d.ID]; !ok {
+			r.deliveries[d.ID] = d
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) ClaimDueDeliveries(_ context.Context, now, leaseUntil time.Time, limit uint) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []Delivery
+	for id, d := range r.deliveries {
+		if d.Status == Pending && !d.NextAttemptAt.After(now) && uint(len(res)) < limit {
+			d.NextAttemptAt = leaseUntil
+			r.deliveries[id] = d
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+func (r *memoryRepository) UpdateDelivery(_ context.Context, d Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[d.ID] = d
+	return nil
+}
+
+func (r *memoryRepository) GetDeliveries(_ context.Context, subscriptionID uuid.UUID, limit uint) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []Delivery
+	for _, d := range r.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+var testPolicy = RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute}
+
+type receiver struct {
+	mu        sync.Mutex
+	status    int
+	bodies    [][]byte
+	signature []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.bodies = append(rc.bodies, body)
+	rc.signature = append(rc.signature, r.Header.Get(SignatureHeader))
+	w.WriteHeader(rc.status)
+}
+
+func prepare(t *testing.T, status int) (Service, *memoryRepository, *receiver, Subscription, func()) {
+	rc := &receiver{status: status}
+	server := httptest.NewServer(rc)
+	repo := newMemoryRepository()
+	svc := NewService(repo, server.Client(), testGuard(), testPolicy)
+	sub, err := svc.CreateSubscription(context.Background(), SubscriptionOrder{
+		ID: uuid.New(), URL: server.URL, EventTypes: []string{"TransferCreated"},
+	})
+	assert.NoError(t, err)
+	return svc, repo, rc, sub, server.Close
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(time.Second, testPolicy.Delay(1))
+	a.Equal(2*time.Second, testPolicy.Delay(2))
+	a.Equal(8*time.Second, testPolicy.Delay(4))
+	a.Equal(time.Minute, testPolicy.Delay(100))
+}
+
+func TestService_CreateSubscription_validate(t *testing.T) {
+	a := assert.New(t)
+	svc := NewService(newMemoryRepository(), http.DefaultClient, testGuard(), testPolicy)
+	_, err := svc.CreateSubscription(context.Background(), SubscriptionOrder{URL: "http://example.com"})
+	a.Equal(ErrEmptySubscriptionID, err)
+	_, err = svc.CreateSubscription(context.Background(), SubscriptionOrder{ID: uuid.New(), URL: "example.com"})
+	a.Equal(ErrInvalidURL, err)
+	for _, url := range []string{
+		"http://169.254.169.254/latest/meta-data/", "https://[::1]:8080/", "http://192.168.1.1/hook",
+		"http://internal.example/hook", "http://unresolvable.example/hook",
+	} {
+		_, err = svc.CreateSubscription(context.Background(), SubscriptionOrder{ID: uuid.New(), URL: url})
+		a.Equal(ErrURLNotAllowed, err, url)
+	}
+}
+
+func TestService_CreateSubscription_Idempotent(t *testing.T) {
+	a := assert.New(t)
+	svc, _, _, sub, close := prepare(t, http.StatusOK)
+	defer close()
+	a.NotEmpty(sub.Secret)
+	again, err := svc.CreateSubscription(context.Background(), SubscriptionOrder{ID: sub.ID, URL: "http://other"})
+	a.NoError(err)
+	a.Equal(sub.Secret, again.Secret, "the same subscription returned")
+	fetched, err := svc.GetSubscription(context.Background(), sub.ID)
+	a.NoError(err)
+	a.Empty(fetched.Secret, "secret is not shown after creation")
+}
+
+func TestService_DeliverSigned(t *testing.T) {
+	a := assert.New(t)
+	svc, _, rc, sub, close := prepare(t, http.StatusNoContent)
+	defer close()
+
+	err := svc.Publish(context.Background(), []outbox.Event{
+		{ID: 1, Type: "TransferCreated", Payload: []byte(`{}`)},
+		{ID: 2, Type: "BalanceChanged", Payload: []byte(`{}`)},
+	})
+	a.NoError(err)
+	attempted, err := svc.DeliverDue(context.Background(), time.Now())
+	a.NoError(err)
+	a.Equal(1, attempted, "only subscribed event type delivered")
+	a.Equal(1, len(rc.bodies))
+	a.Equal(Sign(sub.Secret, rc.bodies[0]), rc.signature[0])
+
+	deliveries, err := svc.GetDeliveries(context.Background(), sub.ID)
+	a.NoError(err)
+	a.Equal(1, len(deliveries))
+	a.Equal(Succeeded, deliveries[0].Status)
+	a.Equal(1, deliveries[0].Attempts)
+	a.Equal(http.StatusNoContent, deliveries[0].LastStatusCode)
+
+	a.NoError(svc.Publish(context.Background(), []outbox.Event{{ID: 1, Type: "TransferCreated"}}))
+	attempted, err = svc.DeliverDue(context.Background(), time.Now())
+	a.NoError(err)
+	a.Equal(0, attempted, "republished event is not delivered twice")
+}
+
+func TestService_RetryAndDeadLetter(t *testing.T) {
+	a := assert.New(t)
+	svc, _, rc, sub, close := prepare(t, http.StatusInternalServerError)
+	defer close()
+
+	a.NoError(svc.Publish(context.Background(), []outbox.Event{{ID: 1, Type: "TransferCreated"}}))
+	now := time.Now()
+	attempted, err := svc.DeliverDue(context.Background(), now)
+	a.NoError(err)
+	a.Equal(1, attempted)
+	attempted, err = svc.DeliverDue(context.Background(), now)
+	a.NoError(err)
+	a.Equal(0, attempted, "backoff before next attempt")
+
+	deliveries, _ := svc.GetDeliveries(context.Background(), sub.ID)
+	a.Equal(Pending, deliveries[0].Status)
+	a.Equal("responded with status 500", deliveries[0].LastError)
+	a.Equal(now.Add(time.Second).UTC(), deliveries[0].NextAttemptAt)
+
+	for i := 0; i < testPolicy.MaxAttempts; i++ {
+		now = now.Add(time.Hour)
+		_, err = svc.DeliverDue(context.Background(), now)
+		a.NoError(err)
+	}
+	deliveries, _ = svc.GetDeliveries(context.Background(), sub.ID)
+	a.Equal(Dead, deliveries[0].Status)
+	a.Equal(testPolicy.MaxAttempts, deliveries[0].Attempts)
+	a.Equal(testPolicy.MaxAttempts, len(rc.bodies), "no attempts after dead lettered")
+}
+
+func TestService_DeletedSubscription(t *testing.T) {
+	a := assert.New(t)
+	svc, _, rc, sub, close := prepare(t, http.StatusOK)
+	defer close()
+
+	a.NoError(svc.Publish(context.Background(), []outbox.Event{{ID: 1, Type: "TransferCreated"}}))
+	a.NoError(svc.DeleteSubscription(context.Background(), sub.ID))
+	_, err := svc.DeliverDue(context.Background(), time.Now())
+	a.NoError(err)
+	a.Equal(0, len(rc.bodies))
+	deliveries, _ := svc.GetDeliveries(context.Background(), sub.ID)
+	a.Equal(Dead, deliveries[0].Status)
+	a.Equal(ErrSubscriptionNotExists, svc.DeleteSubscription(context.Background(), uuid.New()))
+}
+
+func TestService_CustomerIsolation(t *testing.T) {
+	a := assert.New(t)
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	repo := newMemoryRepository()
+	svc := NewService(repo, server.Client(), testGuard(), testPolicy)
+	owner, other := uuid.New(), uuid.New()
+	ownerAccount, otherAccount := uuid.New(), uuid.New()
+	repo.owners[ownerAccount] = owner
+	repo.owners[otherAccount] = other
+	ctx := auth.NewContext(context.Background(), auth.Principal{ClientID: "client", CustomerID: owner})
+	otherCtx := auth.NewContext(context.Background(), auth.Principal{ClientID: "other", CustomerID: other})
+
+	sub, err := svc.CreateSubscription(ctx, SubscriptionOrder{ID: uuid.New(), URL: server.URL})
+	a.NoError(err)
+	a.Equal(owner, *sub.CustomerID)
+	_, err = svc.GetSubscription(otherCtx, sub.ID)
+	a.Equal(ErrSubscriptionNotExists, err)
+	_, err = svc.GetDeliveries(otherCtx, sub.ID)
+	a.Equal(ErrSubscriptionNotExists, err)
+	a.Equal(ErrSubscriptionNotExists, svc.DeleteSubscription(otherCtx, sub.ID))
+	_, err = svc.CreateSubscription(otherCtx, SubscriptionOrder{ID: sub.ID, URL: server.URL})
+	a.Equal(ErrSubscriptionNotExists, err, "secret is not revealed to other customer")
+
+	a.NoError(svc.Publish(context.Background(), []outbox.Event{
+		{ID: 1, Type: "TransferCreated", AccountIDs: []uuid.UUID{otherAccount, ownerAccount}},
+		{ID: 2, Type: "BalanceChanged", AccountIDs: []uuid.UUID{otherAccount}},
+		{ID: 3, Type: "BalanceChanged", AccountIDs: []uuid.UUID{ownerAccount}},
+	}))
+	deliveries, err := svc.GetDeliveries(ctx, sub.ID)
+	a.NoError(err)
+	a.Equal(2, len(deliveries), "only events of customer accounts")
+	for _, d := range deliveries {
+		a.NotEqual(int64(2), d.EventID)
+	}
+}